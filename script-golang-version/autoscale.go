@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/googleapis/gax-go/v2/apierror"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+)
+
+// How often the auto-scaler re-evaluates the worker count
+const autoScaleInterval = 15 * time.Second
+
+// Embedding API outcomes observed by the auto-scaler
+var (
+	embeddingRequests    atomic.Int64
+	embeddingRateLimited atomic.Int64
+)
+
+// Check whether an API error is a rate-limit (429 / RESOURCE_EXHAUSTED) response
+func isRateLimited(err error) bool {
+	var openAIErr *openAIError
+	if errors.As(err, &openAIErr) {
+		return openAIErr.StatusCode == http.StatusTooManyRequests
+	}
+	var apiErr *apierror.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.HTTPCode() == http.StatusTooManyRequests || apiErr.GRPCStatus().Code() == codes.ResourceExhausted
+}
+
+// Process the source with a pool that scales between minWorkers and maxWorkers.
+// A single scanner feeds a shared queue; workers are added while the embedding
+// API stays healthy and removed when rate limiting persists.
+func processPropertiesAutoScaled(
+	ctx context.Context,
+	store propertyStore,
+	aiClient *genai.Client,
+	scanRange idRange,
+	minWorkers int,
+	maxWorkers int,
+) (workerStats, error) {
+	if err := acquireCursorSlot(ctx, 0); err != nil {
+		return workerStats{}, err
+	}
+	defer releaseCursorSlot()
+
+	cursor, err := openSourceRangeCursor(ctx, store.Source(), scanRange, nil)
+	if err != nil {
+		return workerStats{}, fmt.Errorf("error finding properties: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	// With EXISTENCE_CHECK=preload, stored IDs are loaded once and shared by
+	// all workers, which only read them
+	var preloaded map[primitive.ObjectID]string
+	if existenceCheckMode == existenceCheckPreload && outputFile == "" {
+		preloaded, err = preloadExisting(ctx, store.Target(), scanRange)
+		if err != nil {
+			return workerStats{}, fmt.Errorf("error preloading existing properties: %w", err)
+		}
+		slog.Info("Auto-scaling: preloaded existing properties", "existing_count", len(preloaded))
+	}
+
+	// Scan this shard's range of the source into the shared queue
+	queue := make(chan Property, maxWorkers*2)
+	scanErr := make(chan error, 1)
+	go func() {
+		defer close(queue)
+		for cursor.Next(ctx) {
+			var property Property
+			if err := decodeSourceProperty(cursor, &property); err != nil {
+				slog.Error("Scanner failed to decode a property", "error", err)
+				continue
+			}
+			select {
+			case queue <- property:
+			case <-ctx.Done():
+				scanErr <- ctx.Err()
+				return
+			}
+		}
+		scanErr <- cursor.Err()
+	}()
+
+	// Each worker reports its counts when it exits
+	exited := make(chan workerStats)
+	shrink := make(chan struct{})
+	active := 0
+	nextID := 0
+
+	startWorker := func() {
+		nextID++
+		active++
+		worker := newPropertyWorker(nextID, store, aiClient)
+		worker.preloaded = preloaded
+		go func() {
+			defer func() {
+				worker.flush(ctx)
+				exited <- worker.stats()
+			}()
+			for {
+				select {
+				case property, ok := <-queue:
+					if !ok {
+						return
+					}
+					worker.process(ctx, property)
+				case <-shrink:
+					worker.logger.Info("Stopping to scale down")
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < minWorkers; i++ {
+		startWorker()
+	}
+	slog.Info("Auto-scaling workers", "min_workers", minWorkers, "max_workers", maxWorkers, "workers", active)
+
+	ticker := time.NewTicker(autoScaleInterval)
+	defer ticker.Stop()
+
+	// Adjust the pool size from rate-limit feedback until every worker has exited
+	var stats workerStats
+	limitedIntervals := 0
+	var lastRequests, lastLimited int64
+	for active > 0 {
+		select {
+		case done := <-exited:
+			active--
+			stats.add(done)
+			continue
+		case <-ticker.C:
+		}
+
+		requests := embeddingRequests.Load() - lastRequests
+		limited := embeddingRateLimited.Load() - lastLimited
+		lastRequests += requests
+		lastLimited += limited
+
+		if limited > 0 {
+			limitedIntervals++
+		} else {
+			limitedIntervals = 0
+		}
+
+		switch {
+		case limitedIntervals >= 2 && active > minWorkers:
+			select {
+			case shrink <- struct{}{}:
+			case done := <-exited:
+				stats.add(done)
+			}
+			active--
+			limitedIntervals = 0
+		case limited == 0 && requests > 0 && active < maxWorkers:
+			startWorker()
+		}
+		slog.Info("Auto-scaling: adjusted workers", "workers", active, "interval_ms", autoScaleInterval.Milliseconds(),
+			"embedding_requests", requests, "rate_limited", limited)
+	}
+
+	if err := <-scanErr; err != nil {
+		return stats, fmt.Errorf("cursor error: %w", err)
+	}
+	return stats, nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// How often the checkpoint file is rewritten during a run
+const checkpointInterval = 10 * time.Second
+
+// Path of the checkpoint file (checkpointing disabled when empty)
+var checkpointFile string
+
+// checkpointState is the content of the checkpoint file
+type checkpointState struct {
+	Shard       int                `json:"shard"`
+	TotalShards int                `json:"total_shards"`
+	Workers     []workerCheckpoint `json:"workers"`
+}
+
+// workerCheckpoint is a worker's _id range and the last property it finished.
+// Empty ids are open bounds or, for LastID, a worker that hasn't finished any.
+type workerCheckpoint struct {
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+	LastID string `json:"last_id,omitempty"`
+}
+
+// checkpointer tracks worker progress and periodically writes it to disk
+type checkpointer struct {
+	mu    sync.Mutex
+	path  string
+	state checkpointState
+	dirty bool
+}
+
+// Progress tracker shared by all workers, nil when checkpointing is disabled
+var checkpoints *checkpointer
+
+// Load a checkpoint file, returning nil when it doesn't exist
+func loadCheckpoint(path string) (*checkpointState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading checkpoint: %w", err)
+	}
+	var state checkpointState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("error parsing checkpoint %s: %w", path, err)
+	}
+	return &state, nil
+}
+
+// Turn a loaded checkpoint back into worker ranges that start after each
+// worker's last finished property. The checkpoint must match this run's
+// shard and worker count, since the ranges were split for those.
+func (s *checkpointState) resumeRanges(workers int) ([]idRange, error) {
+	if s.Shard != shardIndex || s.TotalShards != totalShards {
+		return nil, fmt.Errorf("checkpoint is for shard %d of %d, not %d of %d", s.Shard, s.TotalShards, shardIndex, totalShards)
+	}
+	if len(s.Workers) != workers {
+		return nil, fmt.Errorf("checkpoint was written by %d workers; run with -workers %d or delete it", len(s.Workers), len(s.Workers))
+	}
+
+	ranges := make([]idRange, workers)
+	for i, worker := range s.Workers {
+		var err error
+		if ranges[i].from, err = parseCheckpointID(worker.From); err != nil {
+			return nil, err
+		}
+		if ranges[i].to, err = parseCheckpointID(worker.To); err != nil {
+			return nil, err
+		}
+		if ranges[i].after, err = parseCheckpointID(worker.LastID); err != nil {
+			return nil, err
+		}
+	}
+	return ranges, nil
+}
+
+// Move each worker's last finished property forward to the one stored with
+// its latest transactional batch, which the file may not have caught up with
+func (s *checkpointState) applyStored(stored map[int]primitive.ObjectID) error {
+	for workerID, id := range stored {
+		if workerID < 1 || workerID > len(s.Workers) {
+			return fmt.Errorf("stored checkpoint for worker %d, but the checkpoint has %d workers", workerID, len(s.Workers))
+		}
+		last, err := parseCheckpointID(s.Workers[workerID-1].LastID)
+		if err != nil {
+			return err
+		}
+		if bytes.Compare(id[:], last[:]) > 0 {
+			s.Workers[workerID-1].LastID = id.Hex()
+		}
+	}
+	return nil
+}
+
+// Parse an ObjectId stored in the checkpoint, where empty means unset
+func parseCheckpointID(hex string) (primitive.ObjectID, error) {
+	if hex == "" {
+		return primitive.NilObjectID, nil
+	}
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("invalid _id %q in checkpoint", hex)
+	}
+	return id, nil
+}
+
+// Format an ObjectId for the checkpoint, where the zero id is left empty
+func formatCheckpointID(id primitive.ObjectID) string {
+	if id.IsZero() {
+		return ""
+	}
+	return id.Hex()
+}
+
+// Create a tracker for the given worker ranges
+func newCheckpointer(path string, ranges []idRange) *checkpointer {
+	c := &checkpointer{
+		path: path,
+		state: checkpointState{
+			Shard:       shardIndex,
+			TotalShards: totalShards,
+			Workers:     make([]workerCheckpoint, len(ranges)),
+		},
+		dirty: true,
+	}
+	for i, r := range ranges {
+		c.state.Workers[i] = workerCheckpoint{
+			From:   formatCheckpointID(r.from),
+			To:     formatCheckpointID(r.to),
+			LastID: formatCheckpointID(r.after),
+		}
+	}
+	return c
+}
+
+// Record that a worker has finished every property up to and including id
+func (c *checkpointer) record(workerID int, id primitive.ObjectID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state.Workers[workerID-1].LastID = id.Hex()
+	c.dirty = true
+}
+
+// Write the checkpoint atomically if it changed since the last write
+func (c *checkpointer) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	data, err := json.MarshalIndent(c.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(c.path, data); err != nil {
+		return fmt.Errorf("error writing checkpoint: %w", err)
+	}
+	c.dirty = false
+	return nil
+}
+
+// Write a file through a temporary file and a rename, so readers never see
+// it half written
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Write the checkpoint periodically until the context is done
+func (c *checkpointer) run(ctx context.Context) {
+	ticker := time.NewTicker(checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.save(); err != nil {
+				slog.Warn("Error saving checkpoint", "error", err)
+			}
+		}
+	}
+}
+
+// Delete the checkpoint once the run has completed
+func (c *checkpointer) remove() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.Remove(c.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCheckpointApplyStored(t *testing.T) {
+	older, _ := primitive.ObjectIDFromHex("65a1b2c3d4e5f60718293a4b")
+	newer, _ := primitive.ObjectIDFromHex("65a1b2c3d4e5f60718293a4c")
+
+	state := checkpointState{Workers: []workerCheckpoint{
+		{LastID: older.Hex()},
+		{LastID: newer.Hex()},
+		{},
+	}}
+	err := state.applyStored(map[int]primitive.ObjectID{1: newer, 2: older, 3: older})
+	if err != nil {
+		t.Fatalf("applyStored: %v", err)
+	}
+	want := []string{newer.Hex(), newer.Hex(), older.Hex()}
+	for i, worker := range state.Workers {
+		if worker.LastID != want[i] {
+			t.Errorf("worker %d LastID = %s, want %s", i+1, worker.LastID, want[i])
+		}
+	}
+
+	if err := state.applyStored(map[int]primitive.ObjectID{4: newer}); err == nil {
+		t.Error("a stored checkpoint for an unknown worker was accepted")
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Price of embedding 1,000 tokens, for cost estimates (only tokens are reported when 0)
+var embedCostPer1K float64
+
+// Estimated tokens sent to the embedding API across all workers, and the
+// tokens a dry run would have sent
+var (
+	embeddedTokens atomic.Int64
+	dryRunTokens   atomic.Int64
+)
+
+// Estimate the tokens of texts from their length, as EMBED_MAX_TOKENS does
+func estimateTextsTokens(texts []string) int64 {
+	var tokens int64
+	for _, text := range texts {
+		tokens += int64(estimateTokens(text))
+	}
+	return tokens
+}
+
+// Describe a token count with its cost at EMBED_COST_PER_1K
+func formatTokenCost(tokens int64) string {
+	if embedCostPer1K <= 0 {
+		return fmt.Sprintf("~%d tokens", tokens)
+	}
+	return fmt.Sprintf("~%d tokens, ~$%.4f at $%g per 1K tokens", tokens, float64(tokens)/1000*embedCostPer1K, embedCostPer1K)
+}
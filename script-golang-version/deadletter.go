@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection failed properties are recorded in for a later -retry-failures
+// run (not recorded when empty)
+var failuresCollection string
+
+// Re-embed only the properties recorded in FAILURES_COLLECTION
+var retryFailures bool
+
+// Step a recorded property failed at
+const (
+	failureStageEmbedding = "embedding"
+	failureStageInsert    = "insert"
+)
+
+// Record properties that failed, keyed by property ID. A property failing
+// again keeps its entry, with the latest error and one more attempt.
+func recordFailedProperties(ctx context.Context, failures propertyCollection, stage string, cause error, ids []primitive.ObjectID) error {
+	now := time.Now()
+	for _, id := range ids {
+		_, err := failures.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+			"$set": bson.M{
+				"source_db":         dbName,
+				"source_collection": sourceCollection,
+				"stage":             stage,
+				"error":             cause.Error(),
+				"failed_at":         now,
+			},
+			"$inc": bson.M{"attempts": 1},
+		}, options.Update().SetUpsert(true))
+		if err != nil {
+			return fmt.Errorf("error recording failed property %s: %w", id.Hex(), err)
+		}
+	}
+	return nil
+}
+
+// Load the IDs of the properties recorded as failed for the configured source
+func loadFailedPropertyIDs(ctx context.Context, failures propertyCollection) ([]primitive.ObjectID, error) {
+	cursor, err := failures.Find(ctx, bson.M{"source_db": dbName, "source_collection": sourceCollection},
+		options.Find().SetProjection(bson.M{"_id": 1}).SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("error reading failed properties: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var ids []primitive.ObjectID
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("error decoding failed property: %w", err)
+		}
+		ids = append(ids, doc.ID)
+	}
+	return ids, cursor.Err()
+}
+
+// Delete the recorded failures of retried properties that are stored now.
+// Entries recorded again since the retry started failed again and are kept.
+func removeRecoveredFailures(ctx context.Context, store propertyStore, ids []primitive.ObjectID, retryStart time.Time) (int64, error) {
+	var removed int64
+	for start := 0; start < len(ids); start += 1000 {
+		chunk := ids[start:min(start+1000, len(ids))]
+		existing, err := lookupExisting(ctx, store.Target(), chunk)
+		if err != nil {
+			return removed, fmt.Errorf("error checking retried properties: %w", err)
+		}
+		stored := make([]primitive.ObjectID, 0, len(existing))
+		for id := range existing {
+			stored = append(stored, id)
+		}
+		if len(stored) == 0 {
+			continue
+		}
+		result, err := store.Failures().DeleteMany(ctx, bson.M{
+			"_id":       bson.M{"$in": stored},
+			"failed_at": bson.M{"$lt": retryStart},
+		})
+		if err != nil {
+			return removed, fmt.Errorf("error removing recovered failures: %w", err)
+		}
+		removed += result.DeletedCount
+	}
+	return removed, nil
+}
+
+// The IDs of the documents a failed insertBatch didn't write, which are all
+// of them unless the error lists the documents that failed
+func failedInsertIDs(err error, ids []primitive.ObjectID) []primitive.ObjectID {
+	var bulkErr mongo.BulkWriteException
+	if useTransactions || !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil || len(bulkErr.WriteErrors) == 0 {
+		return ids
+	}
+	failed := make([]primitive.ObjectID, 0, len(bulkErr.WriteErrors))
+	for _, writeErr := range bulkErr.WriteErrors {
+		if writeErr.Index >= 0 && writeErr.Index < len(ids) {
+			failed = append(failed, ids[writeErr.Index])
+		}
+	}
+	return failed
+}
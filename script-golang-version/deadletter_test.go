@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestFailedInsertIDs(t *testing.T) {
+	defer func(transactions bool) { useTransactions = transactions }(useTransactions)
+	ids := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()}
+	writeErrors := func(indexes ...int) mongo.BulkWriteException {
+		var bulkErr mongo.BulkWriteException
+		for _, index := range indexes {
+			bulkErr.WriteErrors = append(bulkErr.WriteErrors, mongo.BulkWriteError{
+				WriteError: mongo.WriteError{Index: index, Code: 11000},
+			})
+		}
+		return bulkErr
+	}
+	withWriteConcern := writeErrors(1)
+	withWriteConcern.WriteConcernError = &mongo.WriteConcernError{Code: 64}
+
+	tests := []struct {
+		name         string
+		err          error
+		transactions bool
+		want         []primitive.ObjectID
+	}{
+		{name: "plain error fails the batch", err: errors.New("connection reset"), want: ids},
+		{name: "write errors fail their documents", err: writeErrors(0, 2), want: []primitive.ObjectID{ids[0], ids[2]}},
+		{name: "wrapped write errors", err: fmt.Errorf("insert: %w", writeErrors(1)), want: ids[1:2]},
+		{name: "out of range index is ignored", err: writeErrors(1, 5), want: ids[1:2]},
+		{name: "write concern error fails the batch", err: withWriteConcern, want: ids},
+		{name: "no write errors fails the batch", err: writeErrors(), want: ids},
+		{name: "transaction fails the batch", err: writeErrors(1), transactions: true, want: ids},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTransactions = tt.transactions
+			if got := failedInsertIDs(tt.err, ids); !slices.Equal(got, tt.want) {
+				t.Errorf("failedInsertIDs = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestCreatePropertyDescription(t *testing.T) {
+	priceRangeMode = priceRangeOff
+
+	tests := []struct {
+		name     string
+		property Property
+		want     []string // lines that must be present
+		notWant  []string // substrings that must be absent
+	}{
+		{
+			name: "rent listing shows the rent price",
+			property: Property{
+				Ad:          &Ad{Title: "Flat", TransactionType: "RENT"},
+				RentPrice:   2500,
+				AskingPrice: 500000,
+			},
+			want:    []string{"Title: Flat", "Transaction Type: RENT", "Price: Rent $2500.00"},
+			notWant: []string{"Sale"},
+		},
+		{
+			name: "sale listing shows the asking price",
+			property: Property{
+				Ad:          &Ad{Title: "House", TransactionType: "SALE"},
+				RentPrice:   2500,
+				AskingPrice: 500000,
+			},
+			want:    []string{"Price: Sale $500000.00"},
+			notWant: []string{"Rent $"},
+		},
+		{
+			name:     "nil ad, company and agent",
+			property: Property{City: "Curitiba", Bedrooms: 2},
+			want:     []string{"Location: Curitiba", "Bedrooms: 2"},
+			notWant:  []string{"Title", "Description:", "Transaction Type"},
+		},
+		{
+			name:     "empty features are left out",
+			property: Property{Features: []string{}},
+			notWant:  []string{"Features"},
+		},
+		{
+			name:     "features are joined",
+			property: Property{Features: []string{"pool", "gym"}},
+			want:     []string{"Features: pool, gym"},
+		},
+		{
+			name:     "exclusive listing",
+			property: Property{IsExclusive: true},
+			want:     []string{"Exclusive: Yes"},
+		},
+		{
+			name:     "non-exclusive listing",
+			property: Property{},
+			want:     []string{"Exclusive: No"},
+		},
+		{
+			name:     "empty location is left out",
+			property: Property{PropertyType: "APARTMENT"},
+			want:     []string{"Property Type: APARTMENT"},
+			notWant:  []string{"Location"},
+		},
+		{
+			name:     "partial location has no stray commas",
+			property: Property{City: "Curitiba", State: "PR"},
+			want:     []string{"Location: Curitiba, PR"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			description := createPropertyDescription(&tt.property)
+			lines := strings.Split(description, "\n")
+			for _, want := range tt.want {
+				found := false
+				for _, line := range lines {
+					if line == want {
+						found = true
+						break
+					}
+				}
+				if !found {
+					t.Errorf("missing line %q in:\n%s", want, description)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(description, notWant) {
+					t.Errorf("unexpected %q in:\n%s", notWant, description)
+				}
+			}
+		})
+	}
+}
+
+// A property embeds its EMBED_SOURCE_FIELD text, falling back to the generated description
+func TestEmbeddingText(t *testing.T) {
+	priceRangeMode = priceRangeOff
+	property := Property{City: "Curitiba", Bedrooms: 2}
+	description := createPropertyDescription(&property)
+
+	tests := []struct {
+		name      string
+		embedText string
+		want      string
+	}{
+		{name: "source field", embedText: "Sunny flat near the park", want: "Sunny flat near the park"},
+		{name: "source field is trimmed", embedText: "  Sunny flat\n", want: "Sunny flat"},
+		{name: "missing field falls back", embedText: "", want: description},
+		{name: "blank field falls back", embedText: " \t\n", want: description},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			property := property
+			property.EmbedText = tt.embedText
+			if got := embeddingText(&property); got != tt.want {
+				t.Errorf("embeddingText = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFieldCaps(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    map[string]int
+		wantErr bool
+	}{
+		{value: "", want: map[string]int{}},
+		{value: "description=1000, title=200", want: map[string]int{"description": 1000, "title": 200}},
+		{value: " features = 50 ", want: map[string]int{"features": 50}},
+		{value: "price=10", wantErr: true},
+		{value: "title", wantErr: true},
+		{value: "title=0", wantErr: true},
+		{value: "title=many", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseFieldCaps(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFieldCaps error = %v, want error %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && !maps.Equal(got, tt.want) {
+				t.Errorf("parseFieldCaps = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCapField(t *testing.T) {
+	defer func(caps map[string]int) { fieldCaps = caps }(fieldCaps)
+	fieldCaps = map[string]int{"title": 5, "description": 8}
+
+	tests := []struct {
+		name  string
+		field string
+		value string
+		want  string
+	}{
+		{name: "uncapped field", field: "building", value: "Edifício Central", want: "Edifício Central"},
+		{name: "within the cap", field: "title", value: "Casa", want: "Casa"},
+		{name: "at the cap", field: "title", value: "Casas", want: "Casas"},
+		{name: "cut by runes", field: "title", value: "Sótão amplo", want: "Sótão"},
+		{name: "trailing space trimmed", field: "description", value: "Sunny   flat", want: "Sunny"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := capField(tt.field, tt.value); got != tt.want {
+				t.Errorf("capField(%q, %q) = %q, want %q", tt.field, tt.value, got, tt.want)
+			}
+		})
+	}
+
+	// The built-in description applies the caps
+	priceRangeMode = priceRangeOff
+	description := createPropertyDescription(&Property{Ad: &Ad{Title: "Cobertura duplex", Description: "Vista para o parque"}})
+	if !strings.Contains(description, "Title: Cober\n") || strings.Contains(description, "parque") {
+		t.Errorf("description ignores the caps:\n%s", description)
+	}
+}
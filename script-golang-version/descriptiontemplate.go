@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// Description template file and the template loaded from it (nil uses the built-in layout)
+var (
+	descriptionTemplateFile string
+	descriptionTemplate     *template.Template
+)
+
+// Helpers available to description templates
+var descriptionTemplateFuncs = template.FuncMap{
+	// Apply a DESCRIPTION_FIELD_CAPS limit, e.g. {{cap "title" .Ad.Title}}
+	"cap": capField,
+	// Render a boolean as Yes or No
+	"yesNo": boolToYesNo,
+	// Join a list, e.g. {{join .Features ", "}}
+	"join": func(values []string, sep string) string { return strings.Join(values, sep) },
+	// Captions or alt texts of the property's image documents
+	"captions": imageCaptions,
+	// Value of an optional number such as .CondoFee, inside a with or if on it
+	"deref": func(value *float64) float64 { return *value },
+	// Whether the listing is for rent rather than sale
+	"isRent": isRentListing,
+	// Region, city and state joined without the empty ones, e.g. {{location .Property}}
+	"location": propertyLocation,
+	// Price range line for a sale or rent price, e.g. {{priceRange "Sale" .AskingPrice}},
+	// labeled in the DESCRIPTION_LOCALE
+	"priceRange": func(kind string, price float64) (string, error) {
+		switch kind {
+		case "Rent":
+			return priceRangeLine(labels.Rent, price, priceRangeRentStep), nil
+		case "Sale":
+			return priceRangeLine(labels.Sale, price, priceRangeSaleStep), nil
+		}
+		return "", fmt.Errorf("priceRange: unknown kind %q, must be Rent or Sale", kind)
+	},
+}
+
+// descriptionTemplateData is what description templates are executed with:
+// the fields of the property, and its labels in the DESCRIPTION_LOCALE as .Labels
+type descriptionTemplateData struct {
+	*Property
+	Labels descriptionLabels
+}
+
+// Parse a description template file; the template is executed with descriptionTemplateData
+func loadDescriptionTemplate(path string) (*template.Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	tmpl, err := template.New("description").Funcs(descriptionTemplateFuncs).Option("missingkey=zero").Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	return tmpl, nil
+}
+
+// Render the description from the template, dropping blank lines left by
+// fields the property doesn't have
+func renderDescriptionTemplate(tmpl *template.Template, property *Property) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, descriptionTemplateData{Property: property, Labels: labels}); err != nil {
+		return "", err
+	}
+	var lines []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
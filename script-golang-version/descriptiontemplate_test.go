@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"text/template"
+)
+
+// description.tmpl reproduces the built-in layout in every locale, including
+// properties missing some of their location parts
+func TestDescriptionTemplateMatchesBuiltinLayout(t *testing.T) {
+	defer func(localized descriptionLabels) { labels = localized }(labels)
+	priceRangeMode = priceRangeOff
+	tmpl, err := loadDescriptionTemplate("description.tmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fee := 450.0
+
+	properties := map[string]Property{
+		"full": {
+			Region: "Batel", City: "Curitiba", State: "PR",
+			Ad:           &Ad{Title: "Flat", Description: "Sunny flat", TransactionType: "RENT"},
+			PropertyType: "Apartment",
+			Area:         80, TotalArea: 95, RentPrice: 2500, CondoFee: &fee,
+			Bedrooms: 2, Suites: 1, Bathrooms: 2, ParkingSpots: 1,
+			Building: "Edifício Central", IsExclusive: true, Features: []string{"pool", "gym"},
+		},
+		"city only":     {City: "Curitiba", AskingPrice: 300000},
+		"no region":     {City: "Curitiba", State: "PR"},
+		"state only":    {State: "PR", Bedrooms: 3},
+		"blank parts":   {Region: " ", City: "Curitiba", State: ""},
+		"no location":   {Ad: &Ad{Title: "House"}},
+		"empty listing": {},
+	}
+	for locale, localized := range localeLabels {
+		labels = localized
+		for name, property := range properties {
+			t.Run(locale+"/"+name, func(t *testing.T) {
+				got, err := renderDescriptionTemplate(tmpl, &property)
+				if err != nil {
+					t.Fatalf("renderDescriptionTemplate: %v", err)
+				}
+				if want := builtinPropertyDescription(&property); got != want {
+					t.Errorf("template rendered\n%s\nwant\n%s", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestDescriptionTemplatePriceRange(t *testing.T) {
+	defer func(localized descriptionLabels, sale, rent float64) {
+		labels, priceRangeSaleStep, priceRangeRentStep = localized, sale, rent
+	}(labels, priceRangeSaleStep, priceRangeRentStep)
+	labels = localeLabels["pt-BR"]
+	priceRangeSaleStep, priceRangeRentStep = 100000, 500
+	property := Property{AskingPrice: 550000, RentPrice: 2750}
+
+	tests := []struct {
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{template: `{{priceRange "Sale" .AskingPrice}}`, want: "Faixa de preço: Venda $500k–$600k"},
+		{template: `{{priceRange "Rent" .RentPrice}}`, want: "Faixa de preço: Aluguel $2.5k–$3k"},
+		{template: `{{.Labels.Bedrooms}}`, want: "Quartos"},
+		{template: `{{priceRange "Venda" .AskingPrice}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			tmpl := template.Must(template.New("description").Funcs(descriptionTemplateFuncs).Parse(tt.template))
+			got, err := renderDescriptionTemplate(tmpl, &property)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("renderDescriptionTemplate error = %v, want error %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("rendered %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import "sync/atomic"
+
+// Number of generated descriptions logged in full during a dry run
+const dryRunSampleSize = 5
+
+// Generate descriptions without calling any API or writing to the target
+var dryRun bool
+
+// Properties a dry run would have embedded, across all workers
+var propertiesWouldEmbed atomic.Int64
+
+// Record a property that would be embedded and its estimated tokens, logging
+// the first few descriptions
+func (w *propertyWorker) dryRunProperty(property Property, description string) {
+	dryRunTokens.Add(int64(estimateTokens(description)))
+	if propertiesWouldEmbed.Add(1) <= dryRunSampleSize {
+		w.logger.Info("Dry run: generated description", "property_id", property.ID.Hex(), "description", description)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"log/slog"
+)
+
+// Maximum number of texts sent in one batch embedding request
+var embedBatchSize int
+
+// Number of batch embedding requests each worker sends at once
+var embedConcurrency int
+
+// Embed texts with one request: a plain embedding call for a single text and
+// a batch call otherwise
+func embedTexts(ctx context.Context, provider EmbeddingProvider, texts []string) ([][]float32, error) {
+	if len(texts) == 1 {
+		values, err := provider.Embed(ctx, texts[0])
+		if err != nil {
+			return nil, err
+		}
+		return [][]float32{values}, nil
+	}
+	return provider.EmbedBatch(ctx, texts)
+}
+
+// Check that a response holds a non-empty embedding for every text
+func allEmbedded(embeddings [][]float32, texts int) bool {
+	if len(embeddings) != texts {
+		return false
+	}
+	for _, values := range embeddings {
+		if len(values) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Generate embeddings for texts in groups of EMBED_BATCH_SIZE, returning the
+// vectors aligned to the input order. Texts already in the description cache
+// (loaded from CACHE_FILE when set), and repeats within texts, aren't sent to the API.
+func generateEmbeddingsBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	if embedCacheSize == 0 {
+		return generateEmbeddingsUncached(ctx, texts)
+	}
+
+	embeddings := make([][]float32, len(texts))
+	keys := make([][sha256.Size]byte, len(texts))
+	pending := make(map[[sha256.Size]byte][]int)
+	var uncached []string
+	var uncachedKeys [][sha256.Size]byte
+	for i, text := range texts {
+		keys[i] = embeddingCacheKey(ctx, text)
+		if vector, ok := embeddingCache.get(keys[i]); ok {
+			embeddings[i] = vector
+			embedCacheHits.Add(1)
+			continue
+		}
+		if _, ok := pending[keys[i]]; ok {
+			embedCacheHits.Add(1)
+		} else {
+			uncached = append(uncached, text)
+			uncachedKeys = append(uncachedKeys, keys[i])
+			embedCacheMisses.Add(1)
+		}
+		pending[keys[i]] = append(pending[keys[i]], i)
+	}
+	if len(uncached) == 0 {
+		return embeddings, nil
+	}
+
+	generated, err := generateEmbeddingsUncached(ctx, uncached)
+	if err != nil {
+		return nil, err
+	}
+	for j, vector := range generated {
+		key := uncachedKeys[j]
+		if err := embeddingCache.put(key, vector); err != nil {
+			slog.Warn("Error caching embedding", "error", err)
+		}
+		for _, i := range pending[key] {
+			embeddings[i] = vector
+		}
+	}
+	return embeddings, nil
+}
+
+// Generate embeddings for texts in groups of EMBED_BATCH_SIZE, calling the API for every text
+func generateEmbeddingsUncached(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += embedBatchSize {
+		end := min(start+embedBatchSize, len(texts))
+		batch, err := generateEmbeddings(ctx, texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		embeddings = append(embeddings, batch...)
+	}
+	return embeddings, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAllEmbedded(t *testing.T) {
+	tests := []struct {
+		name       string
+		embeddings [][]float32
+		texts      int
+		want       bool
+	}{
+		{name: "every text embedded", embeddings: [][]float32{{1}, {2}}, texts: 2, want: true},
+		{name: "nil response", embeddings: nil, texts: 1, want: false},
+		{name: "fewer vectors than texts", embeddings: [][]float32{{1}}, texts: 2, want: false},
+		{name: "nil vector", embeddings: [][]float32{{1}, nil}, texts: 2, want: false},
+		{name: "empty vector", embeddings: [][]float32{{}, {1}}, texts: 2, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := allEmbedded(tt.embeddings, tt.texts); got != tt.want {
+				t.Errorf("allEmbedded = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+// emptyProvider answers requests without vector values, counting them
+type emptyProvider struct {
+	values   []float32 // returned for every text, nil or empty
+	empties  int       // requests answered without values before recovering, all of them when zero
+	requests int
+}
+
+// Answer a request: values for the first empties requests, then a real vector
+func (p *emptyProvider) answer() []float32 {
+	p.requests++
+	if p.empties > 0 && p.requests > p.empties {
+		return []float32{1}
+	}
+	return p.values
+}
+
+func (p *emptyProvider) Model() string   { return "empty" }
+func (p *emptyProvider) Dimensions() int { return 0 }
+
+func (p *emptyProvider) Embed(ctx context.Context, text string) ([]float32, error) {
+	return p.answer(), nil
+}
+
+func (p *emptyProvider) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	values := p.answer()
+	embeddings := make([][]float32, len(texts))
+	for i := range embeddings {
+		embeddings[i] = values
+	}
+	return embeddings, nil
+}
+
+// A response without values is retried like a transient error, then given up
+// on with errEmptyEmbedding instead of being stored or panicking
+func TestGenerateEmbeddingsWithRetryGivesUpOnEmptyEmbeddings(t *testing.T) {
+	setupWorkerTest(t, stubProvider{}, 10)
+	defer func(backoff time.Duration) { retryMaxBackoff = backoff }(retryMaxBackoff)
+	retryMaxBackoff = time.Millisecond
+
+	tests := []struct {
+		name   string
+		values []float32
+		texts  []string
+	}{
+		{name: "nil embedding", values: nil, texts: []string{"casa"}},
+		{name: "empty embedding", values: []float32{}, texts: []string{"casa"}},
+		{name: "nil embeddings in a batch", values: nil, texts: []string{"casa", "apartamento"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &emptyProvider{values: tt.values}
+			embeddings, err := generateEmbeddingsWithRetry(context.Background(), provider, tt.texts, 3, time.Millisecond)
+			if !errors.Is(err, errEmptyEmbedding) {
+				t.Fatalf("error = %v, want errEmptyEmbedding", err)
+			}
+			if embeddings != nil {
+				t.Errorf("embeddings = %v, want none", embeddings)
+			}
+			if provider.requests != 3 {
+				t.Errorf("sent %d requests, want 3 attempts", provider.requests)
+			}
+		})
+	}
+}
+
+// An empty response is retried, and the embeddings of a later attempt are returned
+func TestGenerateEmbeddingsWithRetryRetriesEmptyEmbeddings(t *testing.T) {
+	setupWorkerTest(t, stubProvider{}, 10)
+	defer func(backoff time.Duration) { retryMaxBackoff = backoff }(retryMaxBackoff)
+	retryMaxBackoff = time.Millisecond
+
+	tests := []struct {
+		name    string
+		values  []float32
+		empties int
+	}{
+		{name: "nil embedding once", values: nil, empties: 1},
+		{name: "empty embedding twice", values: []float32{}, empties: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &emptyProvider{values: tt.values, empties: tt.empties}
+			embeddings, err := generateEmbeddingsWithRetry(context.Background(), provider, []string{"casa", "apartamento"}, 3, time.Millisecond)
+			if err != nil {
+				t.Fatalf("generateEmbeddingsWithRetry: %v", err)
+			}
+			if !allEmbedded(embeddings, 2) {
+				t.Errorf("embeddings = %v, want both texts embedded", embeddings)
+			}
+			if provider.requests != tt.empties+1 {
+				t.Errorf("sent %d requests, want %d", provider.requests, tt.empties+1)
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Maximum number of embeddings kept by the description cache (disabled when 0)
+var embedCacheSize int
+
+// Cache of embeddings by the SHA-256 of their input text, so identical
+// descriptions across properties are embedded once per run
+var embeddingCache = newEmbeddingTextCache()
+
+// File persisting the cache across runs (unset keeps it in memory only)
+var cacheFile string
+
+// Disable the embedding cache entirely, in memory and on disk
+var noCache bool
+
+// Embedding inputs served from the cache or sent to the API
+var (
+	embedCacheHits   atomic.Int64
+	embedCacheMisses atomic.Int64
+)
+
+// embeddingTextCache maps input hashes to vectors, shared by all workers.
+// With a cache file, every new vector is also appended to it.
+type embeddingTextCache struct {
+	mu      sync.RWMutex
+	vectors map[[sha256.Size]byte][]float32
+	file    *os.File
+}
+
+// Key a text by the model setup that embeds it, so a cache file never hands
+// out vectors from another model, task type, output dimension, ensemble
+// weight or normalization setting
+func embeddingCacheKey(ctx context.Context, text string) [sha256.Size]byte {
+	taskType := geminiDocumentTaskType
+	if isQueryEmbedding(ctx) {
+		taskType = geminiQueryTaskType
+	}
+	parts := []string{
+		embeddingModelLabel(),
+		taskTypeName(taskType),
+		strconv.Itoa(embedDimensions),
+		strconv.FormatBool(normalizeEmbeddings),
+	}
+	// The label names the ensemble strategy but not its weight
+	if ensembleProvider != nil {
+		parts = append(parts, strconv.FormatFloat(ensembleWeight, 'g', -1, 64))
+	}
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	hash.Write([]byte(text))
+	var key [sha256.Size]byte
+	hash.Sum(key[:0])
+	return key
+}
+
+func newEmbeddingTextCache() *embeddingTextCache {
+	return &embeddingTextCache{vectors: make(map[[sha256.Size]byte][]float32)}
+}
+
+func (c *embeddingTextCache) get(key [sha256.Size]byte) ([]float32, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	vector, ok := c.vectors[key]
+	return vector, ok
+}
+
+// Remember a vector; once EMBED_CACHE_SIZE entries are held in memory, new
+// ones are only written to the cache file
+func (c *embeddingTextCache) put(key [sha256.Size]byte, vector []float32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Another worker embedding the same text first already stored it
+	if _, ok := c.vectors[key]; ok {
+		return nil
+	}
+	if len(c.vectors) < embedCacheSize {
+		c.vectors[key] = vector
+	}
+	if c.file == nil {
+		return nil
+	}
+	if _, err := c.file.Write(encodeCacheRecord(key, vector)); err != nil {
+		return fmt.Errorf("error writing cache file: %w", err)
+	}
+	return nil
+}
+
+// Load the vectors stored in a cache file, then append new ones to it. A
+// record cut short by an interrupted run is dropped, and the file is
+// compacted first when it holds the same key more than once.
+func (c *embeddingTextCache) open(path string) (int, error) {
+	dropped, err := compactCacheFile(path)
+	if err != nil {
+		return 0, err
+	}
+	if dropped > 0 {
+		slog.Info("Compacted cache file", "path", path, "dropped_count", dropped)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return 0, fmt.Errorf("error opening cache file: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var loaded int
+	var offset int64
+	err = scanCacheRecords(file, func(key [sha256.Size]byte, vector []float32, end int64) error {
+		offset = end
+		if len(c.vectors) < embedCacheSize {
+			c.vectors[key] = vector
+			loaded++
+		}
+		return nil
+	})
+	if err != nil {
+		file.Close()
+		return 0, fmt.Errorf("error reading cache file %s: %w", path, err)
+	}
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return 0, fmt.Errorf("error repairing cache file: %w", err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return 0, fmt.Errorf("error opening cache file: %w", err)
+	}
+	c.file = file
+	return loaded, nil
+}
+
+func (c *embeddingTextCache) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		return nil
+	}
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
+
+// Rewrite a cache file keeping only the last record of each key, returning
+// how many records were dropped. Keys never read from the in-memory cache,
+// once EMBED_CACHE_SIZE is reached, are appended again by every run, so
+// without this the file would keep growing. Nothing is rewritten when every
+// key is unique.
+func compactCacheFile(path string) (int, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error opening cache file: %w", err)
+	}
+	defer file.Close()
+
+	// First pass: where each key's last record ends
+	last := make(map[[sha256.Size]byte]int64)
+	var records int
+	err = scanCacheRecords(bufio.NewReader(file), func(key [sha256.Size]byte, _ []float32, end int64) error {
+		last[key] = end
+		records++
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error reading cache file %s: %w", path, err)
+	}
+	if records == len(last) {
+		return 0, nil
+	}
+
+	// Second pass: copy those records through a temporary file
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("error compacting cache file: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, fmt.Errorf("error compacting cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	writer := bufio.NewWriter(tmp)
+	err = scanCacheRecords(bufio.NewReader(file), func(key [sha256.Size]byte, vector []float32, end int64) error {
+		if last[key] != end {
+			return nil
+		}
+		_, err := writer.Write(encodeCacheRecord(key, vector))
+		return err
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error compacting cache file: %w", err)
+	}
+	return records - len(last), nil
+}
+
+// Call fn with each complete record of a cache file and the offset where it
+// ends, stopping at the end of the file or at a record cut short
+func scanCacheRecords(r io.Reader, fn func(key [sha256.Size]byte, vector []float32, end int64) error) error {
+	var end int64
+	for {
+		key, vector, size, err := readCacheRecord(r)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		end += size
+		if err := fn(key, vector, end); err != nil {
+			return err
+		}
+	}
+}
+
+// Encode a cache record: the key, the vector length as a uint32, then the
+// values as little-endian float32s
+func encodeCacheRecord(key [sha256.Size]byte, vector []float32) []byte {
+	record := make([]byte, 0, sha256.Size+4+4*len(vector))
+	record = append(record, key[:]...)
+	record = binary.LittleEndian.AppendUint32(record, uint32(len(vector)))
+	for _, value := range vector {
+		record = binary.LittleEndian.AppendUint32(record, math.Float32bits(value))
+	}
+	return record
+}
+
+// Read one cache record, returning its size in bytes
+func readCacheRecord(r io.Reader) ([sha256.Size]byte, []float32, int64, error) {
+	var header [sha256.Size + 4]byte
+	var key [sha256.Size]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return key, nil, 0, err
+	}
+	copy(key[:], header[:sha256.Size])
+	length := binary.LittleEndian.Uint32(header[sha256.Size:])
+	if length > 1<<16 {
+		return key, nil, 0, fmt.Errorf("corrupt record with %d dimensions", length)
+	}
+	data := make([]byte, 4*length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return key, nil, 0, err
+	}
+	vector := make([]float32, length)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return key, vector, int64(len(header) + len(data)), nil
+}
+
+// Share of embedding inputs served from the cache, in percent
+func embedCacheHitRate() float64 {
+	hits, misses := embedCacheHits.Load(), embedCacheMisses.Load()
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses) * 100
+}
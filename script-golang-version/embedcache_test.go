@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+)
+
+func TestEmbeddingCacheKey(t *testing.T) {
+	defer func(provider EmbeddingProvider, document, query genai.TaskType, dimensions int, normalize bool) {
+		embeddingProvider, geminiDocumentTaskType, geminiQueryTaskType = provider, document, query
+		embedDimensions, normalizeEmbeddings = dimensions, normalize
+	}(embeddingProvider, geminiDocumentTaskType, geminiQueryTaskType, embedDimensions, normalizeEmbeddings)
+	defer func(provider EmbeddingProvider, strategy string, weight float64) {
+		ensembleProvider, ensembleStrategy, ensembleWeight = provider, strategy, weight
+	}(ensembleProvider, ensembleStrategy, ensembleWeight)
+	embeddingProvider = stubProvider{}
+	ensembleProvider = stubProvider{}
+	ensembleStrategy = ensembleAverage
+	ensembleWeight = 0.5
+	geminiDocumentTaskType = genai.TaskTypeRetrievalDocument
+	geminiQueryTaskType = genai.TaskTypeRetrievalQuery
+	embedDimensions = 0
+	normalizeEmbeddings = false
+
+	ctx := context.Background()
+	base := embeddingCacheKey(ctx, "flat")
+	if embeddingCacheKey(ctx, "flat") != base {
+		t.Fatal("the same text and setup gave different keys")
+	}
+
+	changes := []struct {
+		name   string
+		change func() context.Context
+	}{
+		{name: "text", change: func() context.Context { return ctx }},
+		{name: "search query", change: func() context.Context { return withQueryEmbedding(ctx) }},
+		{name: "document task type", change: func() context.Context {
+			geminiDocumentTaskType = genai.TaskTypeSemanticSimilarity
+			return ctx
+		}},
+		{name: "dimensions", change: func() context.Context {
+			embedDimensions = 256
+			return ctx
+		}},
+		{name: "normalization", change: func() context.Context {
+			normalizeEmbeddings = true
+			return ctx
+		}},
+		{name: "ensemble weight", change: func() context.Context {
+			ensembleWeight = 0.7
+			return ctx
+		}},
+	}
+	seen := map[[sha256.Size]byte]string{base: "base"}
+	for _, tt := range changes {
+		text := "flat"
+		if tt.name == "text" {
+			text = "house"
+		}
+		key := embeddingCacheKey(tt.change(), text)
+		if previous, ok := seen[key]; ok {
+			t.Errorf("changing the %s gave the same key as %s", tt.name, previous)
+		}
+		seen[key] = tt.name
+	}
+}
+
+func TestEmbeddingCacheCompactsDuplicates(t *testing.T) {
+	defer func(size int) { embedCacheSize = size }(embedCacheSize)
+	embedCacheSize = 10
+
+	path := filepath.Join(t.TempDir(), "cache.bin")
+	first, second := sha256.Sum256([]byte("first")), sha256.Sum256([]byte("second"))
+	var data []byte
+	data = append(data, encodeCacheRecord(first, []float32{1, 0})...)
+	data = append(data, encodeCacheRecord(second, []float32{0, 1})...)
+	data = append(data, encodeCacheRecord(first, []float32{0.5, 0.5})...)
+	// A record cut short by an interrupted run
+	data = append(data, encodeCacheRecord(second, []float32{1, 1})[:10]...)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for run := 1; run <= 2; run++ {
+		cache := newEmbeddingTextCache()
+		loaded, err := cache.open(path)
+		if err != nil {
+			t.Fatalf("run %d: open: %v", run, err)
+		}
+		if loaded != 2 {
+			t.Errorf("run %d: loaded %d embeddings, want 2", run, loaded)
+		}
+		if vector, _ := cache.get(first); !slices.Equal(vector, []float32{0.5, 0.5}) {
+			t.Errorf("run %d: first = %v, want the last one written", run, vector)
+		}
+		if vector, _ := cache.get(second); !slices.Equal(vector, []float32{0, 1}) {
+			t.Errorf("run %d: second = %v, want [0 1]", run, vector)
+		}
+		if err := cache.close(); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := int64(2 * len(encodeCacheRecord(first, []float32{0, 0}))); info.Size() != want {
+			t.Errorf("run %d: cache file holds %d bytes, want %d", run, info.Size(), want)
+		}
+	}
+}
+
+// A vector another worker already stored isn't appended to the file again
+func TestEmbeddingCachePutSkipsKnownKeys(t *testing.T) {
+	defer func(size int) { embedCacheSize = size }(embedCacheSize)
+	embedCacheSize = 10
+
+	path := filepath.Join(t.TempDir(), "cache.bin")
+	cache := newEmbeddingTextCache()
+	if _, err := cache.open(path); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	key := sha256.Sum256([]byte("flat"))
+	for i := 0; i < 3; i++ {
+		if err := cache.put(key, []float32{1, 0}); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+	}
+	if err := cache.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(len(encodeCacheRecord(key, []float32{1, 0}))); info.Size() != want {
+		t.Errorf("cache file holds %d bytes, want one record of %d", info.Size(), want)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"google.golang.org/api/option"
+)
+
+// EmbeddingProvider turns text into an embedding vector with one model
+type EmbeddingProvider interface {
+	Embed(ctx context.Context, text string) ([]float32, error)
+	// Embed several texts in one request, returning vectors in input order
+	EmbedBatch(ctx context.Context, texts []string) ([][]float32, error)
+	// Length of the vectors returned by Embed (0 when unknown)
+	Dimensions() int
+	// Name of the model, for logs and metrics
+	Model() string
+}
+
+// providerFactory describes how to build an EmbeddingProvider for a model
+type providerFactory struct {
+	needsClient  bool   // requires a Gemini client (and API key)
+	defaultModel string // used when EMBEDDING_MODEL isn't set
+	new          func(client *genai.Client, modelName string) EmbeddingProvider
+}
+
+// Available embedding providers, by EMBEDDING_PROVIDER name
+var embeddingProviders = map[string]providerFactory{
+	"gemini": {
+		needsClient:  true,
+		defaultModel: "text-embedding-004",
+		new: func(client *genai.Client, modelName string) EmbeddingProvider {
+			return GeminiProvider{
+				client:           client,
+				model:            modelName,
+				documentTaskType: geminiDocumentTaskType,
+				queryTaskType:    geminiQueryTaskType,
+			}
+		},
+	},
+	"openai": {
+		defaultModel: "text-embedding-3-small",
+		new: func(_ *genai.Client, modelName string) EmbeddingProvider {
+			return OpenAIProvider{apiKey: openAIAPIKey, model: modelName, client: http.DefaultClient}
+		},
+	},
+	"fake": {
+		defaultModel: "text-embedding-004",
+		new: func(_ *genai.Client, modelName string) EmbeddingProvider {
+			return fakeProvider{model: modelName, dimensions: fakeEmbeddingDimensions()}
+		},
+	},
+}
+
+// Dimension of fake embeddings when EMBED_DIMENSIONS isn't set
+const defaultFakeDimensions = 768
+
+// Output dimensions of known Gemini embedding models
+var geminiModelDimensions = map[string]int{
+	"text-embedding-004": 768,
+	"embedding-001":      768,
+}
+
+// Check EMBED_DIMENSIONS against the output of known Gemini models. The Gemini
+// SDK can't request a smaller output dimensionality, so any other size would
+// fail every embedding request. Unknown models are checked per response.
+func checkGeminiDimensions() error {
+	if embeddingProviderName != "gemini" || embedDimensions == 0 {
+		return nil
+	}
+	dimensions, model := geminiModelDimensions[embeddingModelName], embeddingModelName
+	if ensembleModel != "" && ensembleStrategy == ensembleConcat {
+		if geminiModelDimensions[ensembleModel] == 0 {
+			return nil
+		}
+		dimensions += geminiModelDimensions[ensembleModel]
+		model += "+" + ensembleModel
+	}
+	if dimensions == 0 || dimensions == embedDimensions {
+		return nil
+	}
+	return fmt.Errorf("invalid EMBED_DIMENSIONS %d: Gemini model %s returns %d dimensions and can't be asked for fewer",
+		embedDimensions, model, dimensions)
+}
+
+// The providers used for all embedding requests; the ensemble one is nil
+// unless ENSEMBLE_MODEL is set
+var (
+	embeddingProvider EmbeddingProvider
+	ensembleProvider  EmbeddingProvider
+)
+
+// The configured embedding providers, including the ensemble one when set
+func activeEmbeddingProviders() []EmbeddingProvider {
+	if ensembleProvider == nil {
+		return []EmbeddingProvider{embeddingProvider}
+	}
+	return []EmbeddingProvider{embeddingProvider, ensembleProvider}
+}
+
+// Name the model behind stored vectors, including the ensemble model and
+// strategy when vectors are combined
+func embeddingModelLabel() string {
+	if ensembleProvider == nil {
+		return embeddingProvider.Model()
+	}
+	return fmt.Sprintf("%s+%s (%s)", embeddingProvider.Model(), ensembleProvider.Model(), ensembleStrategy)
+}
+
+// Create the Gemini client when the configured provider needs one (nil
+// otherwise) and set up the embedding providers and their request limits
+func setupEmbeddingProviders(ctx context.Context) (*genai.Client, error) {
+	factory := embeddingProviders[embeddingProviderName]
+
+	var client *genai.Client
+	if factory.needsClient || summarize || translateTo != "" {
+		var err error
+		client, err = genai.NewClient(ctx, option.WithAPIKey(apiKey))
+		if err != nil {
+			return nil, fmt.Errorf("error creating Gemini client: %w", err)
+		}
+	}
+	embeddingProvider = factory.new(client, embeddingModelName)
+	if ensembleModel != "" {
+		ensembleProvider = factory.new(client, ensembleModel)
+	}
+	setupEmbeddingLimits()
+	return client, nil
+}
+
+// Gemini task types, by GEMINI_TASK_TYPE and GEMINI_QUERY_TASK_TYPE name
+var geminiTaskTypes = map[string]genai.TaskType{
+	"UNSPECIFIED":         genai.TaskTypeUnspecified,
+	"RETRIEVAL_QUERY":     genai.TaskTypeRetrievalQuery,
+	"RETRIEVAL_DOCUMENT":  genai.TaskTypeRetrievalDocument,
+	"SEMANTIC_SIMILARITY": genai.TaskTypeSemanticSimilarity,
+	"CLASSIFICATION":      genai.TaskTypeClassification,
+	"CLUSTERING":          genai.TaskTypeClustering,
+}
+
+// Task types sent with Gemini embedding requests for stored properties and
+// for search queries
+var (
+	geminiDocumentTaskType genai.TaskType
+	geminiQueryTaskType    genai.TaskType
+)
+
+// Parse a Gemini task type name such as RETRIEVAL_DOCUMENT
+func parseTaskType(name string) (genai.TaskType, error) {
+	taskType, ok := geminiTaskTypes[strings.ToUpper(strings.TrimSpace(name))]
+	if !ok {
+		return 0, fmt.Errorf("unknown task type %q", name)
+	}
+	return taskType, nil
+}
+
+// Name of a Gemini task type as configured
+func taskTypeName(taskType genai.TaskType) string {
+	for name, t := range geminiTaskTypes {
+		if t == taskType {
+			return name
+		}
+	}
+	return taskType.String()
+}
+
+type queryEmbeddingKey struct{}
+
+// Mark embedding requests made with the context as search queries rather
+// than documents to store
+func withQueryEmbedding(ctx context.Context) context.Context {
+	return context.WithValue(ctx, queryEmbeddingKey{}, true)
+}
+
+func isQueryEmbedding(ctx context.Context) bool {
+	query, _ := ctx.Value(queryEmbeddingKey{}).(bool)
+	return query
+}
+
+// GeminiProvider calls the Gemini embedding API
+type GeminiProvider struct {
+	client           *genai.Client
+	model            string
+	documentTaskType genai.TaskType
+	queryTaskType    genai.TaskType
+}
+
+func (p GeminiProvider) Model() string { return p.model }
+
+func (p GeminiProvider) Dimensions() int { return geminiModelDimensions[p.model] }
+
+// The embedding model with the task type for what's being embedded
+func (p GeminiProvider) embeddingModel(ctx context.Context) *genai.EmbeddingModel {
+	model := p.client.EmbeddingModel(p.model)
+	model.TaskType = p.documentTaskType
+	if isQueryEmbedding(ctx) {
+		model.TaskType = p.queryTaskType
+	}
+	return model
+}
+
+func (p GeminiProvider) Embed(ctx context.Context, text string) ([]float32, error) {
+	resp, err := p.embeddingModel(ctx).EmbedContent(ctx, genai.Text(text))
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil || resp.Embedding == nil {
+		return nil, nil
+	}
+	return resp.Embedding.Values, nil
+}
+
+func (p GeminiProvider) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	model := p.embeddingModel(ctx)
+	batch := model.NewBatch()
+	for _, text := range texts {
+		batch.AddContent(genai.Text(text))
+	}
+	resp, err := model.BatchEmbedContents(ctx, batch)
+	if err != nil || resp == nil {
+		return nil, err
+	}
+	embeddings := make([][]float32, len(resp.Embeddings))
+	for i, embedding := range resp.Embeddings {
+		if embedding != nil {
+			embeddings[i] = embedding.Values
+		}
+	}
+	return embeddings, nil
+}
+
+// fakeProvider derives a deterministic unit vector from a hash of the model
+// name and text, for offline runs and tests without API credentials
+type fakeProvider struct {
+	model      string
+	dimensions int
+}
+
+func (p fakeProvider) Model() string { return p.model }
+
+func (p fakeProvider) Dimensions() int { return p.dimensions }
+
+func (p fakeProvider) Embed(ctx context.Context, text string) ([]float32, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	values := make([]float32, p.dimensions)
+	var norm float64
+	var block [sha256.Size]byte
+	for i := range values {
+		// Each hash block yields 8 values from its 4-byte words
+		if i%8 == 0 {
+			var counter [8]byte
+			binary.BigEndian.PutUint64(counter[:], uint64(i/8))
+			block = sha256.Sum256([]byte(p.model + "\x00" + text + "\x00" + string(counter[:])))
+		}
+		word := binary.BigEndian.Uint32(block[(i%8)*4:])
+		value := float64(word)/math.MaxUint32*2 - 1
+		values[i] = float32(value)
+		norm += value * value
+	}
+
+	norm = math.Sqrt(norm)
+	for i := range values {
+		values[i] = float32(float64(values[i]) / norm)
+	}
+	return values, nil
+}
+
+func (p fakeProvider) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		values, err := p.Embed(ctx, text)
+		if err != nil {
+			return nil, err
+		}
+		embeddings[i] = values
+	}
+	return embeddings, nil
+}
+
+// Fake embeddings match EMBED_DIMENSIONS so the dimension check passes
+func fakeEmbeddingDimensions() int {
+	if embedDimensions > 0 {
+		return embedDimensions
+	}
+	return defaultFakeDimensions
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckGeminiDimensions(t *testing.T) {
+	defer func(provider, model, ensemble, strategy string, dimensions int) {
+		embeddingProviderName, embeddingModelName, ensembleModel, ensembleStrategy = provider, model, ensemble, strategy
+		embedDimensions = dimensions
+	}(embeddingProviderName, embeddingModelName, ensembleModel, ensembleStrategy, embedDimensions)
+
+	tests := []struct {
+		name       string
+		provider   string
+		model      string
+		ensemble   string
+		strategy   string
+		dimensions int
+		wantErr    string // substring of the error, empty for none
+	}{
+		{name: "unset", provider: "gemini", model: "text-embedding-004"},
+		{name: "native size", provider: "gemini", model: "text-embedding-004", dimensions: 768},
+		{
+			name:       "smaller than the model",
+			provider:   "gemini",
+			model:      "text-embedding-004",
+			dimensions: 256,
+			wantErr:    "invalid EMBED_DIMENSIONS 256: Gemini model text-embedding-004 returns 768 dimensions",
+		},
+		{name: "unknown model", provider: "gemini", model: "gemini-embedding-exp", dimensions: 256},
+		{name: "other provider", provider: "openai", model: "text-embedding-3-small", dimensions: 256},
+		{
+			name:       "concatenated ensemble",
+			provider:   "gemini",
+			model:      "text-embedding-004",
+			ensemble:   "embedding-001",
+			strategy:   ensembleConcat,
+			dimensions: 1536,
+		},
+		{
+			name:       "concatenated ensemble of the wrong size",
+			provider:   "gemini",
+			model:      "text-embedding-004",
+			ensemble:   "embedding-001",
+			strategy:   ensembleConcat,
+			dimensions: 768,
+			wantErr:    "text-embedding-004+embedding-001 returns 1536 dimensions",
+		},
+		{
+			name:       "averaged ensemble",
+			provider:   "gemini",
+			model:      "text-embedding-004",
+			ensemble:   "embedding-001",
+			strategy:   ensembleAverage,
+			dimensions: 768,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			embeddingProviderName, embeddingModelName, ensembleModel, ensembleStrategy = tt.provider, tt.model, tt.ensemble, tt.strategy
+			embedDimensions = tt.dimensions
+			err := checkGeminiDimensions()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
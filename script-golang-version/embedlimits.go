@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+
+	"golang.org/x/time/rate"
+)
+
+// Limits on embedding requests shared by all workers
+var (
+	embedMaxConcurrency int     // maximum requests in flight (unlimited when 0)
+	embedRPM            float64 // maximum requests per minute (unlimited when 0)
+	embedRPS            float64 // maximum requests per second (unlimited when 0)
+)
+
+// Semaphore and rate limiter enforcing the limits above, nil when unlimited
+var (
+	embeddingSlots     chan struct{}
+	embeddingLimiter   *rate.Limiter
+	embeddingsInFlight atomic.Int64
+)
+
+// Set up the shared embedding limits from configuration
+func setupEmbeddingLimits() {
+	if embedMaxConcurrency > 0 {
+		embeddingSlots = make(chan struct{}, embedMaxConcurrency)
+	}
+	if perSecond := embeddingRate(); perSecond > 0 {
+		embeddingLimiter = rate.NewLimiter(rate.Limit(perSecond), 1)
+	}
+}
+
+// The tighter of EMBED_RPS and EMBED_RPM, in requests per second (0 when unlimited)
+func embeddingRate() float64 {
+	perSecond := embedRPS
+	if embedRPM > 0 && (perSecond == 0 || embedRPM/60 < perSecond) {
+		perSecond = embedRPM / 60
+	}
+	return perSecond
+}
+
+// Wait until an embedding request may be sent, under both the rate and the
+// concurrency limit. The returned function must be called once the request
+// has completed.
+func acquireEmbeddingSlot(ctx context.Context) (func(), error) {
+	if embeddingLimiter != nil {
+		if err := embeddingLimiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if embeddingSlots != nil {
+		select {
+		case embeddingSlots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	metrics.Gauge(metricEmbeddingsInFlight, float64(embeddingsInFlight.Add(1)))
+	return func() {
+		metrics.Gauge(metricEmbeddingsInFlight, float64(embeddingsInFlight.Add(-1)))
+		if embeddingSlots != nil {
+			<-embeddingSlots
+		}
+	}, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Strategies for combining the primary and ensemble embeddings
+const (
+	ensembleConcat  = "concat"
+	ensembleAverage = "weighted-average"
+)
+
+// Combine two embeddings into a single stored vector.
+// Concatenation keeps both vectors side by side (dimension = sum of both);
+// weighted averaging requires equal dimensions and keeps that dimension.
+func combineEmbeddings(primary, secondary []float32, strategy string, primaryWeight float64) ([]float32, error) {
+	switch strategy {
+	case ensembleConcat:
+		combined := make([]float32, 0, len(primary)+len(secondary))
+		combined = append(combined, primary...)
+		return append(combined, secondary...), nil
+	case ensembleAverage:
+		if len(primary) != len(secondary) {
+			return nil, fmt.Errorf("cannot average embeddings of different dimensions (%d and %d)",
+				len(primary), len(secondary))
+		}
+		combined := make([]float32, len(primary))
+		for i := range primary {
+			combined[i] = float32(primaryWeight*float64(primary[i]) + (1-primaryWeight)*float64(secondary[i]))
+		}
+		return combined, nil
+	default:
+		return nil, fmt.Errorf("unknown ensemble strategy %q", strategy)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCombineEmbeddings(t *testing.T) {
+	tests := []struct {
+		name      string
+		primary   []float32
+		secondary []float32
+		strategy  string
+		weight    float64
+		want      []float32
+		wantErr   bool
+	}{
+		{name: "concat", primary: []float32{1, 2}, secondary: []float32{3, 4, 5}, strategy: ensembleConcat,
+			want: []float32{1, 2, 3, 4, 5}},
+		{name: "equal weights", primary: []float32{1, 0}, secondary: []float32{0, 1}, strategy: ensembleAverage, weight: 0.5,
+			want: []float32{0.5, 0.5}},
+		{name: "primary weighted", primary: []float32{1, 0}, secondary: []float32{0, 1}, strategy: ensembleAverage, weight: 0.75,
+			want: []float32{0.75, 0.25}},
+		{name: "primary only", primary: []float32{1, 2}, secondary: []float32{3, 4}, strategy: ensembleAverage, weight: 1,
+			want: []float32{1, 2}},
+		{name: "average of different dimensions", primary: []float32{1, 2}, secondary: []float32{3}, strategy: ensembleAverage,
+			weight: 0.5, wantErr: true},
+		{name: "unknown strategy", primary: []float32{1}, secondary: []float32{1}, strategy: "max", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := combineEmbeddings(tt.primary, tt.secondary, tt.strategy, tt.weight)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("combineEmbeddings error = %v, want error %t", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("combineEmbeddings = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Concatenating leaves the primary vector's backing array alone
+	primary := make([]float32, 2, 4)
+	combineEmbeddings(primary, []float32{7, 8}, ensembleConcat, 0)
+	if extended := primary[:4]; extended[2] != 0 || extended[3] != 0 {
+		t.Errorf("concat wrote into the primary vector: %v", extended)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// How workers find the properties that already have embeddings: one $in
+// query per EXISTENCE_CHECK_WINDOW, or every stored ID in the worker's range
+// loaded up front
+const (
+	existenceCheckByWindow = "window"
+	existenceCheckPreload  = "preload"
+)
+
+// Configured EXISTENCE_CHECK mode
+var existenceCheckMode string
+
+// Number of stored IDs read per query when preloading
+var existencePreloadChunk int
+
+// Queries sent to the target to check for existing embeddings, in either mode
+var existenceQueries atomic.Int64
+
+// Load the IDs of stored properties within an _id range, mapped to their
+// stored description_text when -skip-unchanged compares it. IDs are read in
+// pages of EXISTENCE_PRELOAD_CHUNK along the metadata._id index, so no single
+// query has to return a whole large collection.
+func preloadExisting(ctx context.Context, targetDB propertyCollection, r idRange) (map[primitive.ObjectID]string, error) {
+	idPath := storedPath("metadata._id")
+	projection := bson.M{idPath: 1}
+	if skipUnchanged {
+		projection["description_text"] = 1
+	}
+	findOptions := options.Find().
+		SetProjection(projection).
+		SetSort(bson.D{{Key: idPath, Value: 1}}).
+		SetLimit(int64(existencePreloadChunk))
+
+	existing := make(map[primitive.ObjectID]string)
+	after := r.after
+	for {
+		condition := bson.M{}
+		if !r.from.IsZero() {
+			condition["$gte"] = r.from
+		}
+		if !r.to.IsZero() {
+			condition["$lt"] = r.to
+		}
+		if !after.IsZero() {
+			condition["$gt"] = after
+		}
+		filter := bson.M{}
+		if len(condition) > 0 {
+			filter[idPath] = condition
+		}
+
+		existenceQueries.Add(1)
+		cursor, err := targetDB.Find(ctx, filter, findOptions)
+		if err != nil {
+			return nil, err
+		}
+		read := 0
+		for cursor.Next(ctx) {
+			var doc PropertyWithEmbedding
+			if err := decodeStoredDocument(cursor.Current, &doc); err != nil {
+				cursor.Close(ctx)
+				return nil, err
+			}
+			existing[doc.Metadata.ID] = doc.DescriptionText
+			after = doc.Metadata.ID
+			read++
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if read < existencePreloadChunk {
+			return existing, nil
+		}
+	}
+}
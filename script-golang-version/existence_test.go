@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Preloading reads the stored IDs of a range in pages of EXISTENCE_PRELOAD_CHUNK
+func TestPreloadExisting(t *testing.T) {
+	setupWorkerTest(t, stubProvider{}, 10)
+	defer func(chunk int) { existencePreloadChunk = chunk }(existencePreloadChunk)
+	target := &memoryCollection{}
+	properties := testProperties("a", "b", "c", "d", "e", "f", "g", "h")
+	storeProperties(t, target, properties...)
+	ids := propertyIDs(properties)
+
+	tests := []struct {
+		name    string
+		chunk   int
+		r       idRange
+		want    []primitive.ObjectID
+		queries int64
+	}{
+		{name: "partial last page", chunk: 3, r: idRange{}, want: ids, queries: 3},
+		// A full last page needs one more query to find nothing follows
+		{name: "full last page", chunk: 4, r: idRange{}, want: ids, queries: 3},
+		{name: "single page", chunk: 100, r: idRange{}, want: ids, queries: 1},
+		{name: "bounded range", chunk: 2, r: idRange{from: ids[1], to: ids[6]}, want: ids[1:6], queries: 3},
+		{name: "resumed range", chunk: 2, r: idRange{from: ids[1], to: ids[6], after: ids[3]}, want: ids[4:6], queries: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			existencePreloadChunk = tt.chunk
+			queries := existenceQueries.Load()
+			existing, err := preloadExisting(context.Background(), target, tt.r)
+			if err != nil {
+				t.Fatalf("preloadExisting: %v", err)
+			}
+			var got []primitive.ObjectID
+			for id, description := range existing {
+				got = append(got, id)
+				if description == "" {
+					t.Errorf("property %s has no stored description", id.Hex())
+				}
+			}
+			slices.SortFunc(got, func(a, b primitive.ObjectID) int { return slices.Compare(a[:], b[:]) })
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("preloaded %v, want %v", got, tt.want)
+			}
+			if got := existenceQueries.Load() - queries; got != tt.queries {
+				t.Errorf("sent %d queries, want %d", got, tt.queries)
+			}
+		})
+	}
+}
+
+// A worker with preloaded IDs skips stored properties without querying per window
+func TestWorkerUsesPreloadedExistence(t *testing.T) {
+	setupWorkerTest(t, stubProvider{}, 10)
+	defer func(mode string, chunk int) {
+		existenceCheckMode, existencePreloadChunk = mode, chunk
+	}(existenceCheckMode, existencePreloadChunk)
+	existenceCheckMode, existencePreloadChunk = existenceCheckPreload, 100
+	existenceCheckWindow = 2
+	store := newMemoryStore()
+	properties := testProperties("a", "b", "c", "d", "e")
+	storeProperties(t, store.target, properties[0], properties[3])
+
+	ctx := context.Background()
+	queries := existenceQueries.Load()
+	w := newPropertyWorker(1, store, nil)
+	if err := w.preloadExisting(ctx, idRange{}); err != nil {
+		t.Fatalf("preloadExisting: %v", err)
+	}
+	for _, property := range properties {
+		w.process(ctx, property)
+	}
+	w.flush(ctx)
+
+	if got := existenceQueries.Load() - queries; got != 1 {
+		t.Errorf("sent %d existence queries, want only the preload", got)
+	}
+	if got := w.stats(); got.Skipped != 2 || got.Processed != 5 {
+		t.Errorf("stats = %+v, want 5 processed and 2 skipped", got)
+	}
+	if ids := store.target.ids(storedPath("metadata._id")); len(ids) != 5 {
+		t.Errorf("target holds %d properties, want 5", len(ids))
+	}
+}
+
+// Existence queries of a catch-up run over stored properties: one per
+// property as before windowed lookups, one per window, or the preload
+func BenchmarkExistenceCheck(b *testing.B) {
+	setupWorkerTest(b, stubProvider{}, 100)
+	defer func(mode string, chunk int, logger *slog.Logger) {
+		existenceCheckMode, existencePreloadChunk = mode, chunk
+		slog.SetDefault(logger)
+	}(existenceCheckMode, existencePreloadChunk, slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	existencePreloadChunk = 10000
+
+	texts := make([]string, 500)
+	for i := range texts {
+		texts[i] = fmt.Sprintf("property %d", i)
+	}
+	properties := testProperties(texts...)
+	store := newMemoryStore()
+	storeProperties(b, store.target, properties...)
+
+	modes := []struct {
+		name   string
+		mode   string
+		window int
+	}{
+		{name: "per property", mode: existenceCheckByWindow, window: 1},
+		{name: "window", mode: existenceCheckByWindow, window: 100},
+		{name: "preload", mode: existenceCheckPreload, window: 100},
+	}
+	ctx := context.Background()
+	for _, m := range modes {
+		b.Run(m.name, func(b *testing.B) {
+			existenceCheckMode, existenceCheckWindow = m.mode, m.window
+			queries := existenceQueries.Load()
+			for i := 0; i < b.N; i++ {
+				w := newPropertyWorker(1, store, nil)
+				if err := w.preloadExisting(ctx, idRange{}); err != nil {
+					b.Fatalf("preloadExisting: %v", err)
+				}
+				for _, property := range properties {
+					w.process(ctx, property)
+				}
+				w.flush(ctx)
+				if got := w.stats(); got.Skipped != len(properties) {
+					b.Fatalf("skipped %d properties, want all %d", got.Skipped, len(properties))
+				}
+			}
+			b.ReportMetric(float64(existenceQueries.Load()-queries)/float64(b.N), "queries/op")
+		})
+	}
+}
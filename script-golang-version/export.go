@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Export stored embeddings to this path ("-" for stdout), then exit
+var exportPath string
+
+// Format of -export
+var exportFormat string
+
+// Supported -format values
+const (
+	exportJSONL = "jsonl"
+	exportCSV   = "csv"
+	exportNPY   = "npy"
+)
+
+// Length reserved for the .npy header, so the final shape can be written in
+// place once all rows are known
+const npyHeaderLength = 128
+
+// embeddingExporter writes stored documents one at a time
+type embeddingExporter interface {
+	write(doc *PropertyWithEmbedding) error
+	// Flush buffered output and finish the file
+	close() error
+}
+
+// Stream the target collection's documents, restricted to the tenant when
+// one is given, into the export file. Returns the number of documents written.
+func exportEmbeddings(ctx context.Context, client *mongo.Client, path, format, tenant string) (int64, error) {
+	if format == exportNPY && path == "-" {
+		return 0, errors.New("npy exports need a file, not stdout")
+	}
+
+	var file *os.File
+	if path == "-" {
+		file = os.Stdout
+	} else {
+		var err error
+		file, err = os.Create(path)
+		if err != nil {
+			return 0, fmt.Errorf("error creating export file: %w", err)
+		}
+		defer file.Close()
+	}
+
+	var exporter embeddingExporter
+	switch format {
+	case exportJSONL:
+		exporter = newJSONLExporter(file)
+	case exportCSV:
+		exporter = newCSVExporter(file)
+	case exportNPY:
+		idsPath := strings.TrimSuffix(path, ".npy") + "_ids.txt"
+		ids, err := os.Create(idsPath)
+		if err != nil {
+			return 0, fmt.Errorf("error creating id file: %w", err)
+		}
+		defer ids.Close()
+		exporter, err = newNPYExporter(file, ids)
+		if err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("unknown export format %q", format)
+	}
+
+	filter := bson.M{}
+	if tenant != "" {
+		filter = tenantFilter(tenant)
+	}
+	cursor, err := client.Database(dbName).Collection(targetCollection).Find(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("error finding embeddings: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var written int64
+	for cursor.Next(ctx) {
+		var doc PropertyWithEmbedding
+		if err := decodeStoredDocument(cursor.Current, &doc); err != nil {
+			return written, fmt.Errorf("error decoding document: %w", err)
+		}
+		if err := exporter.write(&doc); err != nil {
+			return written, fmt.Errorf("error exporting property %s: %w", doc.Metadata.ID.Hex(), err)
+		}
+		written++
+	}
+	if err := cursor.Err(); err != nil {
+		return written, fmt.Errorf("cursor error: %w", err)
+	}
+	if err := exporter.close(); err != nil {
+		return written, fmt.Errorf("error writing export: %w", err)
+	}
+	return written, nil
+}
+
+// jsonlExporter writes each document as a JSON line
+type jsonlExporter struct {
+	writer *bufio.Writer
+}
+
+func newJSONLExporter(w io.Writer) *jsonlExporter {
+	return &jsonlExporter{writer: bufio.NewWriter(w)}
+}
+
+func (e *jsonlExporter) write(doc *PropertyWithEmbedding) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	_, err = e.writer.Write(append(data, '\n'))
+	return err
+}
+
+func (e *jsonlExporter) close() error { return e.writer.Flush() }
+
+// csvExporter writes the property ID followed by one column per vector value
+type csvExporter struct {
+	writer     *csv.Writer
+	dimensions int
+}
+
+func newCSVExporter(w io.Writer) *csvExporter {
+	return &csvExporter{writer: csv.NewWriter(w)}
+}
+
+func (e *csvExporter) write(doc *PropertyWithEmbedding) error {
+	if e.dimensions == 0 {
+		e.dimensions = len(doc.Embeddings)
+		header := make([]string, e.dimensions+1)
+		header[0] = "id"
+		for i := 1; i <= e.dimensions; i++ {
+			header[i] = "v" + strconv.Itoa(i-1)
+		}
+		if err := e.writer.Write(header); err != nil {
+			return err
+		}
+	}
+	if len(doc.Embeddings) != e.dimensions {
+		return fmt.Errorf("%w: expected %d, got %d", errDimensionMismatch, e.dimensions, len(doc.Embeddings))
+	}
+	record := make([]string, len(doc.Embeddings)+1)
+	record[0] = doc.Metadata.ID.Hex()
+	for i, value := range doc.Embeddings {
+		record[i+1] = strconv.FormatFloat(float64(value), 'g', -1, 32)
+	}
+	return e.writer.Write(record)
+}
+
+func (e *csvExporter) close() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// npyExporter writes a NumPy float32 matrix with one row per document, and the
+// property IDs in row order to a separate text file
+type npyExporter struct {
+	file       *os.File
+	writer     *bufio.Writer
+	ids        *bufio.Writer
+	rows       int
+	dimensions int
+}
+
+// Start an .npy file with a placeholder header, rewritten by close
+func newNPYExporter(file *os.File, ids io.Writer) (*npyExporter, error) {
+	e := &npyExporter{file: file, writer: bufio.NewWriter(file), ids: bufio.NewWriter(ids)}
+	if _, err := e.writer.Write(npyHeader(0, 0)); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *npyExporter) write(doc *PropertyWithEmbedding) error {
+	if e.rows == 0 {
+		e.dimensions = len(doc.Embeddings)
+	}
+	if len(doc.Embeddings) != e.dimensions {
+		return fmt.Errorf("%w: expected %d, got %d", errDimensionMismatch, e.dimensions, len(doc.Embeddings))
+	}
+	var buf [4]byte
+	for _, value := range doc.Embeddings {
+		binary.LittleEndian.PutUint32(buf[:], math.Float32bits(value))
+		if _, err := e.writer.Write(buf[:]); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintln(e.ids, doc.Metadata.ID.Hex()); err != nil {
+		return err
+	}
+	e.rows++
+	return nil
+}
+
+func (e *npyExporter) close() error {
+	if err := e.writer.Flush(); err != nil {
+		return err
+	}
+	if err := e.ids.Flush(); err != nil {
+		return err
+	}
+	_, err := e.file.WriteAt(npyHeader(e.rows, e.dimensions), 0)
+	return err
+}
+
+// Build a version 1.0 .npy header for a little-endian float32 matrix, padded
+// with spaces to npyHeaderLength
+func npyHeader(rows, dimensions int) []byte {
+	dict := fmt.Sprintf("{'descr': '<f4', 'fortran_order': False, 'shape': (%d, %d), }", rows, dimensions)
+	header := make([]byte, 0, npyHeaderLength)
+	header = append(header, "\x93NUMPY\x01\x00"...)
+	header = binary.LittleEndian.AppendUint16(header, npyHeaderLength-10)
+	header = append(header, dict...)
+	for len(header) < npyHeaderLength-1 {
+		header = append(header, ' ')
+	}
+	return append(header, '\n')
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Percentage thresholds only apply once this many properties were processed,
+// so a couple of early failures can't abort the run
+const minFailureSample = 100
+
+// Properties processed and failed across all workers
+var (
+	propertiesProcessed atomic.Int64
+	propertiesFailed    atomic.Int64
+)
+
+// failureThreshold aborts the run after too many failed properties
+type failureThreshold struct {
+	count   int64   // absolute number of failures (unlimited when 0)
+	percent float64 // share of processed properties, in percent (unlimited when 0)
+}
+
+// Abort threshold for failed properties (unlimited by default)
+var maxFailures failureThreshold
+
+// Failures tolerated at the end of a run before it exits non-zero (unlimited by default)
+var failThreshold failureThreshold
+
+// Parse "N" as an absolute failure count or "N%" as a failure rate
+func parseFailureThreshold(value string) (failureThreshold, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return failureThreshold{}, nil
+	}
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		p, err := strconv.ParseFloat(strings.TrimSpace(percent), 64)
+		if err != nil || p <= 0 || p > 100 {
+			return failureThreshold{}, fmt.Errorf("invalid failure rate %q: must be between 0 and 100%%", value)
+		}
+		return failureThreshold{percent: p}, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 1 {
+		return failureThreshold{}, fmt.Errorf("invalid failure count %q: must be a positive integer", value)
+	}
+	return failureThreshold{count: n}, nil
+}
+
+// Record failed properties and abort the run once the threshold is crossed
+func recordFailures(n int) {
+	failed := propertiesFailed.Add(int64(n))
+	processed := propertiesProcessed.Load()
+	switch {
+	case maxFailures.count > 0 && failed >= maxFailures.count:
+		abortRun(fmt.Errorf("%d properties failed, reaching -max-failures %d", failed, maxFailures.count))
+	case maxFailures.percent > 0 && processed >= minFailureSample && failureRate(failed, processed) >= maxFailures.percent:
+		abortRun(fmt.Errorf("%.1f%% of %d properties failed, reaching -max-failures %g%%",
+			failureRate(failed, processed), processed, maxFailures.percent))
+	}
+}
+
+// Failed properties as a percentage of processed ones
+func failureRate(failed, processed int64) float64 {
+	if processed == 0 {
+		return 0
+	}
+	return float64(failed) / float64(processed) * 100
+}
+
+// Check whether more properties failed than the threshold tolerates. Unlike
+// -max-failures this is checked once, when the run ends.
+func (t failureThreshold) exceeded(failed, processed int64) bool {
+	switch {
+	case t.count > 0:
+		return failed > t.count
+	case t.percent > 0:
+		return failureRate(failed, processed) > t.percent
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Weight key for the main description embedding in -field-weights
+const fullEmbeddingField = "full"
+
+// Property fields that can be embedded on their own, by name
+var embeddableFields = map[string]func(*Property) string{
+	"title": func(p *Property) string {
+		if p.Ad == nil {
+			return ""
+		}
+		return p.Ad.Title
+	},
+	"description": func(p *Property) string {
+		if p.Ad == nil {
+			return ""
+		}
+		return p.Ad.Description
+	},
+	"features": func(p *Property) string {
+		return strings.Join(p.Features, ", ")
+	},
+}
+
+// Fields embedded separately into field_embeddings (none when empty)
+var fieldEmbeddingNames []string
+
+// Parse a comma-separated list of embeddable field names
+func parseFieldEmbeddings(value string) ([]string, error) {
+	names := splitList(value)
+	for _, name := range names {
+		if _, ok := embeddableFields[name]; !ok {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+	}
+	return names, nil
+}
+
+// Embed each configured field of a property; empty fields are left out
+func generateFieldEmbeddings(ctx context.Context, property *Property) (map[string][]float32, error) {
+	if len(fieldEmbeddingNames) == 0 {
+		return nil, nil
+	}
+	embeddings := make(map[string][]float32, len(fieldEmbeddingNames))
+	for _, name := range fieldEmbeddingNames {
+		text := strings.TrimSpace(embeddableFields[name](property))
+		if text == "" {
+			continue
+		}
+		embedding, err := generateEmbedding(ctx, text)
+		if err != nil {
+			return nil, fmt.Errorf("error embedding field %s: %w", name, err)
+		}
+		embeddings[name] = embedding
+	}
+	return embeddings, nil
+}
+
+// Parse search weights such as "title=2,description=1,full=0.5"
+func parseFieldWeights(value string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for _, entry := range splitList(value) {
+		name, weight, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if _, known := embeddableFields[name]; !ok || (!known && name != fullEmbeddingField) {
+			return nil, fmt.Errorf("invalid field weight %q", entry)
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(weight), 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid weight in field weight %q", entry)
+		}
+		weights[name] = w
+	}
+	return weights, nil
+}
+
+// Combine per-field similarities into one score. Each field's cosine
+// similarity is weighted and the sum is divided by the total weight of the
+// fields the document actually has, so a missing field neither helps nor
+// hurts. Returns false when none of the weighted fields are present.
+func weightedSimilarity(
+	query []float32,
+	full []float32,
+	fields map[string][]float32,
+	weights map[string]float64,
+) (float64, bool) {
+	var score, totalWeight float64
+	for name, weight := range weights {
+		embedding := fields[name]
+		if name == fullEmbeddingField {
+			embedding = full
+		}
+		if weight == 0 || len(embedding) != len(query) {
+			continue
+		}
+		score += weight * cosineSimilarity(query, embedding)
+		totalWeight += weight
+	}
+	if totalWeight == 0 {
+		return 0, false
+	}
+	return score / totalWeight, true
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"math"
+	"testing"
+)
+
+func TestParseFieldWeights(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    map[string]float64
+		wantErr bool
+	}{
+		{value: "", want: map[string]float64{}},
+		{value: "title=2, description=1, full=0.5", want: map[string]float64{"title": 2, "description": 1, "full": 0.5}},
+		{value: "features=0", want: map[string]float64{"features": 0}},
+		{value: "price=1", wantErr: true},
+		{value: "title", wantErr: true},
+		{value: "title=-1", wantErr: true},
+		{value: "title=high", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseFieldWeights(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFieldWeights error = %v, want error %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && !maps.Equal(got, tt.want) {
+				t.Errorf("parseFieldWeights = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWeightedSimilarity(t *testing.T) {
+	query := []float32{1, 0}
+	full := []float32{0, 1}
+	fields := map[string][]float32{
+		"title":       {1, 0},
+		"description": {-1, 0},
+		"features":    {1, 0, 0},
+	}
+
+	tests := []struct {
+		name    string
+		weights map[string]float64
+		want    float64
+		ok      bool
+	}{
+		{name: "single field", weights: map[string]float64{"title": 1}, want: 1, ok: true},
+		{name: "full embedding", weights: map[string]float64{"full": 1}, want: 0, ok: true},
+		{name: "weighted average", weights: map[string]float64{"title": 3, "description": 1}, want: 0.5, ok: true},
+		{name: "zero weight is ignored", weights: map[string]float64{"title": 1, "description": 0}, want: 1, ok: true},
+		{name: "missing field neither helps nor hurts", weights: map[string]float64{"title": 1, "unknown": 5}, want: 1, ok: true},
+		{name: "other dimension is ignored", weights: map[string]float64{"description": 1, "features": 1}, want: -1, ok: true},
+		{name: "no weighted field present", weights: map[string]float64{"features": 1}, ok: false},
+		{name: "no weights", weights: nil, ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := weightedSimilarity(query, full, fields, tt.weights)
+			if ok != tt.ok || math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("weightedSimilarity = %v, %t, want %v, %t", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+// Each configured field is embedded separately and empty fields are left out
+func TestGenerateFieldEmbeddings(t *testing.T) {
+	setupWorkerTest(t, stubProvider{}, 10)
+	defer func(names []string) { fieldEmbeddingNames = names }(fieldEmbeddingNames)
+	fieldEmbeddingNames = []string{"title", "description", "features"}
+
+	embeddings, err := generateFieldEmbeddings(context.Background(), &Property{
+		Ad:       &Ad{Title: "Flat", Description: "  "},
+		Features: []string{"pool"},
+	})
+	if err != nil {
+		t.Fatalf("generateFieldEmbeddings: %v", err)
+	}
+	if len(embeddings) != 2 || embeddings["title"] == nil || embeddings["features"] == nil {
+		t.Errorf("embedded fields %v, want title and features", embeddings)
+	}
+}
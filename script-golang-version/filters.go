@@ -0,0 +1,112 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// FilterFields holds normalized values used for structured filtering at search time
+type FilterFields struct {
+	City            string `bson:"city,omitempty" json:"city,omitempty"`
+	State           string `bson:"state,omitempty" json:"state,omitempty"`
+	PropertyType    string `bson:"property_type,omitempty" json:"property_type,omitempty"`
+	TransactionType string `bson:"transaction_type,omitempty" json:"transaction_type,omitempty"`
+	Bedrooms        int    `bson:"bedrooms,omitempty" json:"bedrooms,omitempty"`
+	PriceBucket     string `bson:"price_bucket,omitempty" json:"price_bucket,omitempty"`
+}
+
+// priceBucket is an upper bound (exclusive) and the label for prices below it
+type priceBucket struct {
+	upTo  float64
+	label string
+}
+
+// Price buckets for rentals (monthly) and sales
+var (
+	rentPriceBuckets = []priceBucket{
+		{1000, "rent_lt_1k"},
+		{2000, "rent_1k_2k"},
+		{3000, "rent_2k_3k"},
+		{5000, "rent_3k_5k"},
+		{10000, "rent_5k_10k"},
+	}
+	salePriceBuckets = []priceBucket{
+		{200000, "sale_lt_200k"},
+		{500000, "sale_200k_500k"},
+		{1000000, "sale_500k_1m"},
+		{2000000, "sale_1m_2m"},
+		{5000000, "sale_2m_5m"},
+	}
+)
+
+// Matches a trailing state suffix such as "São Paulo - SP" or "Campinas/SP"
+var citySuffixPattern = regexp.MustCompile(`\s*[-/,]\s*[a-z]{2}$`)
+
+// Extract normalized filter fields from a property
+func extractFilterFields(property *Property) *FilterFields {
+	filter := &FilterFields{
+		City:         normalizeCity(property.City),
+		State:        strings.ToUpper(normalizeText(property.State)),
+		PropertyType: normalizeText(property.PropertyType),
+		Bedrooms:     property.Bedrooms,
+	}
+
+	var isRent bool
+	if property.Ad != nil {
+		filter.TransactionType = normalizeTransactionType(property.Ad.TransactionType)
+		isRent = filter.TransactionType == "rent"
+	}
+
+	if isRent && property.RentPrice > 0 {
+		filter.PriceBucket = bucketPrice(property.RentPrice, rentPriceBuckets, "rent_10k_plus")
+	} else if property.AskingPrice > 0 {
+		filter.PriceBucket = bucketPrice(property.AskingPrice, salePriceBuckets, "sale_5m_plus")
+	}
+
+	return filter
+}
+
+// Lowercase, strip accents and collapse whitespace
+func normalizeText(value string) string {
+	stripped, _, err := transform.String(
+		transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), value)
+	if err != nil {
+		stripped = value
+	}
+	return strings.Join(strings.Fields(strings.ToLower(stripped)), " ")
+}
+
+// Normalize a city name, dropping any trailing state abbreviation
+func normalizeCity(city string) string {
+	return strings.TrimSpace(citySuffixPattern.ReplaceAllString(normalizeText(city), ""))
+}
+
+// Map the free-form transaction type onto "rent" or "sale"
+func normalizeTransactionType(transactionType string) string {
+	value := normalizeText(transactionType)
+	switch {
+	case value == "":
+		return ""
+	case strings.Contains(value, "rent"), strings.Contains(value, "alug"), strings.Contains(value, "loca"):
+		return "rent"
+	case strings.Contains(value, "sale"), strings.Contains(value, "sell"), strings.Contains(value, "vend"):
+		return "sale"
+	default:
+		return value
+	}
+}
+
+// Find the bucket label for a price
+func bucketPrice(price float64, buckets []priceBucket, overflowLabel string) string {
+	for _, bucket := range buckets {
+		if price < bucket.upTo {
+			return bucket.label
+		}
+	}
+	return overflowLabel
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNormalizeText(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"São Paulo", "sao paulo"},
+		{"  APARTAMENTO   Duplex ", "apartamento duplex"},
+		{"Ribeirão Preto", "ribeirao preto"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeText(tt.in); got != tt.want {
+			t.Errorf("normalizeText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeCity(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"SÃO PAULO - SP", "sao paulo"},
+		{"Campinas/SP", "campinas"},
+		{"Curitiba, PR", "curitiba"},
+		{"Florianópolis", "florianopolis"},
+		{"Rio de Janeiro", "rio de janeiro"},
+	}
+	for _, tt := range tests {
+		if got := normalizeCity(tt.in); got != tt.want {
+			t.Errorf("normalizeCity(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeTransactionType(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"RENT", "rent"},
+		{"Aluguel", "rent"},
+		{"Locação", "rent"},
+		{"SALE", "sale"},
+		{"Venda", "sale"},
+		{"for sell", "sale"},
+		{"Temporada", "temporada"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeTransactionType(tt.in); got != tt.want {
+			t.Errorf("normalizeTransactionType(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestExtractFilterFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		property Property
+		want     FilterFields
+	}{
+		{
+			name: "rent listing buckets the rent price",
+			property: Property{
+				City: "São Paulo - SP", State: "sp", PropertyType: "APARTAMENTO", Bedrooms: 3,
+				Ad: &Ad{TransactionType: "Aluguel"}, RentPrice: 2500, AskingPrice: 800000,
+			},
+			want: FilterFields{
+				City: "sao paulo", State: "SP", PropertyType: "apartamento",
+				TransactionType: "rent", Bedrooms: 3, PriceBucket: "rent_2k_3k",
+			},
+		},
+		{
+			name:     "sale listing buckets the asking price",
+			property: Property{Ad: &Ad{TransactionType: "SALE"}, AskingPrice: 750000},
+			want:     FilterFields{TransactionType: "sale", PriceBucket: "sale_500k_1m"},
+		},
+		{
+			name:     "prices above the last bucket",
+			property: Property{Ad: &Ad{TransactionType: "rent"}, RentPrice: 25000},
+			want:     FilterFields{TransactionType: "rent", PriceBucket: "rent_10k_plus"},
+		},
+		{
+			name:     "no ad falls back to the asking price",
+			property: Property{AskingPrice: 6000000},
+			want:     FilterFields{PriceBucket: "sale_5m_plus"},
+		},
+		{
+			name:     "no prices",
+			property: Property{City: "Curitiba"},
+			want:     FilterFields{City: "curitiba"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractFilterFields(&tt.property); *got != tt.want {
+				t.Errorf("extractFilterFields = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// How long a successful embedding provider check is trusted before probing again
+const embeddingCheckTTL = 30 * time.Second
+
+// healthServer exposes liveness and readiness probes during a batch run
+type healthServer struct {
+	server         *http.Server
+	client         *mongo.Client
+	providers      []EmbeddingProvider
+	workersRunning atomic.Int32
+
+	mu               sync.Mutex
+	embeddingOKUntil time.Time
+}
+
+// Start the health server on the given port, probing the given embedding providers
+func startHealthServer(port int, client *mongo.Client, providers []EmbeddingProvider) *healthServer {
+	h := &healthServer{client: client, providers: providers}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", h.handleReady)
+	h.server = &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: mux}
+
+	go func() {
+		if err := h.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Health server error", "error", err)
+		}
+	}()
+	slog.Info("Health server listening (/livez, /readyz)", "port", port)
+	return h
+}
+
+// Report ready only while workers are running and Mongo and the embedding providers are reachable
+func (h *healthServer) handleReady(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := h.checkReady(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (h *healthServer) checkReady(ctx context.Context) error {
+	if h.workersRunning.Load() == 0 {
+		return errors.New("no workers running")
+	}
+	if h.client != nil {
+		if err := h.client.Ping(ctx, nil); err != nil {
+			return fmt.Errorf("mongo unreachable: %w", err)
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if dryRun || time.Now().Before(h.embeddingOKUntil) {
+		return nil
+	}
+	// Embedding a short text is the one request every provider supports
+	for _, provider := range h.providers {
+		if _, err := provider.Embed(ctx, "readiness check"); err != nil {
+			return fmt.Errorf("embedding model %s unreachable: %w", provider.Model(), err)
+		}
+	}
+	h.embeddingOKUntil = time.Now().Add(embeddingCheckTTL)
+	return nil
+}
+
+// Track a worker starting or finishing
+func (h *healthServer) workerStarted()  { h.workersRunning.Add(1) }
+func (h *healthServer) workerFinished() { h.workersRunning.Add(-1) }
+
+// Stop the health server, waiting briefly for in-flight probes
+func (h *healthServer) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := h.server.Shutdown(ctx); err != nil {
+		slog.Error("Error shutting down health server", "error", err)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+)
+
+// Every configured provider is probed, not only Gemini, and a successful
+// check is trusted for embeddingCheckTTL
+func TestHealthCheckReadyProbesEveryProvider(t *testing.T) {
+	defer func(dry bool) { dryRun = dry }(dryRun)
+	dryRun = false
+
+	failing := &statusProvider{code: codes.Unavailable}
+	h := &healthServer{providers: []EmbeddingProvider{stubProvider{}, failing}}
+	if err := h.checkReady(context.Background()); err == nil || err.Error() != "no workers running" {
+		t.Fatalf("checkReady without workers = %v, want no workers running", err)
+	}
+	h.workerStarted()
+
+	err := h.checkReady(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "embedding model status unreachable") {
+		t.Errorf("checkReady with an unreachable provider = %v, want it reported", err)
+	}
+	if failing.requests != 1 {
+		t.Errorf("probed the failing provider %d times, want 1", failing.requests)
+	}
+
+	reachable := &emptyProvider{values: []float32{1}}
+	h.providers = []EmbeddingProvider{stubProvider{}, reachable}
+	for i := 0; i < 2; i++ {
+		if err := h.checkReady(context.Background()); err != nil {
+			t.Fatalf("checkReady with reachable providers: %v", err)
+		}
+	}
+	if reachable.requests != 1 {
+		t.Errorf("probed a reachable provider %d times, want 1 within the check TTL", reachable.requests)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Keys holding human-readable text in image documents, in order of preference
+var imageCaptionKeys = []string{"caption", "alt", "altText", "alt_text", "description", "title"}
+
+// Collect the captions or alt texts of image entries. Entries are bare URL
+// strings or documents, decoded as primitive.D from MongoDB or as maps from
+// JSON; URLs carry no meaning for the embedding and are ignored.
+func imageCaptions(images []interface{}) []string {
+	var captions []string
+	for _, image := range images {
+		var fields map[string]interface{}
+		switch entry := image.(type) {
+		case primitive.D:
+			fields = entry.Map()
+		case primitive.M:
+			fields = entry
+		case map[string]interface{}:
+			fields = entry
+		default:
+			continue
+		}
+		for _, key := range imageCaptionKeys {
+			if caption, ok := fields[key].(string); ok && strings.TrimSpace(caption) != "" {
+				captions = append(captions, strings.TrimSpace(caption))
+				break
+			}
+		}
+	}
+	return captions
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Only process source properties updated after this time (disabled when zero)
+var updatedSince time.Time
+
+// Configured start of an incremental run, used until a high-water mark is stored
+var incrementalSince time.Time
+
+// Find the newest createdAt among stored embeddings. Returns false when the
+// target has no timestamped documents, meaning a full run is needed.
+func computeWatermark(ctx context.Context, targetDB propertyCollection) (time.Time, bool, error) {
+	var newest struct {
+		CreatedAt time.Time `bson:"createdAt"`
+	}
+	err := targetDB.FindOne(ctx,
+		bson.M{"createdAt": bson.M{"$exists": true}},
+		options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetProjection(bson.M{"createdAt": 1}),
+	).Decode(&newest)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("error finding newest embedding: %w", err)
+	}
+	return newest.CreatedAt, true, nil
+}
+
+// File storing the newest source updatedAt seen by a completed run
+// (disabled when empty)
+var highWaterMarkFile string
+
+// Newest source updatedAt seen by this run
+var (
+	highWaterMu   sync.Mutex
+	highWaterMark time.Time
+)
+
+// highWaterMarkState is the JSON stored in HIGH_WATER_MARK_FILE
+type highWaterMarkState struct {
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Read the stored high-water mark. Returns false when the file doesn't exist
+// yet, meaning INCREMENTAL_SINCE (or a full run) applies.
+func loadHighWaterMark(path string) (time.Time, bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("error reading high-water mark: %w", err)
+	}
+	var state highWaterMarkState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return time.Time{}, false, fmt.Errorf("error parsing high-water mark %s: %w", path, err)
+	}
+	return state.UpdatedAt, true, nil
+}
+
+// Store the high-water mark for the next run
+func saveHighWaterMark(path string, mark time.Time) error {
+	data, err := json.MarshalIndent(highWaterMarkState{UpdatedAt: mark}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("error writing high-water mark: %w", err)
+	}
+	return nil
+}
+
+// Track the newest updatedAt among processed properties
+func observeUpdatedAt(updatedAt *time.Time) {
+	if updatedAt == nil {
+		return
+	}
+	highWaterMu.Lock()
+	defer highWaterMu.Unlock()
+	if updatedAt.After(highWaterMark) {
+		highWaterMark = *updatedAt
+	}
+}
+
+// The newest updatedAt seen so far, or the zero time
+func currentHighWaterMark() time.Time {
+	highWaterMu.Lock()
+	defer highWaterMu.Unlock()
+	return highWaterMark
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestComputeWatermark(t *testing.T) {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		created []time.Time // createdAt of each stored document, zero for untimestamped ones
+		want    time.Time
+		found   bool
+	}{
+		{name: "empty target", found: false},
+		{name: "newest of several", created: []time.Time{base, base.Add(2 * time.Hour), base.Add(time.Hour)},
+			want: base.Add(2 * time.Hour), found: true},
+		{name: "untimestamped documents are ignored", created: []time.Time{{}, base, {}}, want: base, found: true},
+		{name: "only untimestamped documents", created: []time.Time{{}}, found: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &memoryCollection{}
+			for _, created := range tt.created {
+				document := map[string]interface{}{"description_text": "stored"}
+				if !created.IsZero() {
+					document["createdAt"] = created
+				}
+				if _, err := target.InsertMany(context.Background(), []interface{}{document}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			watermark, found, err := computeWatermark(context.Background(), target)
+			if err != nil {
+				t.Fatalf("computeWatermark: %v", err)
+			}
+			if found != tt.found || !watermark.Equal(tt.want) {
+				t.Errorf("computeWatermark = %s, %t, want %s, %t", watermark, found, tt.want, tt.found)
+			}
+		})
+	}
+}
+
+func TestObserveUpdatedAt(t *testing.T) {
+	defer func(mark time.Time) { highWaterMark = mark }(highWaterMark)
+	highWaterMark = time.Time{}
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	older, newer := base.Add(-time.Hour), base.Add(time.Hour)
+
+	steps := []struct {
+		updatedAt *time.Time
+		want      time.Time
+	}{
+		{updatedAt: nil, want: time.Time{}},
+		{updatedAt: &base, want: base},
+		{updatedAt: &older, want: base},
+		{updatedAt: nil, want: base},
+		{updatedAt: &newer, want: newer},
+	}
+	for i, step := range steps {
+		observeUpdatedAt(step.updatedAt)
+		if got := currentHighWaterMark(); !got.Equal(step.want) {
+			t.Errorf("after step %d the high-water mark is %s, want %s", i+1, got, step.want)
+		}
+	}
+}
+
+func TestHighWaterMarkFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "high-water-mark.json")
+	if _, found, err := loadHighWaterMark(path); err != nil || found {
+		t.Fatalf("loading a missing file = %t, %v, want not found", found, err)
+	}
+
+	mark := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	if err := saveHighWaterMark(path, mark); err != nil {
+		t.Fatalf("saveHighWaterMark: %v", err)
+	}
+	loaded, found, err := loadHighWaterMark(path)
+	if err != nil || !found || !loaded.Equal(mark) {
+		t.Errorf("loadHighWaterMark = %s, %t, %v, want %s", loaded, found, err, mark)
+	}
+}
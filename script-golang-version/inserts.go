@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Number of full batches each worker queues for its insert goroutine before
+// embedding waits for the inserts to catch up
+var insertQueueSize int
+
+// writeBatch is a batch of documents written to the target collection together
+type writeBatch struct {
+	inserts      []interface{}
+	replacements []interface{}        // documents replacing stored ones, only batched with -transactional
+	replacedIDs  []primitive.ObjectID // property IDs of replacements, in order
+	checkpoint   *batchCheckpoint     // written in the same transaction, nil for none
+}
+
+// insertJob is a batch of documents queued for a worker's insert goroutine
+type insertJob struct {
+	batch      writeBatch
+	ids        []primitive.ObjectID // property IDs of batch.inserts, in order
+	checkpoint primitive.ObjectID   // last property handled before the batch was queued, zero if none
+	processed  int                  // properties the worker had processed, for logging
+	final      bool
+}
+
+// Queue the collected documents for insertion, starting the insert goroutine
+// on first use. Sending blocks while INSERT_QUEUE_SIZE batches are already
+// waiting, so embedding never runs too far ahead of the writes.
+func (w *propertyWorker) queueInsert(ctx context.Context, final bool) {
+	if w.inserts == nil {
+		w.inserts = make(chan insertJob, insertQueueSize)
+		w.insertsDone = make(chan struct{})
+		// Queued batches are still written after the run is aborted
+		go w.runInserts(context.WithoutCancel(ctx), w.inserts, w.insertsDone)
+	}
+	w.insertsPending.Add(1)
+	w.inserts <- insertJob{
+		batch: writeBatch{
+			inserts:      w.batchDocuments,
+			replacements: w.batchReplacements,
+			replacedIDs:  w.batchReplacedIDs,
+		},
+		ids:        w.batchIDs,
+		checkpoint: w.lastHandled,
+		processed:  w.processed,
+		final:      final,
+	}
+	w.batchDocuments, w.batchIDs = nil, nil
+	w.batchReplacements, w.batchReplacedIDs = nil, nil
+}
+
+// Write queued batches in order until the queue is closed
+func (w *propertyWorker) runInserts(ctx context.Context, jobs <-chan insertJob, done chan<- struct{}) {
+	defer close(done)
+	for job := range jobs {
+		w.insert(ctx, job)
+		w.insertsPending.Add(-1)
+	}
+}
+
+// Write one batch, checkpointing the properties handled before it once it's
+// stored. With -transactional the checkpoint is part of the batch's transaction.
+func (w *propertyWorker) insert(ctx context.Context, job insertJob) {
+	label := "batch"
+	if job.final {
+		label = "final batch"
+	}
+	batch := job.batch
+	batch.checkpoint = w.batchCheckpoint(job.checkpoint)
+	size := len(batch.inserts) + len(batch.replacements)
+	start := time.Now()
+	inserted, err := insertBatch(ctx, w.targetDB, batch)
+	if err != nil {
+		w.logger.Error("Error inserting "+label, "inserted", inserted, "batch_size", size,
+			"duration_ms", durationMillis(start), "error", err)
+		ids := append(failedInsertIDs(err, job.ids), batch.replacedIDs...)
+		w.failInsert(ctx, err, ids...)
+		return
+	}
+	w.logger.Info("Inserted "+label, "batch_size", size,
+		"processed_count", job.processed, "duration_ms", durationMillis(start))
+	w.checkpoint(job.checkpoint)
+}
+
+// The checkpoint written along with a transactional batch, nil without
+// transactions or while the checkpoint is held. Called from the insert
+// goroutine, so failures of the batches before it were already recorded.
+func (w *propertyWorker) batchCheckpoint(id primitive.ObjectID) *batchCheckpoint {
+	if !useTransactions || checkpoints == nil || id.IsZero() || w.checkpointHeld.Load() {
+		return nil
+	}
+	return &batchCheckpoint{File: checkpointFile, Worker: w.id, LastID: id}
+}
+
+// Wait until every queued batch is written and stop the insert goroutine
+func (w *propertyWorker) waitForInserts() {
+	if w.inserts == nil {
+		return
+	}
+	close(w.inserts)
+	<-w.insertsDone
+	w.inserts, w.insertsDone = nil, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// JSONL files used instead of the source and target collections (unset for Mongo)
+var (
+	inputFile  string
+	outputFile string
+)
+
+// Shared input and output files, set in main when configured
+var (
+	inputSource  *jsonlSource
+	outputWriter *jsonlWriter
+)
+
+// propertySource yields the properties a worker processes
+type propertySource interface {
+	// Read the next property into property, reporting false at the end.
+	// An error for a single property still reports true so reading continues.
+	Next(ctx context.Context, property *Property) (bool, error)
+	// Error that ended reading, if any
+	Err() error
+}
+
+// cursorSource reads properties from a source collection cursor
+type cursorSource struct {
+	cursor *mongo.Cursor
+}
+
+func (s cursorSource) Next(ctx context.Context, property *Property) (bool, error) {
+	if !s.cursor.Next(ctx) {
+		return false, nil
+	}
+	return true, decodeSourceProperty(s.cursor, property)
+}
+
+func (s cursorSource) Err() error { return s.cursor.Err() }
+
+// jsonlSource reads one Property per line from a JSONL file. It's shared by
+// all workers, which each take the next line in turn.
+type jsonlSource struct {
+	file *os.File
+
+	mu     sync.Mutex
+	reader *bufio.Reader
+	line   int
+	err    error
+}
+
+// Open a JSONL file of properties
+func openJSONLSource(path string) (*jsonlSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening input file: %w", err)
+	}
+	return &jsonlSource{file: file, reader: bufio.NewReader(file)}, nil
+}
+
+func (s *jsonlSource) Next(ctx context.Context, property *Property) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.err == nil {
+		if err := ctx.Err(); err != nil {
+			s.err = err
+			break
+		}
+		data, err := s.reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			s.err = fmt.Errorf("error reading %s: %w", s.file.Name(), err)
+			break
+		}
+		if len(data) > 0 {
+			s.line++
+		}
+		data = bytes.TrimSpace(data)
+		if len(data) > 0 {
+			return true, s.decode(data, property)
+		}
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+	}
+	return false, nil
+}
+
+// Decode one line into property, reading EMBED_SOURCE_FIELD into EmbedText
+func (s *jsonlSource) decode(data []byte, property *Property) error {
+	*property = Property{}
+	if err := json.Unmarshal(data, property); err != nil {
+		return fmt.Errorf("line %d: %w", s.line, err)
+	}
+	if embedSourceField != "" {
+		var raw map[string]interface{}
+		if err := json.Unmarshal(data, &raw); err == nil {
+			property.EmbedText, _ = lookupJSONField(raw, strings.Split(embedSourceField, ".")).(string)
+		}
+	}
+	return nil
+}
+
+func (s *jsonlSource) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *jsonlSource) Close() error {
+	return s.file.Close()
+}
+
+// Follow a dotted path through decoded JSON objects
+func lookupJSONField(value interface{}, path []string) interface{} {
+	for _, key := range path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+// Count the non-empty lines of a JSONL file
+func countJSONLRecords(path string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("error opening input file: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var count int64
+	for {
+		data, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(data)) > 0 {
+			count++
+		}
+		if errors.Is(err, io.EOF) {
+			return count, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("error reading %s: %w", path, err)
+		}
+	}
+}
+
+// jsonlWriter writes one PropertyWithEmbedding per line, shared by all workers
+type jsonlWriter struct {
+	file *os.File
+
+	mu     sync.Mutex
+	writer *bufio.Writer
+}
+
+// Create (or truncate) the output file
+func createJSONLWriter(path string) (*jsonlWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating output file: %w", err)
+	}
+	return &jsonlWriter{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+func (w *jsonlWriter) write(doc PropertyWithEmbedding) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.writer.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("error writing %s: %w", w.file.Name(), err)
+	}
+	return nil
+}
+
+// Flush buffered lines and close the file
+func (w *jsonlWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.writer.Flush(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("error writing %s: %w", w.file.Name(), err)
+	}
+	return w.file.Close()
+}
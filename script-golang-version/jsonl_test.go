@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestJSONLDecodeReadsEmbedSourceField(t *testing.T) {
+	defer func(field string) { embedSourceField = field }(embedSourceField)
+	line := []byte(`{"city": "Curitiba", "summary": "Top-level summary", "ai": {"text": "Nested summary", "score": 3}}`)
+
+	tests := []struct {
+		field string
+		want  string
+	}{
+		{field: "", want: ""},
+		{field: "summary", want: "Top-level summary"},
+		{field: "ai.text", want: "Nested summary"},
+		{field: "ai.score", want: ""},
+		{field: "summary.text", want: ""},
+		{field: "missing", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			embedSourceField = tt.field
+			var property Property
+			if err := (&jsonlSource{}).decode(line, &property); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if property.EmbedText != tt.want || property.City != "Curitiba" {
+				t.Errorf("decoded EmbedText %q and city %q, want %q and Curitiba", property.EmbedText, property.City, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+// Field labels used in the built-in description layout
+type descriptionLabels struct {
+	Title           string
+	Description     string
+	Location        string
+	PropertyType    string
+	TransactionType string
+	Area            string
+	TotalArea       string
+	Price           string
+	PriceRange      string
+	Rent            string
+	Sale            string
+	CondoFee        string
+	Tax             string
+	CommercialID    string
+	Bedrooms        string
+	Suites          string
+	Bathrooms       string
+	ParkingSpots    string
+	Building        string
+	Exclusive       string
+	Yes             string
+	No              string
+	Features        string
+	ImageCount      string
+	ImageCaptions   string
+}
+
+// Description labels by DESCRIPTION_LOCALE
+var localeLabels = map[string]descriptionLabels{
+	"en": {
+		Title:           "Title",
+		Description:     "Description",
+		Location:        "Location",
+		PropertyType:    "Property Type",
+		TransactionType: "Transaction Type",
+		Area:            "Area",
+		TotalArea:       "Total Area",
+		Price:           "Price",
+		PriceRange:      "Price range",
+		Rent:            "Rent",
+		Sale:            "Sale",
+		CondoFee:        "Condo Fee",
+		Tax:             "Tax",
+		CommercialID:    "Commercial ID",
+		Bedrooms:        "Bedrooms",
+		Suites:          "Suites",
+		Bathrooms:       "Bathrooms",
+		ParkingSpots:    "Parking Spots",
+		Building:        "Building",
+		Exclusive:       "Exclusive",
+		Yes:             "Yes",
+		No:              "No",
+		Features:        "Features",
+		ImageCount:      "Image count",
+		ImageCaptions:   "Image captions",
+	},
+	"pt-BR": {
+		Title:           "Título",
+		Description:     "Descrição",
+		Location:        "Localização",
+		PropertyType:    "Tipo de imóvel",
+		TransactionType: "Tipo de transação",
+		Area:            "Área",
+		TotalArea:       "Área total",
+		Price:           "Preço",
+		PriceRange:      "Faixa de preço",
+		Rent:            "Aluguel",
+		Sale:            "Venda",
+		CondoFee:        "Condomínio",
+		Tax:             "IPTU",
+		CommercialID:    "Código comercial",
+		Bedrooms:        "Quartos",
+		Suites:          "Suítes",
+		Bathrooms:       "Banheiros",
+		ParkingSpots:    "Vagas de garagem",
+		Building:        "Edifício",
+		Exclusive:       "Exclusivo",
+		Yes:             "Sim",
+		No:              "Não",
+		Features:        "Características",
+		ImageCount:      "Quantidade de imagens",
+		ImageCaptions:   "Legendas das imagens",
+	},
+	"es": {
+		Title:           "Título",
+		Description:     "Descripción",
+		Location:        "Ubicación",
+		PropertyType:    "Tipo de propiedad",
+		TransactionType: "Tipo de transacción",
+		Area:            "Área",
+		TotalArea:       "Área total",
+		Price:           "Precio",
+		PriceRange:      "Rango de precio",
+		Rent:            "Alquiler",
+		Sale:            "Venta",
+		CondoFee:        "Gastos comunes",
+		Tax:             "Impuestos",
+		CommercialID:    "Código comercial",
+		Bedrooms:        "Dormitorios",
+		Suites:          "Suites",
+		Bathrooms:       "Baños",
+		ParkingSpots:    "Estacionamientos",
+		Building:        "Edificio",
+		Exclusive:       "Exclusivo",
+		Yes:             "Sí",
+		No:              "No",
+		Features:        "Características",
+		ImageCount:      "Cantidad de imágenes",
+		ImageCaptions:   "Leyendas de las imágenes",
+	},
+}
+
+// Locale of the description labels, and the labels themselves
+var (
+	descriptionLocale = "en"
+	labels            = localeLabels["en"]
+)
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// Log output settings
+var (
+	logLevel  slog.Level
+	logFormat string
+)
+
+// Log formats supported by LOG_FORMAT
+const (
+	logFormatJSON = "json"
+	logFormatText = "text"
+)
+
+// Parse LOG_LEVEL and LOG_FORMAT
+func loadLoggingConfig() error {
+	if err := logLevel.UnmarshalText([]byte(getEnv("LOG_LEVEL", "info"))); err != nil {
+		return fmt.Errorf("invalid LOG_LEVEL %q: must be debug, info, warn or error", os.Getenv("LOG_LEVEL"))
+	}
+	logFormat = strings.ToLower(getEnv("LOG_FORMAT", logFormatJSON))
+	if logFormat != logFormatJSON && logFormat != logFormatText {
+		return fmt.Errorf("invalid LOG_FORMAT %q: must be %q or %q", logFormat, logFormatJSON, logFormatText)
+	}
+	return nil
+}
+
+// Send all logs, including those written with the log package, through slog
+// on stderr in the configured format and level
+func setupLogging() {
+	options := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler = slog.NewJSONHandler(os.Stderr, options)
+	if logFormat == logFormatText {
+		handler = slog.NewTextHandler(os.Stderr, options)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// Milliseconds elapsed since start, for duration_ms fields
+func durationMillis(start time.Time) int64 {
+	return time.Since(start).Milliseconds()
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Rough number of characters per token, used to estimate input length
+const charsPerToken = 4
+
+// How embedding inputs over EMBED_MAX_TOKENS are handled
+const (
+	longTextTruncate     = "truncate"
+	longTextChunkAverage = "chunk-average"
+	// Send the whole input and let the API truncate it, like Vertex AI's
+	// autoTruncate; the Gemini API truncates over-long inputs on its own
+	longTextAPI = "api"
+)
+
+// Estimated token limit for a single embedding input (unchecked when 0) and
+// what to do with longer inputs
+var (
+	embedMaxTokens   int
+	longTextStrategy string
+)
+
+// Estimate the number of tokens in a text from its length
+func estimateTokens(text string) int {
+	return (len([]rune(text)) + charsPerToken - 1) / charsPerToken
+}
+
+// Fit an embedding input within EMBED_MAX_TOKENS, either truncating it to one
+// piece or splitting it into chunks whose embeddings are averaged. Reports
+// whether the text is over the limit, in which case the api strategy leaves
+// it whole for the API to truncate.
+func splitLongText(text string) ([]string, bool) {
+	if embedMaxTokens == 0 || estimateTokens(text) <= embedMaxTokens {
+		return []string{text}, false
+	}
+	if longTextStrategy == longTextAPI {
+		return []string{text}, true
+	}
+	limit := embedMaxTokens * charsPerToken
+	runes := []rune(text)
+
+	var chunks []string
+	for len(runes) > 0 {
+		end := chunkEnd(runes, limit)
+		if chunk := strings.TrimSpace(string(runes[:end])); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		if longTextStrategy == longTextTruncate {
+			break
+		}
+		runes = runes[end:]
+	}
+	if len(chunks) == 0 {
+		return []string{text}, false
+	}
+	return chunks, true
+}
+
+// Find where a chunk of at most limit runes should end, preferring a
+// whitespace boundary in its second half so words aren't cut in two
+func chunkEnd(runes []rune, limit int) int {
+	if len(runes) <= limit {
+		return len(runes)
+	}
+	for i := limit; i > limit/2; i-- {
+		if unicode.IsSpace(runes[i]) {
+			return i
+		}
+	}
+	return limit
+}
+
+// Average chunk embeddings into a single unit-length vector
+func averageEmbeddings(embeddings [][]float32) []float32 {
+	if len(embeddings) == 1 {
+		return embeddings[0]
+	}
+	sum := make([]float64, len(embeddings[0]))
+	for _, embedding := range embeddings {
+		for i, value := range embedding {
+			if i < len(sum) {
+				sum[i] += float64(value)
+			}
+		}
+	}
+	var norm float64
+	for _, value := range sum {
+		norm += value * value
+	}
+	norm = math.Sqrt(norm)
+
+	averaged := make([]float32, len(sum))
+	for i, value := range sum {
+		if norm > 0 {
+			averaged[i] = float32(value / norm)
+		}
+	}
+	return averaged
+}
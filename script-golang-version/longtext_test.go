@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// The api strategy leaves every input whole, but still reports the ones over
+// the limit so they're logged as likely truncated
+func TestSplitLongTextAPIStrategy(t *testing.T) {
+	defer func(tokens int, strategy string) { embedMaxTokens, longTextStrategy = tokens, strategy }(embedMaxTokens, longTextStrategy)
+	embedMaxTokens, longTextStrategy = 4, longTextAPI
+
+	tests := []struct {
+		name     string
+		text     string
+		wantOver bool
+	}{
+		{name: "within the limit", text: "casa com piscina", wantOver: false},
+		{name: "over the limit", text: strings.Repeat("apartamento ", 10), wantOver: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inputs, over := splitLongText(tt.text)
+			if len(inputs) != 1 || inputs[0] != tt.text {
+				t.Errorf("inputs = %q, want the whole text", inputs)
+			}
+			if over != tt.wantOver {
+				t.Errorf("reported over the limit = %t, want %t", over, tt.wantOver)
+			}
+		})
+	}
+}
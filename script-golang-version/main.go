@@ -1,12 +1,20 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"math"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -17,11 +25,14 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"google.golang.org/api/option"
+	"golang.org/x/time/rate"
 )
 
-// Batch size for processing
-const batchSize = 50
+// Number of documents each worker collects before inserting them
+var batchSize int
+
+// Exit code used when there was nothing to process, distinct from success with work done
+const exitNothingToDo = 3
 
 // MongoDB collection names and database
 var (
@@ -32,32 +43,206 @@ var (
 	apiKey           string
 )
 
+// Embedding provider, see embeddingProviders, and its primary model
+var (
+	embeddingProviderName string
+	embeddingModelName    string
+	offline               bool
+)
+
+// Metrics backend configuration
+var (
+	metricsBackend string
+	statsdAddr     string
+	metricsPort    int
+	metricsPrefix  string
+)
+
+// Expected embedding dimension (unchecked when 0) and what to do on mismatch
+var (
+	embedDimensions         int
+	dimensionMismatchPolicy string
+)
+
+// Policies for embeddings whose dimension differs from EMBED_DIMENSIONS
+const (
+	dimensionMismatchFail = "fail"
+	dimensionMismatchSkip = "skip"
+)
+
+// Stops the whole run with a reason, set in main
+var abortRun context.CancelCauseFunc = func(error) {}
+
+// Timeout for a single embedding API attempt
+var embedTimeout time.Duration
+
+// Upper bound on the backoff between retries
+var retryMaxBackoff time.Duration
+
+// Attempts per embedding request, and the backoff before the first retry
+var (
+	embedMaxRetries     int
+	embedInitialBackoff time.Duration
+)
+
+// Log embedding calls slower than this (disabled when 0)
+var slowEmbeddingThreshold time.Duration
+
+// Optional second embedding model combined with the primary one
+var (
+	ensembleModel    string
+	ensembleStrategy string
+	ensembleWeight   float64
+)
+
+// External sharding across processes, set from command-line flags
+var (
+	shardIndex  int
+	totalShards int
+)
+
+// Optional aggregation pipeline used instead of a plain find on the source collection
+var sourcePipeline []bson.D
+
+// Business key used to merge listings split across documents, and how conflicts are resolved
+var (
+	mergeKey    string
+	mergePolicy string
+)
+
+// Optional LLM-generated summary phase
+var (
+	summarize    bool
+	summaryModel string
+	summaryMode  string
+	summaryRPS   float64
+)
+
+// Optional translation phase for cross-lingual retrieval
+var (
+	translateTo           string
+	translationModel      string
+	translateKeepOriginal bool
+	translationRPS        float64
+)
+
+// Number of workers processing properties
+var workerCount int
+
+// Maximum number of source cursors open at once (unlimited when 0)
+var maxOpenCursors int
+
+// Sort applied to the source scan so important properties are processed first
+var prioritySort bson.D
+
+// Number of properties whose existence in the target is checked with one query
+var existenceCheckWindow int
+
+// MongoDB connection pool bounds
+var (
+	mongoMaxPoolSize uint64
+	mongoMinPoolSize uint64
+)
+
+// How long MongoDB operations wait for a suitable server before failing
+var mongoServerSelectionTimeout time.Duration
+
+// Maximum characters per description field, applied before the lines are joined
+var fieldCaps map[string]int
+
+// Description lines selected with -fields (all when nil)
+var selectedFields map[string]bool
+
+// How to handle properties whose Area exceeds their TotalArea
+var areaConflictPolicy string
+
+// Source field holding precomputed embedding input (description builder when empty)
+var embedSourceField string
+
+// Property fields kept in the stored metadata (all fields when empty)
+var metadataFields []string
+
+// Re-embed existing properties whose stored description_text differs
+var skipUnchanged bool
+
+// Re-embed and replace every existing property, whatever its description
+var forceReembed bool
+
+// Only report counts and exit without processing
+var countOnly bool
+
+// Report skipped properties in aggregate instead of one line each
+var quietSkips bool
+
+// Empty the target collection before processing, optionally without prompting
+var (
+	purgeTarget bool
+	assumeYes   bool
+)
+
+// Port for the liveness/readiness server (disabled when 0)
+var healthPort int
+
+// Write each batch in a transaction when the deployment supports it
+var transactional bool
+
+// Run a local brute-force or an Atlas Vector Search for this query instead of processing
+var (
+	dryRunSearch       string
+	vectorSearchQuery  string
+	searchK            int
+	searchMaxDocuments int64
+	searchTenant       string
+	searchFieldWeights map[string]float64
+	searchJSON         bool
+	vectorIndexName    string
+	searchMode         string
+	searchFilter       bson.M
+)
+
+// Compare the query embedding's dimension with VECTOR_INDEX's before an Atlas search
+var searchDimensionCheck bool
+
+// How -search finds the nearest embeddings
+const (
+	searchModeAtlas = "atlas"
+	searchModeBrute = "brute"
+)
+
+// Derive updatedSince from the newest stored embedding
+var autoIncremental bool
+
+// Skip source properties up to and including this _id
+var resumeFromID primitive.ObjectID
+
 // Property represents a property document from MongoDB
 type Property struct {
-	ID            primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
-	Region        string             `bson:"region,omitempty" json:"region,omitempty"`
-	City          string             `bson:"city,omitempty" json:"city,omitempty"`
-	State         string             `bson:"state,omitempty" json:"state,omitempty"`
-	Ad            *Ad                `bson:"ad,omitempty" json:"ad,omitempty"`
-	Company       *Company           `bson:"company,omitempty" json:"company,omitempty"`
-	CompanyID     string             `bson:"companyId,omitempty" json:"companyId,omitempty"`
-	Agent         *Agent             `bson:"agent,omitempty" json:"agent,omitempty"`
-	Images        []interface{}      `bson:"images,omitempty" json:"images,omitempty"`
-	Area          float64            `bson:"area,omitempty" json:"area,omitempty"`
-	RentPrice     float64            `bson:"rentPrice,omitempty" json:"rentPrice,omitempty"`
-	AskingPrice   float64            `bson:"askingPrice,omitempty" json:"askingPrice,omitempty"`
-	CommercialID  string             `bson:"commercialId,omitempty" json:"commercialId,omitempty"`
-	TotalArea     float64            `bson:"totalArea,omitempty" json:"totalArea,omitempty"`
-	Suites        int                `bson:"suites,omitempty" json:"suites,omitempty"`
-	Bedrooms      int                `bson:"bedrooms,omitempty" json:"bedrooms,omitempty"`
-	Bathrooms     int                `bson:"bathrooms,omitempty" json:"bathrooms,omitempty"`
-	ParkingSpots  int                `bson:"parkingSpots,omitempty" json:"parkingSpots,omitempty"`
-	IsExclusive   bool               `bson:"isExclusive,omitempty" json:"isExclusive,omitempty"`
-	Building      string             `bson:"building,omitempty" json:"building,omitempty"`
-	CondoFee      *float64           `bson:"condoFee,omitempty" json:"condoFee,omitempty"`
-	Tax           *float64           `bson:"tax,omitempty" json:"tax,omitempty"`
-	Features      []string           `bson:"features,omitempty" json:"features,omitempty"`
-	PropertyType  string             `bson:"propertyType,omitempty" json:"propertyType,omitempty"`
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	Region       string             `bson:"region,omitempty" json:"region,omitempty"`
+	City         string             `bson:"city,omitempty" json:"city,omitempty"`
+	State        string             `bson:"state,omitempty" json:"state,omitempty"`
+	Ad           *Ad                `bson:"ad,omitempty" json:"ad,omitempty"`
+	Company      *Company           `bson:"company,omitempty" json:"company,omitempty"`
+	CompanyID    string             `bson:"companyId,omitempty" json:"companyId,omitempty"`
+	Agent        *Agent             `bson:"agent,omitempty" json:"agent,omitempty"`
+	Images       []interface{}      `bson:"images,omitempty" json:"images,omitempty"`
+	Area         float64            `bson:"area,omitempty" json:"area,omitempty"`
+	RentPrice    float64            `bson:"rentPrice,omitempty" json:"rentPrice,omitempty"`
+	AskingPrice  float64            `bson:"askingPrice,omitempty" json:"askingPrice,omitempty"`
+	CommercialID string             `bson:"commercialId,omitempty" json:"commercialId,omitempty"`
+	TotalArea    float64            `bson:"totalArea,omitempty" json:"totalArea,omitempty"`
+	Suites       int                `bson:"suites,omitempty" json:"suites,omitempty"`
+	Bedrooms     int                `bson:"bedrooms,omitempty" json:"bedrooms,omitempty"`
+	Bathrooms    int                `bson:"bathrooms,omitempty" json:"bathrooms,omitempty"`
+	ParkingSpots int                `bson:"parkingSpots,omitempty" json:"parkingSpots,omitempty"`
+	IsExclusive  bool               `bson:"isExclusive,omitempty" json:"isExclusive,omitempty"`
+	Building     string             `bson:"building,omitempty" json:"building,omitempty"`
+	CondoFee     *float64           `bson:"condoFee,omitempty" json:"condoFee,omitempty"`
+	Tax          *float64           `bson:"tax,omitempty" json:"tax,omitempty"`
+	Features     []string           `bson:"features,omitempty" json:"features,omitempty"`
+	PropertyType string             `bson:"propertyType,omitempty" json:"propertyType,omitempty"`
+	EmbedText    string             `bson:"-" json:"-"`
+	UpdatedAt    *time.Time         `bson:"updatedAt,omitempty" json:"updatedAt,omitempty"`
 }
 
 // Ad represents the advertisement details of a property
@@ -82,19 +267,37 @@ type Agent struct {
 
 // PropertyWithEmbedding represents a property with its embedding
 type PropertyWithEmbedding struct {
-	Metadata   Property   `bson:"metadata" json:"metadata"`
-	Embeddings []float32  `bson:"embeddings" json:"embeddings"`
+	Metadata         Property             `bson:"metadata" json:"metadata"`
+	TenantID         string               `bson:"tenant_id,omitempty" json:"tenant_id,omitempty"`
+	SourceDB         string               `bson:"source_db" json:"source_db"`
+	SourceCollection string               `bson:"source_collection" json:"source_collection"`
+	Filter           *FilterFields        `bson:"filter,omitempty" json:"filter,omitempty"`
+	DescriptionText  string               `bson:"description_text,omitempty" json:"description_text,omitempty"`
+	Summary          string               `bson:"summary,omitempty" json:"summary,omitempty"`
+	Language         string               `bson:"language,omitempty" json:"language,omitempty"`
+	Translation      *Translation         `bson:"translation,omitempty" json:"translation,omitempty"`
+	FieldEmbeddings  map[string][]float32 `bson:"field_embeddings,omitempty" json:"field_embeddings,omitempty"`
+	Embeddings       []float32            `bson:"embeddings" json:"embeddings"`
+	Model            string               `bson:"model,omitempty" json:"model,omitempty"`
+	Dimensions       int                  `bson:"dimensions,omitempty" json:"dimensions,omitempty"`
+	EmbeddedAt       time.Time            `bson:"embedded_at" json:"embedded_at"`
+	CreatedAt        time.Time            `bson:"createdAt" json:"createdAt"`
 }
 
 // WorkerResult represents the result of a worker's processing
 type WorkerResult struct {
 	WorkerID            int
 	PropertiesProcessed int
+	Skipped             int // already had embeddings
+	TooShort            int // description under MIN_DESCRIPTION_LENGTH
+	FailedEmbedding     int
+	FailedInsert        int
 	Error               error
 }
 
-// Initialize environment variables from .env file
-func init() {
+// Load configuration from the .env file and environment variables.
+// Returns an error instead of exiting so the code can be reused as a library.
+func LoadConfig() error {
 	// Load .env file from parent directory
 	envPath := filepath.Join("..", ".env")
 	err := godotenv.Load(envPath)
@@ -102,7 +305,7 @@ func init() {
 		// Try the current directory if not found in parent
 		err = godotenv.Load()
 		if err != nil {
-			log.Println("Warning: .env file not found, using environment variables")
+			slog.Warn(".env file not found, using environment variables")
 		}
 	}
 
@@ -111,11 +314,324 @@ func init() {
 	dbName = getEnv("MONGODB_DB_NAME", "properties_db")
 	sourceCollection = getEnv("SOURCE_COLLECTION", "properties")
 	targetCollection = getEnv("TARGET_COLLECTION", "properties_embeddings")
+	failuresCollection = getEnv("FAILURES_COLLECTION", "failures")
+	if failuresCollection == "none" {
+		failuresCollection = ""
+	}
 	apiKey = getEnv("GOOGLE_GENERATIVE_AI_API_KEY", "")
 
-	if apiKey == "" {
-		log.Fatal("GOOGLE_GENERATIVE_AI_API_KEY is not set")
+	if err := loadLoggingConfig(); err != nil {
+		return err
+	}
+
+	embeddingProviderName = getEnv("EMBEDDING_PROVIDER", "gemini")
+	if offline {
+		embeddingProviderName = "fake"
+	}
+	if _, ok := embeddingProviders[embeddingProviderName]; !ok {
+		return fmt.Errorf("invalid EMBEDDING_PROVIDER %q", embeddingProviderName)
+	}
+	embeddingModelName = getEnv("EMBEDDING_MODEL", embeddingProviders[embeddingProviderName].defaultModel)
+	geminiDocumentTaskType, err = parseTaskType(getEnv("GEMINI_TASK_TYPE", "RETRIEVAL_DOCUMENT"))
+	if err != nil {
+		return fmt.Errorf("invalid GEMINI_TASK_TYPE: %w", err)
+	}
+	geminiQueryTaskType, err = parseTaskType(getEnv("GEMINI_QUERY_TASK_TYPE", "RETRIEVAL_QUERY"))
+	if err != nil {
+		return fmt.Errorf("invalid GEMINI_QUERY_TASK_TYPE: %w", err)
+	}
+	openAIAPIKey = getEnv("OPENAI_API_KEY", "")
+	if embeddingProviderName == "openai" && openAIAPIKey == "" {
+		return errors.New("OPENAI_API_KEY is not set")
+	}
+
+	sourcePipeline, err = loadSourcePipeline(getEnv("SOURCE_PIPELINE", ""), getEnv("SOURCE_PIPELINE_FILE", ""))
+	if err != nil {
+		return fmt.Errorf("invalid source pipeline: %w", err)
+	}
+
+	tenantID = getEnv("TENANT_ID", "")
+	tenantField = getEnv("TENANT_FIELD", "")
+	if tenantField != "" {
+		if err := validateFieldPath(tenantField); err != nil {
+			return fmt.Errorf("invalid TENANT_FIELD: %w", err)
+		}
+	}
+
+	mergeKey = getEnv("MERGE_KEY", "")
+	if mergeKey != "" {
+		if err := validateFieldPath(mergeKey); err != nil {
+			return fmt.Errorf("invalid MERGE_KEY: %w", err)
+		}
+	}
+	prioritySort, err = parsePrioritySort(getEnv("PRIORITY_SORT", ""))
+	if err != nil {
+		return fmt.Errorf("invalid PRIORITY_SORT: %w", err)
+	}
+	if len(prioritySort) > 0 && mergeKey != "" {
+		return errors.New("PRIORITY_SORT can't be combined with MERGE_KEY")
+	}
+	mergePolicy = getEnv("MERGE_POLICY", mergePreferFirst)
+	if mergePolicy != mergePreferFirst && mergePolicy != mergePreferLast {
+		return fmt.Errorf("invalid MERGE_POLICY %q: must be %q or %q", mergePolicy, mergePreferFirst, mergePreferLast)
+	}
+
+	summaryModel = getEnv("SUMMARY_MODEL", "gemini-1.5-flash")
+	summaryMode = getEnv("SUMMARY_MODE", summaryModeAppend)
+	if summaryMode != summaryModeReplace && summaryMode != summaryModeAppend {
+		return fmt.Errorf("invalid SUMMARY_MODE %q: must be %q or %q", summaryMode, summaryModeReplace, summaryModeAppend)
+	}
+	summaryRPS, err = strconv.ParseFloat(getEnv("SUMMARY_RPS", "1"), 64)
+	if err != nil || summaryRPS <= 0 {
+		return fmt.Errorf("invalid SUMMARY_RPS %q: must be a positive number", os.Getenv("SUMMARY_RPS"))
+	}
+
+	translateTo = getEnv("TRANSLATE_TO", "")
+	translationModel = getEnv("TRANSLATION_MODEL", "gemini-1.5-flash")
+	translateKeepOriginal = getEnv("TRANSLATE_KEEP_ORIGINAL", "false") == "true"
+	translationRPS, err = strconv.ParseFloat(getEnv("TRANSLATION_RPS", "1"), 64)
+	if err != nil || translationRPS <= 0 {
+		return fmt.Errorf("invalid TRANSLATION_RPS %q: must be a positive number", os.Getenv("TRANSLATION_RPS"))
+	}
+
+	// Summaries and translations always use Gemini, whatever the embedding provider
+	if (embeddingProviders[embeddingProviderName].needsClient || summarize || translateTo != "") && apiKey == "" {
+		return errors.New("GOOGLE_GENERATIVE_AI_API_KEY is not set")
+	}
+
+	batchSize, err = strconv.Atoi(getEnv("BATCH_SIZE", "50"))
+	if err != nil || batchSize < 1 {
+		return fmt.Errorf("invalid BATCH_SIZE %q: must be at least 1", os.Getenv("BATCH_SIZE"))
+	}
+	insertQueueSize, err = strconv.Atoi(getEnv("INSERT_QUEUE_SIZE", "2"))
+	if err != nil || insertQueueSize < 0 {
+		return fmt.Errorf("invalid INSERT_QUEUE_SIZE %q: must not be negative", os.Getenv("INSERT_QUEUE_SIZE"))
+	}
+
+	workerCount, err = strconv.Atoi(getEnv("WORKER_COUNT", strconv.Itoa(runtime.NumCPU())))
+	if err != nil || workerCount < 1 {
+		return fmt.Errorf("invalid WORKER_COUNT %q: must be at least 1", os.Getenv("WORKER_COUNT"))
+	}
+
+	maxOpenCursors, err = strconv.Atoi(getEnv("MAX_OPEN_CURSORS", "0"))
+	if err != nil || maxOpenCursors < 0 {
+		return fmt.Errorf("invalid MAX_OPEN_CURSORS %q: must be a non-negative integer", os.Getenv("MAX_OPEN_CURSORS"))
+	}
+
+	mongoMaxPoolSize, err = strconv.ParseUint(getEnv("MONGO_MAX_POOL_SIZE", "100"), 10, 64)
+	if err != nil || mongoMaxPoolSize < 1 {
+		return fmt.Errorf("invalid MONGO_MAX_POOL_SIZE %q: must be a positive integer", os.Getenv("MONGO_MAX_POOL_SIZE"))
+	}
+	mongoMinPoolSize, err = strconv.ParseUint(getEnv("MONGO_MIN_POOL_SIZE", "0"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid MONGO_MIN_POOL_SIZE %q: must be a non-negative integer", os.Getenv("MONGO_MIN_POOL_SIZE"))
+	}
+	if mongoMinPoolSize > mongoMaxPoolSize {
+		return fmt.Errorf("MONGO_MIN_POOL_SIZE (%d) can't exceed MONGO_MAX_POOL_SIZE (%d)", mongoMinPoolSize, mongoMaxPoolSize)
+	}
+	mongoServerSelectionTimeout, err = time.ParseDuration(getEnv("MONGO_SERVER_SELECTION_TIMEOUT", "30s"))
+	if err != nil || mongoServerSelectionTimeout <= 0 {
+		return fmt.Errorf("invalid MONGO_SERVER_SELECTION_TIMEOUT %q: must be a positive duration like 10s", os.Getenv("MONGO_SERVER_SELECTION_TIMEOUT"))
+	}
+
+	embedMaxConcurrency, err = strconv.Atoi(getEnv("EMBED_MAX_CONCURRENCY", "0"))
+	if err != nil || embedMaxConcurrency < 0 {
+		return fmt.Errorf("invalid EMBED_MAX_CONCURRENCY %q: must be a non-negative integer", os.Getenv("EMBED_MAX_CONCURRENCY"))
+	}
+	embedRPM, err = strconv.ParseFloat(getEnv("EMBED_RPM", "0"), 64)
+	if err != nil || embedRPM < 0 {
+		return fmt.Errorf("invalid EMBED_RPM %q: must be a non-negative number", os.Getenv("EMBED_RPM"))
+	}
+	embedRPS, err = strconv.ParseFloat(getEnv("EMBED_RPS", "0"), 64)
+	if err != nil || embedRPS < 0 {
+		return fmt.Errorf("invalid EMBED_RPS %q: must be a non-negative number", os.Getenv("EMBED_RPS"))
+	}
+
+	embedMaxTokens, err = strconv.Atoi(getEnv("EMBED_MAX_TOKENS", "2048"))
+	if err != nil || embedMaxTokens < 0 {
+		return fmt.Errorf("invalid EMBED_MAX_TOKENS %q: must be a non-negative integer", os.Getenv("EMBED_MAX_TOKENS"))
+	}
+	longTextStrategy = getEnv("LONG_TEXT_STRATEGY", longTextTruncate)
+	if longTextStrategy != longTextTruncate && longTextStrategy != longTextChunkAverage && longTextStrategy != longTextAPI {
+		return fmt.Errorf("invalid LONG_TEXT_STRATEGY %q: must be %q, %q or %q", longTextStrategy, longTextTruncate, longTextChunkAverage, longTextAPI)
+	}
+	// OpenAI rejects over-long inputs instead of truncating them
+	if longTextStrategy == longTextAPI && embeddingProviderName == "openai" {
+		return fmt.Errorf("LONG_TEXT_STRATEGY=%s needs an API that truncates long inputs, which OpenAI doesn't", longTextAPI)
+	}
+
+	embedCacheSize, err = strconv.Atoi(getEnv("EMBED_CACHE_SIZE", "10000"))
+	if err != nil || embedCacheSize < 0 {
+		return fmt.Errorf("invalid EMBED_CACHE_SIZE %q: must be a non-negative integer", os.Getenv("EMBED_CACHE_SIZE"))
+	}
+
+	cacheFile = getEnv("CACHE_FILE", "")
+
+	embedBatchSize, err = strconv.Atoi(getEnv("EMBED_BATCH_SIZE", "100"))
+	if err != nil || embedBatchSize < 1 {
+		return fmt.Errorf("invalid EMBED_BATCH_SIZE %q: must be a positive integer", os.Getenv("EMBED_BATCH_SIZE"))
+	}
+	embedConcurrency, err = strconv.Atoi(getEnv("EMBED_CONCURRENCY", "1"))
+	if err != nil || embedConcurrency < 1 {
+		return fmt.Errorf("invalid EMBED_CONCURRENCY %q: must be a positive integer", os.Getenv("EMBED_CONCURRENCY"))
+	}
+
+	existenceCheckWindow, err = strconv.Atoi(getEnv("EXISTENCE_CHECK_WINDOW", "100"))
+	if err != nil || existenceCheckWindow < 1 {
+		return fmt.Errorf("invalid EXISTENCE_CHECK_WINDOW %q: must be a positive integer", os.Getenv("EXISTENCE_CHECK_WINDOW"))
+	}
+	existenceCheckMode = getEnv("EXISTENCE_CHECK", existenceCheckByWindow)
+	if existenceCheckMode != existenceCheckByWindow && existenceCheckMode != existenceCheckPreload {
+		return fmt.Errorf("invalid EXISTENCE_CHECK %q: must be %q or %q", existenceCheckMode, existenceCheckByWindow, existenceCheckPreload)
+	}
+	existencePreloadChunk, err = strconv.Atoi(getEnv("EXISTENCE_PRELOAD_CHUNK", "10000"))
+	if err != nil || existencePreloadChunk < 1 {
+		return fmt.Errorf("invalid EXISTENCE_PRELOAD_CHUNK %q: must be a positive integer", os.Getenv("EXISTENCE_PRELOAD_CHUNK"))
+	}
+
+	priceRangeMode = getEnv("PRICE_RANGE_MODE", priceRangeOff)
+	if !validPriceRangeMode(priceRangeMode) {
+		return fmt.Errorf("invalid PRICE_RANGE_MODE %q: must be %q, %q or %q",
+			priceRangeMode, priceRangeOff, priceRangeAdd, priceRangeReplace)
+	}
+	priceRangeSaleStep, err = strconv.ParseFloat(getEnv("PRICE_RANGE_SALE_STEP", "100000"), 64)
+	if err != nil || priceRangeSaleStep <= 0 {
+		return fmt.Errorf("invalid PRICE_RANGE_SALE_STEP %q: must be a positive number", os.Getenv("PRICE_RANGE_SALE_STEP"))
+	}
+	priceRangeRentStep, err = strconv.ParseFloat(getEnv("PRICE_RANGE_RENT_STEP", "500"), 64)
+	if err != nil || priceRangeRentStep <= 0 {
+		return fmt.Errorf("invalid PRICE_RANGE_RENT_STEP %q: must be a positive number", os.Getenv("PRICE_RANGE_RENT_STEP"))
+	}
+
+	descriptionLocale = getEnv("DESCRIPTION_LOCALE", "en")
+	localized, ok := localeLabels[descriptionLocale]
+	if !ok {
+		return fmt.Errorf("invalid DESCRIPTION_LOCALE %q: must be en, pt-BR or es", descriptionLocale)
+	}
+	labels = localized
+
+	descriptionTemplateFile = getEnv("DESCRIPTION_TEMPLATE", "")
+	if descriptionTemplateFile != "" {
+		descriptionTemplate, err = loadDescriptionTemplate(descriptionTemplateFile)
+		if err != nil {
+			return fmt.Errorf("invalid DESCRIPTION_TEMPLATE: %w", err)
+		}
+	}
+
+	fieldCaps, err = parseFieldCaps(getEnv("DESCRIPTION_FIELD_CAPS", ""))
+	if err != nil {
+		return fmt.Errorf("invalid DESCRIPTION_FIELD_CAPS: %w", err)
+	}
+
+	minDescriptionLength, err = strconv.Atoi(getEnv("MIN_DESCRIPTION_LENGTH", "0"))
+	if err != nil || minDescriptionLength < 0 {
+		return fmt.Errorf("invalid MIN_DESCRIPTION_LENGTH %q: must be a non-negative integer", os.Getenv("MIN_DESCRIPTION_LENGTH"))
+	}
+	skippedFile = getEnv("SKIPPED_FILE", "")
+
+	areaConflictPolicy = getEnv("AREA_CONFLICT_POLICY", areaConflictKeep)
+	if !validAreaConflictPolicy(areaConflictPolicy) {
+		return fmt.Errorf("invalid AREA_CONFLICT_POLICY %q: must be keep, skip, swap or drop-smaller", areaConflictPolicy)
+	}
+
+	metadataFields = splitList(getEnv("METADATA_FIELDS", ""))
+	if err := validateMetadataFields(metadataFields); err != nil {
+		return fmt.Errorf("invalid METADATA_FIELDS: %w", err)
+	}
+
+	metadataField = getEnv("METADATA_FIELD", defaultMetadataField)
+	vectorField = getEnv("VECTOR_FIELD", defaultVectorField)
+	if err := validateStoredFieldNames(); err != nil {
+		return err
+	}
+
+	checkpointFile = getEnv("CHECKPOINT_FILE", "")
+
+	progressSeconds, err := strconv.Atoi(getEnv("PROGRESS_INTERVAL", "30"))
+	if err != nil || progressSeconds < 0 {
+		return fmt.Errorf("invalid PROGRESS_INTERVAL %q: must be a non-negative number of seconds", os.Getenv("PROGRESS_INTERVAL"))
+	}
+	progressInterval = time.Duration(progressSeconds) * time.Second
+
+	vectorIndexName = getEnv("VECTOR_INDEX", "vector_index")
+	searchDimensionCheck = getEnv("SEARCH_DIMENSION_CHECK", "true") != "false"
+
+	if since := getEnv("INCREMENTAL_SINCE", ""); since != "" {
+		incrementalSince, err = time.Parse(time.RFC3339, since)
+		if err != nil {
+			return fmt.Errorf("invalid INCREMENTAL_SINCE %q: must be an RFC 3339 timestamp like 2024-05-01T00:00:00Z", since)
+		}
+	}
+	highWaterMarkFile = getEnv("HIGH_WATER_MARK_FILE", "")
+
+	embedSourceField = strings.TrimSpace(getEnv("EMBED_SOURCE_FIELD", ""))
+
+	fieldEmbeddingNames, err = parseFieldEmbeddings(getEnv("FIELD_EMBEDDINGS", ""))
+	if err != nil {
+		return fmt.Errorf("invalid FIELD_EMBEDDINGS: %w", err)
+	}
+
+	metricsBackend = getEnv("METRICS_BACKEND", "none")
+	statsdAddr = getEnv("STATSD_ADDR", "localhost:8125")
+	metricsPort, err = strconv.Atoi(getEnv("METRICS_PORT", "0"))
+	if err != nil || metricsPort < 0 || metricsPort > 65535 {
+		return fmt.Errorf("invalid METRICS_PORT %q: must be a port number", os.Getenv("METRICS_PORT"))
 	}
+	if metricsPort > 0 && (metricsBackend == "" || metricsBackend == "none") {
+		metricsBackend = "prometheus"
+	}
+	if metricsBackend == "prometheus" && metricsPort == 0 {
+		return errors.New("METRICS_BACKEND=prometheus requires METRICS_PORT")
+	}
+	metricsPrefix = getEnv("METRICS_PREFIX", "property_embeddings.")
+
+	embedDimensions, err = strconv.Atoi(getEnv("EMBED_DIMENSIONS", "0"))
+	if err != nil || embedDimensions < 0 {
+		return fmt.Errorf("invalid EMBED_DIMENSIONS %q: must be a non-negative integer", os.Getenv("EMBED_DIMENSIONS"))
+	}
+	dimensionMismatchPolicy = getEnv("EMBED_DIMENSION_MISMATCH", dimensionMismatchFail)
+	if dimensionMismatchPolicy != dimensionMismatchFail && dimensionMismatchPolicy != dimensionMismatchSkip {
+		return fmt.Errorf("invalid EMBED_DIMENSION_MISMATCH %q: must be %q or %q",
+			dimensionMismatchPolicy, dimensionMismatchFail, dimensionMismatchSkip)
+	}
+
+	retryMaxBackoff, err = time.ParseDuration(getEnv("RETRY_MAX_BACKOFF", "30s"))
+	if err != nil || retryMaxBackoff <= 0 {
+		return fmt.Errorf("invalid RETRY_MAX_BACKOFF %q: must be a positive duration like 30s", os.Getenv("RETRY_MAX_BACKOFF"))
+	}
+	embedCostPer1K, err = strconv.ParseFloat(getEnv("EMBED_COST_PER_1K", "0"), 64)
+	if err != nil || embedCostPer1K < 0 {
+		return fmt.Errorf("invalid EMBED_COST_PER_1K %q: must be a non-negative number", os.Getenv("EMBED_COST_PER_1K"))
+	}
+	embedMaxRetries, err = strconv.Atoi(getEnv("EMBED_MAX_RETRIES", "5"))
+	if err != nil || embedMaxRetries < 1 {
+		return fmt.Errorf("invalid EMBED_MAX_RETRIES %q: must be a positive integer", os.Getenv("EMBED_MAX_RETRIES"))
+	}
+	initialBackoffMillis, err := strconv.Atoi(getEnv("EMBED_INITIAL_BACKOFF_MS", "1000"))
+	if err != nil || initialBackoffMillis < 1 {
+		return fmt.Errorf("invalid EMBED_INITIAL_BACKOFF_MS %q: must be a positive integer", os.Getenv("EMBED_INITIAL_BACKOFF_MS"))
+	}
+	embedInitialBackoff = time.Duration(initialBackoffMillis) * time.Millisecond
+
+	embedTimeout, err = time.ParseDuration(getEnv("EMBED_TIMEOUT", "30s"))
+	if err != nil || embedTimeout <= 0 {
+		return fmt.Errorf("invalid EMBED_TIMEOUT %q: must be a positive duration like 30s", os.Getenv("EMBED_TIMEOUT"))
+	}
+
+	ensembleModel = getEnv("ENSEMBLE_MODEL", "")
+	ensembleStrategy = getEnv("ENSEMBLE_STRATEGY", ensembleConcat)
+	if ensembleStrategy != ensembleConcat && ensembleStrategy != ensembleAverage {
+		return fmt.Errorf("invalid ENSEMBLE_STRATEGY %q: must be %q or %q", ensembleStrategy, ensembleConcat, ensembleAverage)
+	}
+	ensembleWeight, err = strconv.ParseFloat(getEnv("ENSEMBLE_WEIGHT", "0.5"), 64)
+	if err != nil || ensembleWeight < 0 || ensembleWeight > 1 {
+		return fmt.Errorf("invalid ENSEMBLE_WEIGHT %q: must be a number between 0 and 1", os.Getenv("ENSEMBLE_WEIGHT"))
+	}
+	if err := checkGeminiDimensions(); err != nil {
+		return err
+	}
+
+	return nil
 }
 
 // Helper function to get environment variable with a default value
@@ -127,8 +643,46 @@ func getEnv(key, defaultValue string) string {
 	return value
 }
 
-// Create rich text description from property data
+// Pick the embedding input: the precomputed source field when it has text,
+// otherwise the generated description
+func embeddingText(property *Property) string {
+	if text := strings.TrimSpace(property.EmbedText); text != "" {
+		return text
+	}
+	return createPropertyDescription(property)
+}
+
+// Create rich text description from property data, with the DESCRIPTION_TEMPLATE
+// template when configured and the built-in layout otherwise
 func createPropertyDescription(property *Property) string {
+	if descriptionTemplate != nil {
+		description, err := renderDescriptionTemplate(descriptionTemplate, property)
+		if err == nil {
+			return description
+		}
+		slog.Warn("Error rendering DESCRIPTION_TEMPLATE, using the built-in layout", "property_id", property.ID.Hex(), "error", err)
+	}
+	return builtinPropertyDescription(property)
+}
+
+// Check whether a property is listed for rent rather than sale
+func isRentListing(property *Property) bool {
+	return property.Ad != nil && strings.Contains(strings.ToLower(property.Ad.TransactionType), "rent")
+}
+
+// Join the region, city and state of a property, leaving out the ones not set
+func propertyLocation(property *Property) string {
+	var parts []string
+	for _, part := range []string{property.Region, property.City, property.State} {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Describe a property with the built-in layout
+func builtinPropertyDescription(property *Property) string {
 	var features string
 	if property.Features != nil {
 		features = strings.Join(property.Features, ", ")
@@ -137,300 +691,1276 @@ func createPropertyDescription(property *Property) string {
 	var lines []string
 
 	// Add non-empty fields to description
-	if property.Ad != nil && property.Ad.Title != "" {
-		lines = append(lines, fmt.Sprintf("Title: %s", property.Ad.Title))
+	if includeField("title") && property.Ad != nil && property.Ad.Title != "" {
+		lines = append(lines, fmt.Sprintf("%s: %s", labels.Title, capField("title", property.Ad.Title)))
 	}
-	if property.Ad != nil && property.Ad.Description != "" {
-		lines = append(lines, fmt.Sprintf("Description: %s", property.Ad.Description))
+	if includeField("description") && property.Ad != nil && property.Ad.Description != "" {
+		lines = append(lines, fmt.Sprintf("%s: %s", labels.Description, capField("description", property.Ad.Description)))
 	}
 
-	location := strings.TrimSpace(fmt.Sprintf("%s, %s, %s", 
-		property.Region, property.City, property.State))
-	if location != ",," {
-		lines = append(lines, fmt.Sprintf("Location: %s", location))
+	location := propertyLocation(property)
+	if includeField("location") && location != "" {
+		lines = append(lines, fmt.Sprintf("%s: %s", labels.Location, capField("location", location)))
 	}
 
-	if property.PropertyType != "" {
-		lines = append(lines, fmt.Sprintf("Property Type: %s", property.PropertyType))
+	if includeField("propertyType") && property.PropertyType != "" {
+		lines = append(lines, fmt.Sprintf("%s: %s", labels.PropertyType, property.PropertyType))
 	}
-	if property.Ad != nil && property.Ad.TransactionType != "" {
-		lines = append(lines, fmt.Sprintf("Transaction Type: %s", property.Ad.TransactionType))
+	if includeField("transactionType") && property.Ad != nil && property.Ad.TransactionType != "" {
+		lines = append(lines, fmt.Sprintf("%s: %s", labels.TransactionType, property.Ad.TransactionType))
 	}
-	if property.Area > 0 {
-		lines = append(lines, fmt.Sprintf("Area: %.2f m²", property.Area))
+	if includeField("area") && property.Area > 0 {
+		lines = append(lines, fmt.Sprintf("%s: %.2f m²", labels.Area, property.Area))
 	}
-	if property.TotalArea > 0 {
-		lines = append(lines, fmt.Sprintf("Total Area: %.2f m²", property.TotalArea))
+	if includeField("totalArea") && property.TotalArea > 0 {
+		lines = append(lines, fmt.Sprintf("%s: %.2f m²", labels.TotalArea, property.TotalArea))
 	}
 
 	// Add price information based on transaction type
-	var isRent bool
-	if property.Ad != nil && property.Ad.TransactionType != "" {
-		isRent = strings.Contains(strings.ToLower(property.Ad.TransactionType), "rent")
+	if includeField("price") && isRentListing(property) && property.RentPrice > 0 {
+		if priceRangeMode != priceRangeReplace {
+			lines = append(lines, fmt.Sprintf("%s: %s $%.2f", labels.Price, labels.Rent, property.RentPrice))
+		}
+		if priceRangeMode != priceRangeOff {
+			lines = append(lines, priceRangeLine(labels.Rent, property.RentPrice, priceRangeRentStep))
+		}
+	} else if includeField("price") && property.AskingPrice > 0 {
+		if priceRangeMode != priceRangeReplace {
+			lines = append(lines, fmt.Sprintf("%s: %s $%.2f", labels.Price, labels.Sale, property.AskingPrice))
+		}
+		if priceRangeMode != priceRangeOff {
+			lines = append(lines, priceRangeLine(labels.Sale, property.AskingPrice, priceRangeSaleStep))
+		}
+	}
+
+	if includeField("condoFee") && property.CondoFee != nil {
+		lines = append(lines, fmt.Sprintf("%s: $%.2f", labels.CondoFee, *property.CondoFee))
+	}
+	if includeField("tax") && property.Tax != nil {
+		lines = append(lines, fmt.Sprintf("%s: $%.2f", labels.Tax, *property.Tax))
 	}
-	if isRent && property.RentPrice > 0 {
-		lines = append(lines, fmt.Sprintf("Price: Rent $%.2f", property.RentPrice))
-	} else if property.AskingPrice > 0 {
-		lines = append(lines, fmt.Sprintf("Price: Sale $%.2f", property.AskingPrice))
+	if includeField("commercialId") && property.CommercialID != "" {
+		lines = append(lines, fmt.Sprintf("%s: %s", labels.CommercialID, property.CommercialID))
 	}
 
-	if property.Bedrooms > 0 {
-		lines = append(lines, fmt.Sprintf("Bedrooms: %d", property.Bedrooms))
+	if includeField("bedrooms") && property.Bedrooms > 0 {
+		lines = append(lines, fmt.Sprintf("%s: %d", labels.Bedrooms, property.Bedrooms))
 	}
-	if property.Suites > 0 {
-		lines = append(lines, fmt.Sprintf("Suites: %d", property.Suites))
+	if includeField("suites") && property.Suites > 0 {
+		lines = append(lines, fmt.Sprintf("%s: %d", labels.Suites, property.Suites))
 	}
-	if property.Bathrooms > 0 {
-		lines = append(lines, fmt.Sprintf("Bathrooms: %d", property.Bathrooms))
+	if includeField("bathrooms") && property.Bathrooms > 0 {
+		lines = append(lines, fmt.Sprintf("%s: %d", labels.Bathrooms, property.Bathrooms))
 	}
-	if property.ParkingSpots > 0 {
-		lines = append(lines, fmt.Sprintf("Parking Spots: %d", property.ParkingSpots))
+	if includeField("parkingSpots") && property.ParkingSpots > 0 {
+		lines = append(lines, fmt.Sprintf("%s: %d", labels.ParkingSpots, property.ParkingSpots))
 	}
-	if property.Building != "" {
-		lines = append(lines, fmt.Sprintf("Building: %s", property.Building))
+	if includeField("building") && property.Building != "" {
+		lines = append(lines, fmt.Sprintf("%s: %s", labels.Building, capField("building", property.Building)))
+	}
+
+	if includeField("exclusive") {
+		lines = append(lines, fmt.Sprintf("%s: %s", labels.Exclusive, boolToYesNo(property.IsExclusive)))
 	}
 
-	lines = append(lines, fmt.Sprintf("Exclusive: %s", boolToYesNo(property.IsExclusive)))
+	if includeField("features") && features != "" {
+		lines = append(lines, fmt.Sprintf("%s: %s", labels.Features, capField("features", features)))
+	}
 
-	if features != "" {
-		lines = append(lines, fmt.Sprintf("Features: %s", features))
+	if includeField("imageCount") && len(property.Images) > 0 {
+		lines = append(lines, fmt.Sprintf("%s: %d", labels.ImageCount, len(property.Images)))
+	}
+	if captions := imageCaptions(property.Images); includeField("captions") && len(captions) > 0 {
+		lines = append(lines, fmt.Sprintf("%s: %s", labels.ImageCaptions, capField("captions", strings.Join(captions, "; "))))
 	}
 
 	return strings.Join(lines, "\n")
 }
 
+// Description lines -fields can select, in the order they're written
+var descriptionFields = []string{
+	"title", "description", "location", "propertyType", "transactionType", "area", "totalArea",
+	"price", "condoFee", "tax", "commercialId", "bedrooms", "suites", "bathrooms", "parkingSpots",
+	"building", "exclusive", "features", "imageCount", "captions",
+}
+
+// Parse a -fields list like "title,description,location,features" (all fields when empty)
+func parseDescriptionFields(value string) (map[string]bool, error) {
+	names := splitList(value)
+	if len(names) == 0 {
+		return nil, nil
+	}
+	selected := make(map[string]bool)
+	for _, name := range names {
+		if !slices.Contains(descriptionFields, name) {
+			return nil, fmt.Errorf("unknown field %q, must be one of %s", name, strings.Join(descriptionFields, ", "))
+		}
+		selected[name] = true
+	}
+	return selected, nil
+}
+
+// Check whether a description line was selected with -fields
+func includeField(name string) bool {
+	return selectedFields == nil || selectedFields[name]
+}
+
+// Description fields that accept a length cap
+var cappableFields = map[string]bool{
+	"title":       true,
+	"description": true,
+	"location":    true,
+	"building":    true,
+	"features":    true,
+	"captions":    true,
+}
+
+// Parse per-field caps like "description=1000,title=200"
+func parseFieldCaps(value string) (map[string]int, error) {
+	caps := make(map[string]int)
+	for _, entry := range splitList(value) {
+		name, limit, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !cappableFields[name] {
+			return nil, fmt.Errorf("invalid field cap %q", entry)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(limit))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid length in field cap %q", entry)
+		}
+		caps[name] = n
+	}
+	return caps, nil
+}
+
+// Cut a field value to its configured maximum number of characters
+func capField(name, value string) string {
+	limit, ok := fieldCaps[name]
+	if !ok {
+		return value
+	}
+	runes := []rune(value)
+	if len(runes) <= limit {
+		return value
+	}
+	return strings.TrimSpace(string(runes[:limit]))
+}
+
 // Convert boolean to "Yes" or "No"
 func boolToYesNo(value bool) string {
 	if value {
-		return "Yes"
+		return labels.Yes
 	}
-	return "No"
+	return labels.No
 }
 
-// Generate embedding for a text with retry
-func generateEmbedding(ctx context.Context, text string, client *genai.Client) ([]float32, error) {
-	return generateEmbeddingWithRetry(ctx, text, client, 5)
+// Generate embedding for a text with retry, combining it with the ensemble model when configured
+func generateEmbedding(ctx context.Context, text string) ([]float32, error) {
+	embeddings, err := generateEmbeddings(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return embeddings[0], nil
 }
 
-// Generate embedding with retry and exponential backoff
-func generateEmbeddingWithRetry(
-	ctx context.Context, 
-	text string, 
-	client *genai.Client, 
-	maxRetries int,
-) ([]float32, error) {
-	initialBackoff := 1000 * time.Millisecond
-	
-	// Get the embedding model
-	model := client.EmbeddingModel("text-embedding-004")
-	
-	for retries := 0; retries < maxRetries; retries++ {
-		// Generate embedding
-		resp, err := model.EmbedContent(ctx, genai.Text(text))
+// Generate embeddings for texts in a single request per model, aligned to the input order
+func generateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings, err := generateEmbeddingsWithRetry(ctx, embeddingProvider, texts, embedMaxRetries, embedInitialBackoff)
+	if err != nil {
+		return nil, err
+	}
+
+	if ensembleProvider != nil {
+		secondary, err := generateEmbeddingsWithRetry(ctx, ensembleProvider, texts, embedMaxRetries, embedInitialBackoff)
 		if err != nil {
-			if retries == maxRetries-1 {
-				return nil, fmt.Errorf("failed to generate embedding after %d attempts: %w", maxRetries, err)
+			return nil, fmt.Errorf("ensemble model %s: %w", ensembleModel, err)
+		}
+		for i := range embeddings {
+			embeddings[i], err = combineEmbeddings(embeddings[i], secondary[i], ensembleStrategy, ensembleWeight)
+			if err != nil {
+				return nil, err
 			}
-			
-			// Calculate exponential backoff with jitter
-			backoff := time.Duration(float64(initialBackoff) * 
-				math.Pow(2, float64(retries)) * // 2^retries
-				(0.5 + 0.5*float64(time.Now().Nanosecond())/1e9)) // Add jitter
-			
-			log.Printf("Embedding API error. Retrying in %.2f seconds... (Attempt %d/%d)",
-				float64(backoff)/float64(time.Second), retries+1, maxRetries)
-			
-			// Sleep before retrying
-			time.Sleep(backoff)
-			continue
 		}
-		
-		// Convert to float32 array
-		embedding := make([]float32, len(resp.Embedding.Values))
-		for i, val := range resp.Embedding.Values {
-			embedding[i] = float32(val)
+	}
+
+	if normalizeEmbeddings {
+		for i := range embeddings {
+			embeddings[i] = normalize(embeddings[i])
 		}
-		
-		return embedding, nil
 	}
-	
-	return nil, fmt.Errorf("max retries exceeded")
-}
 
-// Count total properties in the source collection
-func countTotalProperties(ctx context.Context, client *mongo.Client) (int64, error) {
-	collection := client.Database(dbName).Collection(sourceCollection)
-	count, err := collection.CountDocuments(ctx, bson.M{})
-	if err != nil {
-		return 0, fmt.Errorf("error counting properties: %w", err)
+	// Never store vectors whose dimension differs from the configured one
+	for _, embedding := range embeddings {
+		if embedDimensions > 0 && len(embedding) != embedDimensions {
+			return nil, fmt.Errorf("%w: expected %d, got %d", errDimensionMismatch, embedDimensions, len(embedding))
+		}
 	}
-	
-	log.Printf("Total properties to process: %d", count)
-	return count, nil
+	return embeddings, nil
 }
 
-// Process properties for a worker
-func processProperties(
-	ctx context.Context,
-	workerID int,
-	totalWorkers int,
-	client *mongo.Client,
-	aiClient *genai.Client,
-) (int, error) {
-	log.Printf("[Worker %d] Starting to process properties", workerID)
-	
-	propertiesProcessed := 0
+// Returned when an embedding's dimension differs from EMBED_DIMENSIONS
+var errDimensionMismatch = errors.New("embedding dimension mismatch")
+
+// Returned when the embedding API responds without any vector values
+var errEmptyEmbedding = errors.New("embedding response contained no values")
+
+// Context key carrying the ID of the property being embedded
+type propertyIDKey struct{}
+
+// Attach the ID of the property being processed, for logging
+func withPropertyID(ctx context.Context, id primitive.ObjectID) context.Context {
+	return context.WithValue(ctx, propertyIDKey{}, id)
+}
+
+// Warn about a single embedding call that exceeded -trace-slow-embeddings
+func traceSlowEmbedding(ctx context.Context, modelName string, elapsed time.Duration) {
+	if slowEmbeddingThreshold <= 0 || elapsed < slowEmbeddingThreshold {
+		return
+	}
+	metrics.Count(metricSlowEmbeddings, 1, "model:"+modelName)
+	property := "unknown"
+	if id, ok := ctx.Value(propertyIDKey{}).(primitive.ObjectID); ok {
+		property = id.Hex()
+	}
+	slog.Warn("Slow embedding call", "property_id", property, "model", modelName,
+		"duration_ms", elapsed.Milliseconds(), "threshold_ms", slowEmbeddingThreshold.Milliseconds())
+}
+
+// Sleep for the given duration, returning early if the context is done or its
+// deadline would pass before the sleep ends
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		return context.DeadlineExceeded
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Calculate exponential backoff with full jitter for the given attempt: a
+// random duration up to initialBackoff*2^retries, capped at RETRY_MAX_BACKOFF
+func retryBackoff(initialBackoff time.Duration, retries int) time.Duration {
+	ceiling := math.Min(float64(retryMaxBackoff), float64(initialBackoff)*math.Pow(2, float64(retries)))
+	return time.Duration(rand.Float64() * ceiling)
+}
+
+// Generate embeddings with retry and exponential backoff, sending several
+// texts as one batch request. maxRetries counts every attempt, including the first.
+func generateEmbeddingsWithRetry(
+	ctx context.Context, 
+	provider EmbeddingProvider,
+	texts []string, 
+	maxRetries int,
+	initialBackoff time.Duration,
+) ([][]float32, error) {
+	modelName := provider.Model()
 	
-	// Get source and target collections
-	sourceDB := client.Database(dbName).Collection(sourceCollection)
-	targetDB := client.Database(dbName).Collection(targetCollection)
+	for retries := 0; retries < maxRetries; retries++ {
+		// Stop retrying once the overall context is done
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("embedding canceled after %d attempts: %w", retries, err)
+		}
+		
+		// Respect the shared rate and concurrency limits; waiting doesn't count against the timeout
+		release, err := acquireEmbeddingSlot(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("embedding canceled after %d attempts: %w", retries, err)
+		}
+		
+		// Each attempt gets its own timeout so a slow attempt can't eat into the next one's budget
+		attemptCtx, cancel := context.WithTimeout(ctx, embedTimeout)
+		start := time.Now()
+		values, err := embedTexts(attemptCtx, provider, texts)
+		elapsed := time.Since(start)
+		metrics.Timing(metricEmbeddingLatency, elapsed, "model:"+modelName)
+		cancel()
+		release()
+		traceSlowEmbedding(ctx, modelName, elapsed)
+		embeddingRequests.Add(1)
+		if isRateLimited(err) {
+			embeddingRateLimited.Add(1)
+		}
+		
+		// Treat a missing or empty embedding as a failed attempt rather than storing it
+		if err == nil && !allEmbedded(values, len(texts)) {
+			slog.Warn("Embedding API returned an empty embedding", "model", modelName)
+			err = errEmptyEmbedding
+		}
+		if err != nil {
+			metrics.Count(metricErrors, 1, "type:embedding")
+			if !isRetryable(err) {
+				return nil, fmt.Errorf("embedding request failed with a permanent error: %w", err)
+			}
+			if retries == maxRetries-1 {
+				return nil, fmt.Errorf("failed to generate embedding after %d attempts: %w", maxRetries, err)
+			}
+			
+			backoff := retryBackoff(initialBackoff, retries)
+			
+			slog.Warn("Embedding API error, retrying", "model", modelName, "backoff_ms", backoff.Milliseconds(),
+				"attempt", retries+1, "max_attempts", maxRetries, "error", err)
+			
+			// Sleep before retrying
+			if err := sleepContext(ctx, backoff); err != nil {
+				return nil, fmt.Errorf("embedding canceled after %d attempts: %w", retries+1, err)
+			}
+			continue
+		}
+		
+		embeddedTokens.Add(estimateTextsTokens(texts))
+		return values, nil
+	}
 	
-	// Create index on metadata._id for efficient lookups
-	_, err := targetDB.Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "metadata._id", Value: 1}},
-	})
+	return nil, fmt.Errorf("max retries exceeded")
+}
+
+// Count total properties in the source collection
+func countTotalProperties(ctx context.Context, client *mongo.Client) (int64, error) {
+	collection := client.Database(dbName).Collection(sourceCollection)
+	count, err := countSourceProperties(ctx, mongoCollection{collection})
 	if err != nil {
-		return 0, fmt.Errorf("error creating index: %w", err)
+		return 0, fmt.Errorf("error counting properties: %w", err)
 	}
 	
-	// Find all properties in source collection
-	cursor, err := sourceDB.Find(ctx, bson.M{})
+	slog.Info("Counted properties to process", "total_count", count)
+	return count, nil
+}
+
+// Count source properties matching the filter that already have embeddings.
+// Source IDs are checked against the target in chunks so any source filter is honored.
+func countEmbeddedProperties(ctx context.Context, client *mongo.Client) (int64, error) {
+	sourceDB := client.Database(dbName).Collection(sourceCollection)
+	targetDB := client.Database(dbName).Collection(targetCollection)
+
+	// Merging assigns shards by business key, so the key is read as well
+	projection := bson.M{"_id": 1}
+	if mergeKey != "" {
+		projection[mergeKey] = 1
+	}
+	cursor, err := openSourceCursor(ctx, mongoCollection{sourceDB}, projection)
 	if err != nil {
 		return 0, fmt.Errorf("error finding properties: %w", err)
 	}
 	defer cursor.Close(ctx)
+
+	var embedded int64
+	countChunk := func(ids []primitive.ObjectID) error {
+		count, err := targetDB.CountDocuments(ctx, bson.M{storedPath("metadata._id"): bson.M{"$in": ids}})
+		if err != nil {
+			return fmt.Errorf("error counting embedded properties: %w", err)
+		}
+		embedded += count
+		return nil
+	}
+
+	var ids []primitive.ObjectID
+	for cursor.Next(ctx) {
+		var property Property
+		if err := cursor.Decode(&property); err != nil {
+			return 0, fmt.Errorf("error decoding property id: %w", err)
+		}
+		if mergeKey != "" && !keyBelongsToShard(mergePartitionKey(&property)) {
+			continue
+		}
+		ids = append(ids, property.ID)
+		if len(ids) >= 1000 {
+			if err := countChunk(ids); err != nil {
+				return 0, err
+			}
+			ids = nil
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return 0, fmt.Errorf("cursor error: %w", err)
+	}
+	if len(ids) > 0 {
+		if err := countChunk(ids); err != nil {
+			return 0, err
+		}
+	}
+
+	return embedded, nil
+}
+
+// Delete every document in the target collection, keeping its indexes
+func purgeTargetCollection(ctx context.Context, client *mongo.Client) (int64, error) {
+	result, err := client.Database(dbName).Collection(targetCollection).DeleteMany(ctx, bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// Ask for interactive confirmation on stdin
+func confirm(question string) bool {
+	fmt.Printf("%s Type 'yes' to continue: ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(strings.ToLower(answer)) == "yes"
+}
+
+// Write a batch of documents, reporting latency and outcome, and return how
+// many of its new documents were inserted
+func insertBatch(ctx context.Context, targetDB propertyCollection, batch writeBatch) (int, error) {
+	start := time.Now()
+	var upserted int
+	var err error
+	if useTransactions {
+		upserted, err = targetDB.WriteInTransaction(ctx, batch)
+	} else {
+		// Unordered, so one bad document doesn't keep the rest from being inserted
+		_, err = targetDB.InsertMany(ctx, batch.inserts, options.InsertMany().SetOrdered(false))
+	}
+	metrics.Timing(metricInsertLatency, time.Since(start))
+	inserted, replaced := len(batch.inserts), len(batch.replacements)
+	if err != nil {
+		metrics.Count(metricErrors, 1, "type:insert")
+		inserted, replaced, upserted = insertedDespiteError(err, batch.inserts), 0, 0
+	}
+	metrics.Count(metricDocumentsInserted, int64(inserted))
+	documentsAdded.Add(int64(inserted + upserted))
+	documentsUpdated.Add(int64(replaced - upserted))
+	return inserted, err
+}
+
+// Count the documents of a failed unordered insert that were still written,
+// logging each document that failed. Transactions and errors that aren't
+// per-document write errors leave nothing inserted.
+func insertedDespiteError(err error, documents []interface{}) int {
+	var bulkErr mongo.BulkWriteException
+	if useTransactions || !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil || len(bulkErr.WriteErrors) == 0 {
+		return 0
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if writeErr.Index >= 0 && writeErr.Index < len(documents) {
+			slog.Error("Error inserting property", "property_id", storedPropertyID(documents[writeErr.Index]), "error", writeErr.Message)
+		}
+	}
+	return len(documents) - len(bulkErr.WriteErrors)
+}
+
+// Process properties for a worker
+func processProperties(
+	ctx context.Context,
+	workerID int,
+	totalWorkers int,
+	scanRange idRange,
+	store propertyStore,
+	aiClient *genai.Client,
+) (workerStats, error) {
+	worker := newPropertyWorker(workerID, store, aiClient)
+	worker.logger.Info("Starting to process properties")
+	start := time.Now()
+	
+	// Optionally load which properties in this worker's range are already stored
+	if err := worker.preloadExisting(ctx, scanRange); err != nil {
+		return workerStats{}, err
+	}
+	
+	// Read from the shared input file, or find this worker's properties in the source collection
+	var source propertySource = inputSource
+	if inputSource == nil {
+		// Wait for a cursor slot so the source server isn't flooded with cursors
+		if err := acquireCursorSlot(ctx, workerID); err != nil {
+			return workerStats{}, err
+		}
+		defer releaseCursorSlot()
+		
+		cursor, err := openSourceRangeCursor(ctx, store.Source(), scanRange, nil)
+		if err != nil {
+			return workerStats{}, fmt.Errorf("error finding properties: %w", err)
+		}
+		defer cursor.Close(ctx)
+		source = cursorSource{cursor: cursor}
+	}
 	
 	currentIndex := 0
-	var batchDocuments []interface{}
+	
+	// Documents sharing a business key are merged before processing
+	var group *Property
+	var groupKey string
 	
 	// Process each property
-	for cursor.Next(ctx) {
+	for {
+		var property Property
+		more, err := source.Next(ctx, &property)
+		if !more {
+			break
+		}
 		currentIndex++
 		
 		// Log progress periodically
 		if currentIndex%100 == 0 || currentIndex == 1 {
-			log.Printf("[Worker %d] Scanning property %d", workerID, currentIndex)
+			worker.logger.Debug("Scanning property", "scanned_count", currentIndex)
 		}
 		
-		// Skip properties that don't belong to this worker
-		if (currentIndex-1)%totalWorkers != (workerID - 1) {
+		if err != nil {
+			worker.logger.Error("Error decoding property", "error", err)
 			continue
 		}
 		
-		propertiesProcessed++
-		if propertiesProcessed%10 == 0 {
-			log.Printf("[Worker %d] Processed %d properties so far", workerID, propertiesProcessed)
+		if mergeKey == "" {
+			worker.process(ctx, property)
+			continue
 		}
 		
-		// Decode property
-		var property Property
-		if err := cursor.Decode(&property); err != nil {
-			log.Printf("[Worker %d] Error decoding property: %v", workerID, err)
+		// Documents without a business key are never merged
+		key := mergeKeyValue(&property)
+		if !keyBelongsToWorker(mergePartitionKey(&property), workerID, totalWorkers) {
 			continue
 		}
 		
-		// Check if this property already has embeddings
-		var existing bson.M
-		err := targetDB.FindOne(ctx, bson.M{"metadata._id": property.ID}).Decode(&existing)
-		if err == nil {
-			log.Printf("[Worker %d] Property %s already has embeddings, skipping", workerID, property.ID.Hex())
+		// The scan is sorted by the business key, so a group ends when the key changes
+		if group != nil && key != "" && key == groupKey {
+			merged := mergeProperties(group, &property, mergePolicy)
+			worker.logger.Debug("Merged property", "property_id", property.ID.Hex(),
+				"merged_into", group.ID.Hex(), "merge_key", mergeKey, "key", key)
+			group = &merged
 			continue
-		} else if err != mongo.ErrNoDocuments {
-			log.Printf("[Worker %d] Error checking for existing property: %v", workerID, err)
 		}
+		if group != nil {
+			worker.process(ctx, *group)
+		}
+		group, groupKey = &property, key
+	}
+	if group != nil {
+		worker.process(ctx, *group)
+	}
+	
+	worker.flush(ctx)
+	
+	// Check for cursor or read errors
+	if err := source.Err(); err != nil {
+		return worker.stats(), fmt.Errorf("error reading properties: %w", err)
+	}
+	
+	worker.logger.Info("Completed processing properties", "processed_count", worker.processed,
+		"duration_ms", durationMillis(start))
+	return worker.stats(), nil
+}
+
+func main() {
+	flag.IntVar(&shardIndex, "shard", 0, "Index of the external shard handled by this process (0-based)")
+	flag.IntVar(&totalShards, "total-shards", 1, "Total number of external shards across all processes")
+	flag.BoolVar(&skipUnchanged, "skip-unchanged", false,
+		"Re-embed stored properties whose description changed, skipping only those with an identical description_text")
+	flag.BoolVar(&forceReembed, "force-reembed", false,
+		"Re-embed and replace every stored property instead of skipping those that already have embeddings")
+	flag.BoolVar(&countOnly, "count-only", false,
+		"Print the source count, already-embedded count and remaining delta, then exit")
+	flag.IntVar(&servePort, "serve", 0,
+		"Serve POST /embed and POST /search on this port instead of running a batch (disabled when 0)")
+	flag.BoolVar(&profileSource, "stats", false,
+		"Print description lengths, field fill rates and property and transaction type distributions of the source, then exit")
+	flag.BoolVar(&verifyCoverage, "verify", false,
+		"Report how many source properties lack embeddings and which stored vectors don't match the model's dimensions, then exit")
+	flag.IntVar(&verifySample, "verify-sample", 10, "Number of example IDs -verify lists for missing properties and stale vectors")
+	flag.BoolVar(&quietSkips, "quiet-skips", false,
+		"Don't log each already-embedded property; report the skipped count periodically and at the end")
+	failThresholdValue := flag.String("fail-threshold", "",
+		"Exit with code 1 when more properties failed than this, as a count (e.g. 10) or a rate (e.g. 5%) (default: unlimited)")
+	maxFailuresValue := flag.String("max-failures", "",
+		"Abort the run once this many properties failed, as a count (e.g. 500) or a rate (e.g. 5%) (default: unlimited)")
+	flag.BoolVar(&normalizeEmbeddings, "normalize", false,
+		"L2-normalize embeddings to unit length before storing them, so dot product equals cosine similarity")
+	flag.BoolVar(&noCache, "no-cache", false,
+		"Don't reuse embeddings of identical inputs, neither from memory nor from CACHE_FILE")
+	flag.BoolVar(&offline, "offline", false,
+		"Use deterministic fake embeddings instead of calling an embedding API (same as EMBEDDING_PROVIDER=fake)")
+	flag.DurationVar(&slowEmbeddingThreshold, "trace-slow-embeddings", 0,
+		"Log a warning for every embedding call slower than this duration, e.g. 5s (disabled when 0)")
+	printConfigOnly := flag.Bool("print-config", false,
+		"Print the resolved configuration with secrets redacted, then exit")
+	flag.BoolVar(&purgeTarget, "purge-target", false, "Delete all documents in the target collection before processing")
+	flag.BoolVar(&assumeYes, "yes", false, "Don't prompt for confirmation of destructive actions")
+	flag.BoolVar(&summarize, "summarize", false, "Generate an LLM summary of each property, store it and include it in the embedding input")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Generate and log descriptions without calling the embedding API or writing to the target collection")
+	flag.IntVar(&healthPort, "health-port", 0, "Serve /livez and /readyz on this port during the run (disabled when 0)")
+	flag.BoolVar(&transactional, "transactional", false,
+		"Write each batch in a transaction (replica sets and sharded clusters only; falls back to plain inserts)")
+	flag.StringVar(&dryRunSearch, "dry-run-search", "",
+		"Search stored embeddings for this query with in-memory cosine similarity, then exit")
+	flag.StringVar(&vectorSearchQuery, "search", "",
+		"Search stored embeddings for this query with an Atlas $vectorSearch on VECTOR_INDEX, then exit")
+	flag.StringVar(&searchMode, "search-mode", searchModeAtlas,
+		"How -search ranks embeddings: atlas ($vectorSearch) or brute (streamed cosine similarity in Go, any MongoDB)")
+	searchFilterValue := flag.String("search-filter", "",
+		"Extended JSON query pre-filtering documents in -search-mode=brute, e.g. '{\"filter.city\": \"sao paulo\"}'")
+	fieldsValue := flag.String("fields", "",
+		"Comma-separated description lines to embed, e.g. title,description,location,features (default: all)")
+	sourceQueryValue := flag.String("filter", "",
+		"Extended JSON query selecting which source properties to process, e.g. '{\"city\": \"Curitiba\", \"rentPrice\": {\"$gt\": 0}}'")
+	flag.IntVar(&searchK, "k", 10, "Number of search results to return")
+	flag.BoolVar(&createVectorIndex, "create-index", false,
+		"Create the VECTOR_INDEX Atlas Vector Search index on the target collection if it doesn't exist")
+	flag.BoolVar(&searchJSON, "json", false, "Print search results as JSON instead of a table")
+	flag.StringVar(&searchTenant, "tenant", "", "Tenant to search within (default: TENANT_ID)")
+	fieldWeights := flag.String("field-weights", "",
+		"Weight per-field similarities in -dry-run-search, e.g. title=2,description=1,full=1 (requires FIELD_EMBEDDINGS)")
+	flag.Int64Var(&searchMaxDocuments, "search-max-documents", 10000,
+		"Maximum number of stored embeddings loaded by -dry-run-search")
+	flag.BoolVar(&retryFailures, "retry-failures", false,
+		"Re-embed only the properties recorded in FAILURES_COLLECTION, removing the entries of those stored successfully")
+	flag.BoolVar(&reconcile, "reconcile", false,
+		"Sync the target with the source: embed missing properties, re-embed changed ones and delete removed ones")
+	flag.BoolVar(&autoIncremental, "auto-incremental", false,
+		"Only process properties updated since the newest stored embedding (full run when the target is empty)")
+	flag.StringVar(&exportPath, "export", "",
+		"Export the target collection's embeddings to this file (- for stdout) in the -format format, then exit")
+	flag.StringVar(&exportFormat, "format", exportJSONL, "Format of -export: jsonl, csv (id and vector values) or npy")
+	flag.StringVar(&inputFile, "input-file", "",
+		"Read properties from this JSONL file, one Property per line, instead of the source collection")
+	flag.StringVar(&outputFile, "output-file", "",
+		"Write embedded properties to this JSONL file instead of the target collection")
+	batchSizeFlag := flag.Int("batch-size", 0, "Number of documents per insert, overriding BATCH_SIZE (default: 50)")
+	workersFlag := flag.Int("workers", 0, "Number of workers, overriding WORKER_COUNT (default: number of CPUs)")
+	workersAuto := flag.Bool("workers-auto", false,
+		"Scale the number of workers up and down based on embedding API rate limiting")
+	minWorkers := flag.Int("min-workers", 1, "Minimum number of workers in -workers-auto mode")
+	maxWorkers := flag.Int("max-workers", 8, "Maximum number of workers in -workers-auto mode")
+	resumeFromHex := flag.String("resume-from-id", "",
+		"Only scan source properties with an _id greater than this ObjectId (hex)")
+	flag.Parse()
+	
+	if err := LoadConfig(); err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	setupLogging()
+	if *printConfigOnly {
+		printConfig(os.Stdout)
+		return
+	}
+
+	if totalShards < 1 {
+		log.Fatalf("Invalid -total-shards %d: must be at least 1", totalShards)
+	}
+	if shardIndex < 0 || shardIndex >= totalShards {
+		log.Fatalf("Invalid -shard %d: must be between 0 and %d", shardIndex, totalShards-1)
+	}
+	if *resumeFromHex != "" {
+		id, err := primitive.ObjectIDFromHex(*resumeFromHex)
+		if err != nil {
+			log.Fatalf("Invalid -resume-from-id %q: must be a 24-character hex ObjectId", *resumeFromHex)
+		}
+		if len(prioritySort) > 0 {
+			log.Fatalf("-resume-from-id needs an _id ordered scan and can't be combined with PRIORITY_SORT")
+		}
+		resumeFromID = id
+		slog.Info("Resuming scan", "after_id", resumeFromID.Hex())
+	}
+	var err error
+	maxFailures, err = parseFailureThreshold(*maxFailuresValue)
+	if err != nil {
+		log.Fatalf("Invalid -max-failures: %v", err)
+	}
+	failThreshold, err = parseFailureThreshold(*failThresholdValue)
+	if err != nil {
+		log.Fatalf("Invalid -fail-threshold: %v", err)
+	}
+	searchFieldWeights, err = parseFieldWeights(*fieldWeights)
+	if err != nil {
+		log.Fatalf("Invalid -field-weights: %v", err)
+	}
+	if vectorSearchQuery != "" {
+		if dryRunSearch != "" {
+			log.Fatalf("Use either -search or -dry-run-search, not both")
+		}
+		if len(searchFieldWeights) > 0 {
+			log.Fatalf("-field-weights only applies to -dry-run-search")
+		}
+	}
+	if searchMode != searchModeAtlas && searchMode != searchModeBrute {
+		log.Fatalf("Invalid -search-mode %q: must be %q or %q", searchMode, searchModeAtlas, searchModeBrute)
+	}
+	searchFilter, err = parseQueryFilter(*searchFilterValue)
+	if err != nil {
+		log.Fatalf("Invalid -search-filter: %v", err)
+	}
+	sourceQuery, err = parseQueryFilter(*sourceQueryValue)
+	if err != nil {
+		log.Fatalf("Invalid -filter: %v", err)
+	}
+	selectedFields, err = parseDescriptionFields(*fieldsValue)
+	if err != nil {
+		log.Fatalf("Invalid -fields: %v", err)
+	}
+	if selectedFields != nil && descriptionTemplate != nil {
+		log.Fatalf("-fields only applies to the built-in description layout and can't be combined with DESCRIPTION_TEMPLATE")
+	}
+	if len(searchFilter) > 0 && searchMode != searchModeBrute {
+		log.Fatalf("-search-filter requires -search-mode=brute")
+	}
+	if searchK < 1 {
+		log.Fatalf("Invalid -k %d: must be at least 1", searchK)
+	}
+	if *workersAuto {
+		if *minWorkers < 1 || *maxWorkers < *minWorkers {
+			log.Fatalf("Invalid worker bounds: need 1 <= -min-workers (%d) <= -max-workers (%d)", *minWorkers, *maxWorkers)
+		}
+		if mergeKey != "" {
+			log.Fatalf("-workers-auto can't be combined with MERGE_KEY")
+		}
+	}
+	if checkpointFile != "" {
+		switch {
+		case *workersAuto:
+			log.Fatalf("CHECKPOINT_FILE can't be combined with -workers-auto")
+		case mergeKey != "":
+			log.Fatalf("CHECKPOINT_FILE can't be combined with MERGE_KEY")
+		case len(prioritySort) > 0:
+			log.Fatalf("CHECKPOINT_FILE needs an _id ordered scan and can't be combined with PRIORITY_SORT")
+		case !resumeFromID.IsZero():
+			log.Fatalf("CHECKPOINT_FILE can't be combined with -resume-from-id")
+		}
+	}
+	if dryRun && (purgeTarget || createVectorIndex) {
+		log.Fatalf("-dry-run doesn't write to the target and can't be combined with -purge-target or -create-index")
+	}
+	if autoIncremental && (!incrementalSince.IsZero() || highWaterMarkFile != "") {
+		log.Fatalf("-auto-incremental can't be combined with INCREMENTAL_SINCE or HIGH_WATER_MARK_FILE")
+	}
+	if exportPath != "" {
+		if exportFormat != exportJSONL && exportFormat != exportCSV && exportFormat != exportNPY {
+			log.Fatalf("Invalid -format %q: must be %q, %q or %q", exportFormat, exportJSONL, exportCSV, exportNPY)
+		}
+		if inputFile != "" || outputFile != "" || dryRunSearch != "" || vectorSearchQuery != "" {
+			log.Fatalf("-export can't be combined with -input-file, -output-file or searches")
+		}
+	}
+	if servePort > 0 && (inputFile != "" || outputFile != "" || exportPath != "" || profileSource || verifyCoverage ||
+		dryRun || dryRunSearch != "" || vectorSearchQuery != "") {
+		log.Fatalf("-serve can't be combined with -input-file, -output-file, -export, -stats, -verify, -dry-run or searches")
+	}
+	if profileSource && (inputFile != "" || outputFile != "" || exportPath != "" || verifyCoverage || dryRunSearch != "" || vectorSearchQuery != "") {
+		log.Fatalf("-stats can't be combined with -input-file, -output-file, -export, -verify or searches")
+	}
+	if verifyCoverage {
+		if inputFile != "" || outputFile != "" || exportPath != "" || dryRunSearch != "" || vectorSearchQuery != "" {
+			log.Fatalf("-verify can't be combined with -input-file, -output-file, -export or searches")
+		}
+		if verifySample < 0 {
+			log.Fatalf("Invalid -verify-sample %d: must not be negative", verifySample)
+		}
+	}
+	if inputFile != "" {
+		switch {
+		case *workersAuto:
+			log.Fatalf("-input-file can't be combined with -workers-auto")
+		case mergeKey != "" || len(sourcePipeline) > 0 || len(prioritySort) > 0:
+			log.Fatalf("-input-file can't be combined with MERGE_KEY, SOURCE_PIPELINE or PRIORITY_SORT")
+		case checkpointFile != "" || !resumeFromID.IsZero() || totalShards > 1:
+			log.Fatalf("-input-file can't be combined with CHECKPOINT_FILE, -resume-from-id or -total-shards")
+		case autoIncremental || !incrementalSince.IsZero() || highWaterMarkFile != "" || reconcile:
+			log.Fatalf("-input-file can't be combined with incremental runs or -reconcile")
+		case countOnly:
+			log.Fatalf("-input-file can't be combined with -count-only")
+		case len(sourceQuery) > 0:
+			log.Fatalf("-input-file can't be combined with -filter")
+		}
+	}
+	if outputFile != "" {
+		switch {
+		case purgeTarget || createVectorIndex || transactional:
+			log.Fatalf("-output-file can't be combined with -purge-target, -create-index or -transactional")
+		case autoIncremental || reconcile || skipUnchanged || forceReembed:
+			log.Fatalf("-output-file can't be combined with -auto-incremental, -reconcile, -skip-unchanged or -force-reembed")
+		case dryRunSearch != "" || vectorSearchQuery != "":
+			log.Fatalf("-output-file can't be combined with searches")
+		}
+	}
+	if reconcile {
+		if autoIncremental || !incrementalSince.IsZero() || highWaterMarkFile != "" {
+			log.Fatalf("-reconcile scans the whole source and can't be combined with incremental runs")
+		}
+		// Changed descriptions are re-embedded in place
+		skipUnchanged = true
+	}
+	if retryFailures {
+		switch {
+		case failuresCollection == "":
+			log.Fatalf("-retry-failures needs a FAILURES_COLLECTION other than none")
+		case inputFile != "" || outputFile != "" || len(sourceQuery) > 0:
+			log.Fatalf("-retry-failures can't be combined with -input-file, -output-file or -filter")
+		case autoIncremental || !incrementalSince.IsZero() || highWaterMarkFile != "" || reconcile || purgeTarget:
+			log.Fatalf("-retry-failures can't be combined with incremental runs, -reconcile or -purge-target")
+		}
+		// Properties whose stored document couldn't be replaced are retried too
+		forceReembed = true
+	}
+
+	// Use all available CPUs for workers unless configured otherwise
+	workers := workerCount
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "workers":
+			workers = *workersFlag
+		case "batch-size":
+			batchSize = *batchSizeFlag
+		}
+	})
+	if workers < 1 {
+		log.Fatalf("Invalid worker count %d: must be at least 1", workers)
+	}
+	if batchSize < 1 {
+		log.Fatalf("Invalid -batch-size %d: must be at least 1", batchSize)
+	}
+	if noCache {
+		embedCacheSize = 0
+	}
+	
+	if *workersAuto {
+		slog.Info("Starting property embeddings generator with auto-scaled workers", "min_workers", *minWorkers, "max_workers", *maxWorkers)
+		// The auto-scaled pool reports back as a single worker
+		workers = 1
+	} else {
+		slog.Info("Starting property embeddings generator", "workers", workers)
+	}
+	if totalShards > 1 {
+		slog.Info("Handling external shard", "shard_index", shardIndex, "total_shards", totalShards)
+	}
+	
+	// Create context
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	abortRun = cancel
+	
+	// Set up the metrics backend
+	m, err := newMetrics(metricsBackend, statsdAddr, metricsPort, metricsPrefix)
+	if err != nil {
+		log.Fatalf("Error setting up metrics: %v", err)
+	}
+	metrics = m
+	defer metrics.Close()
+	switch metricsBackend {
+	case "statsd":
+		slog.Info("Sending metrics to StatsD", "address", statsdAddr)
+	case "prometheus":
+		slog.Info("Serving Prometheus metrics on /metrics", "port", metricsPort)
+	}
+	
+	// Connect to MongoDB, unless both the input and output are files
+	var client *mongo.Client
+	if inputFile == "" || outputFile == "" {
+		client, err = mongo.Connect(ctx, options.Client().
+			ApplyURI(mongoURI).
+			SetMaxPoolSize(mongoMaxPoolSize).
+			SetMinPoolSize(mongoMinPoolSize).
+			SetServerSelectionTimeout(mongoServerSelectionTimeout).
+			SetRetryWrites(true))
+		if err != nil {
+			log.Fatalf("Error connecting to MongoDB: %v", err)
+		}
+		slog.Info("MongoDB connection pool, retryable writes on", "min_pool_size", mongoMinPoolSize, "max_pool_size", mongoMaxPoolSize,
+			"server_selection_timeout_ms", mongoServerSelectionTimeout.Milliseconds())
+		defer client.Disconnect(ctx)
 		
-		// Create rich description for embedding
-		description := createPropertyDescription(&property)
-		
-		// Generate embedding
-		embedding, err := generateEmbedding(ctx, description, aiClient)
+		// Ping the database to verify connection
+		if err = client.Ping(ctx, nil); err != nil {
+			log.Fatalf("Error pinging MongoDB: %v", err)
+		}
+		slog.Info("Connected to MongoDB")
+	}
+	
+	// Exporting only reads the target collection
+	if exportPath != "" {
+		tenant := searchTenant
+		if tenant == "" {
+			tenant = tenantID
+		}
+		exported, err := exportEmbeddings(ctx, client, exportPath, exportFormat, tenant)
 		if err != nil {
-			log.Printf("[Worker %d] Error generating embedding: %v", workerID, err)
-			continue
+			log.Fatalf("Error exporting embeddings: %v", err)
+		}
+		slog.Info("Exported embeddings", "exported_count", exported, "collection", dbName+"."+targetCollection, "format", exportFormat)
+		return
+	}
+	
+	// Profiling only reads the source, without embedding anything
+	if profileSource {
+		slog.Info("Source filter", "filter", formatSourceFilter())
+		profile, err := profileSourceProperties(ctx, client)
+		if err != nil {
+			log.Fatalf("Error profiling source: %v", err)
+		}
+		printSourceProfile(os.Stdout, profile)
+		return
+	}
+	
+	// Verifying coverage only reads both collections; the embedding provider
+	// is set up without any request to know the expected dimensions
+	if verifyCoverage {
+		aiClient, err := setupEmbeddingProviders(ctx)
+		if err != nil {
+			log.Fatalf("Error setting up embeddings: %v", err)
+		}
+		if aiClient != nil {
+			defer aiClient.Close()
+		}
+		slog.Info("Source filter", "filter", formatSourceFilter())
+		report, err := verifyEmbeddingCoverage(ctx, client, verifySample)
+		if err != nil {
+			log.Fatalf("Error verifying embeddings: %v", err)
+		}
+		printCoverageReport(os.Stdout, report)
+		return
+	}
+	
+	// Server mode embeds and searches on request until interrupted
+	if servePort > 0 {
+		aiClient, err := setupEmbeddingProviders(ctx)
+		if err != nil {
+			log.Fatalf("Error setting up embeddings: %v", err)
+		}
+		if aiClient != nil {
+			defer aiClient.Close()
+		}
+		if err := checkEmbeddingModel(ctx); err != nil {
+			log.Fatalf("Preflight check failed: %v", err)
+		}
+		if err := runEmbeddingServer(ctx, servePort, client); err != nil {
+			log.Fatalf("Server error: %v", err)
+		}
+		return
+	}
+	
+	// Local search mode only needs the target collection and the embedding model
+	if dryRunSearch != "" || vectorSearchQuery != "" {
+		aiClient, err := setupEmbeddingProviders(ctx)
+		if err != nil {
+			log.Fatalf("Error setting up embeddings: %v", err)
+		}
+		if aiClient != nil {
+			defer aiClient.Close()
 		}
 		
-		if embedding != nil {
-			// Create document with metadata and embeddings
-			documentWithEmbedding := PropertyWithEmbedding{
-				Metadata:   property,
-				Embeddings: embedding,
+		tenant := searchTenant
+		if tenant == "" {
+			tenant = tenantID
+		}
+		var results []SearchResult
+		switch {
+		case vectorSearchQuery != "" && searchMode == searchModeBrute:
+			results, err = bruteForceSearch(ctx, mongoStore{client}.Target(), vectorSearchQuery, tenant, searchK, searchFilter)
+		case vectorSearchQuery != "":
+			results, err = vectorSearch(ctx, mongoStore{client}.Target(), vectorSearchQuery, tenant, searchK)
+		default:
+			results, err = localSearch(ctx, mongoStore{client}.Target(), dryRunSearch, tenant, searchK, searchMaxDocuments, searchFieldWeights)
+		}
+		if err != nil {
+			log.Fatalf("Error searching: %v", err)
+		}
+		if searchJSON {
+			err = printSearchResultsJSON(os.Stdout, results)
+		} else {
+			printSearchResults(results)
+		}
+		if err != nil {
+			log.Fatalf("Error printing results: %v", err)
+		}
+		return
+	}
+	
+	// Transactions need a replica set or sharded cluster
+	if transactional {
+		supported, err := supportsTransactions(ctx, client)
+		if err != nil {
+			log.Fatalf("Error checking transaction support: %v", err)
+		}
+		useTransactions = supported
+		if supported {
+			slog.Info("Writing each batch in a transaction")
+		} else {
+			slog.Warn("MongoDB is a standalone server without transaction support, using plain inserts")
+		}
+	}
+	
+	// Pick up only what changed since the last run
+	if autoIncremental {
+		watermark, found, err := computeWatermark(ctx, mongoStore{client}.Target())
+		if err != nil {
+			log.Fatalf("Error computing incremental watermark: %v", err)
+		}
+		if found {
+			updatedSince = watermark
+			skipUnchanged = true
+			slog.Info("Auto-incremental: processing updated properties", "updated_after", watermark.Format(time.RFC3339))
+		} else {
+			slog.Info("Auto-incremental: target has no embeddings yet, doing a full run")
+		}
+	} else if !incrementalSince.IsZero() || highWaterMarkFile != "" {
+		since := incrementalSince
+		if highWaterMarkFile != "" {
+			mark, found, err := loadHighWaterMark(highWaterMarkFile)
+			if err != nil {
+				log.Fatalf("Error loading high-water mark: %v", err)
 			}
-			
-			batchDocuments = append(batchDocuments, documentWithEmbedding)
-			
-			// Insert in batches
-			if len(batchDocuments) >= batchSize {
-				_, err := targetDB.InsertMany(ctx, batchDocuments)
-				if err != nil {
-					log.Printf("[Worker %d] Error inserting batch: %v", workerID, err)
-				} else {
-					log.Printf("[Worker %d] Inserted batch of %d properties (processed: %d)",
-						workerID, len(batchDocuments), propertiesProcessed)
-				}
-				batchDocuments = nil
+			if found {
+				since = mark
 			}
+		}
+		if since.IsZero() {
+			slog.Info("Incremental: no high-water mark yet, doing a full run")
 		} else {
-			log.Printf("[Worker %d] Failed to generate embedding for property %s", workerID, property.ID.Hex())
+			updatedSince = since
+			skipUnchanged = true
+			slog.Info("Incremental: processing updated properties", "updated_after", since.Format(time.RFC3339))
 		}
 	}
 	
-	// Insert any remaining documents
-	if len(batchDocuments) > 0 {
-		_, err := targetDB.InsertMany(ctx, batchDocuments)
+	// A retry only processes the properties recorded as failed
+	var retryIDs []primitive.ObjectID
+	retryStart := time.Now()
+	if retryFailures {
+		retryIDs, err = loadFailedPropertyIDs(ctx, mongoStore{client: client}.Failures())
 		if err != nil {
-			log.Printf("[Worker %d] Error inserting final batch: %v", workerID, err)
+			log.Fatalf("Error loading failed properties: %v", err)
+		}
+		if len(retryIDs) == 0 {
+			slog.Info("No failed properties to retry, exiting", "collection", dbName+"."+failuresCollection)
+			client.Disconnect(ctx)
+			os.Exit(exitNothingToDo)
+		}
+		slog.Info("Retrying failed properties", "failed_count", len(retryIDs), "collection", dbName+"."+failuresCollection)
+		sourceQuery = bson.M{"_id": bson.M{"$in": retryIDs}}
+	}
+	
+	// Fail fast on a missing or empty source collection
+	if inputFile == "" {
+		if err := checkSourceCollection(ctx, client); err != nil {
+			log.Fatalf("Preflight check failed: %v", err)
+		}
+	}
+	
+	// Count total properties
+	if inputFile == "" {
+		slog.Info("Source filter", "filter", formatSourceFilter())
+	}
+	var totalProperties int64
+	if inputFile != "" {
+		totalProperties, err = countJSONLRecords(inputFile)
+	} else {
+		totalProperties, err = countTotalProperties(ctx, client)
+	}
+	if err != nil {
+		log.Fatalf("Error counting properties: %v", err)
+	}
+	slog.Info("Will process properties", "total_count", totalProperties)
+	
+	if countOnly {
+		embedded, err := countEmbeddedProperties(ctx, client)
+		if err != nil {
+			log.Fatalf("Error counting embedded properties: %v", err)
+		}
+		fmt.Printf("Source:               %s.%s\n", dbName, sourceCollection)
+		fmt.Printf("Source properties:    %d\n", totalProperties)
+		fmt.Printf("Already embedded:     %d\n", embedded)
+		fmt.Printf("Remaining to process: %d\n", totalProperties-embedded)
+		return
+	}
+	
+	// Empty the target collection for a clean reindex
+	if purgeTarget {
+		if !assumeYes && !confirm(fmt.Sprintf("Delete ALL documents in %s.%s?", dbName, targetCollection)) {
+			slog.Info("Purge not confirmed, exiting")
+			return
+		}
+		purged, err := purgeTargetCollection(ctx, client)
+		if err != nil {
+			log.Fatalf("Error purging target collection: %v", err)
+		}
+		slog.Info("Purged target documents", "purged_count", purged, "collection", dbName+"."+targetCollection)
+	}
+	
+	// Nothing matched, so don't spin up workers at all
+	if totalProperties == 0 {
+		if inputFile != "" {
+			slog.Info("No properties to process, exiting", "input_file", inputFile)
 		} else {
-			log.Printf("[Worker %d] Inserted final batch of %d properties (total: %d)",
-				workerID, len(batchDocuments), propertiesProcessed)
+			slog.Info("No properties to process, exiting", "collection", dbName+"."+sourceCollection)
+		}
+		if client != nil {
+			client.Disconnect(ctx)
 		}
+		os.Exit(exitNothingToDo)
 	}
 	
-	// Check for cursor errors
-	if err := cursor.Err(); err != nil {
-		return propertiesProcessed, fmt.Errorf("cursor error: %w", err)
+	// Initialize the embedding providers, and the Gemini client when needed. A dry run
+	// never calls the API, so it doesn't need either.
+	var aiClient *genai.Client
+	if dryRun {
+		slog.Info("Dry run: descriptions are logged, nothing is embedded or written")
+		summarize, translateTo = false, ""
+	} else {
+		aiClient, err = setupEmbeddingProviders(ctx)
+		if err != nil {
+			log.Fatalf("Error setting up embeddings: %v", err)
+		}
+		if aiClient != nil {
+			defer aiClient.Close()
+		}
+		slog.Info("Using embedding provider (0 dimensions = unknown)", "provider", embeddingProviderName,
+			"model", embeddingProvider.Model(), "dimensions", embeddingProvider.Dimensions())
+		// Reuse embeddings stored by earlier runs
+		if cacheFile != "" && embedCacheSize > 0 {
+			loaded, err := embeddingCache.open(cacheFile)
+			if err != nil {
+				log.Fatalf("Error loading embedding cache: %v", err)
+			}
+			defer embeddingCache.close()
+			slog.Info("Loaded cached embeddings", "cached_count", loaded, "path", cacheFile)
+		}
+		if embedMaxConcurrency > 0 || embeddingRate() > 0 {
+			slog.Info("Limiting embedding requests (0 = unlimited)", "max_in_flight", embedMaxConcurrency, "requests_per_second", embeddingRate())
+		}
+		
+		// Confirm the API key and model work with one tiny request
+		if err := checkEmbeddingModel(ctx); err != nil {
+			log.Fatalf("Preflight check failed: %v", err)
+		}
+		
+		// Refuse to mix vectors of different lengths in the target collection
+		if !forceReembed && outputFile == "" {
+			if err := checkStoredDimensions(ctx, client.Database(dbName).Collection(targetCollection)); err != nil {
+				log.Fatalf("Embedding dimension check failed: %v (use -force-reembed to replace all stored vectors)", err)
+			}
+		}
+		
+		// Make sure the vector search index exists for querying the embeddings
+		if createVectorIndex {
+			if err := ensureVectorIndex(ctx, client.Database(dbName).Collection(targetCollection)); err != nil {
+				log.Fatalf("Error creating vector search index: %v", err)
+			}
+		}
 	}
 	
-	log.Printf("[Worker %d] Completed processing %d properties", workerID, propertiesProcessed)
-	return propertiesProcessed, nil
-}
-
-func main() {
-	// Use all available CPUs for workers
-	// Using a constant value for now
-	workers := 4
+	// Summary requests are rate limited across all workers
+	if summarize {
+		summaryLimiter = rate.NewLimiter(rate.Limit(summaryRPS), 1)
+		slog.Info("Summarizing properties", "model", summaryModel, "mode", summaryMode, "requests_per_second", summaryRPS)
+	}
 	
-	log.Printf("Starting property embeddings generator with %d workers", workers)
+	// Translation requests are rate limited across all workers
+	if translateTo != "" {
+		translationLimiter = rate.NewLimiter(rate.Limit(translationRPS), 1)
+		slog.Info("Translating descriptions", "language", translateTo, "model", translationModel,
+			"keep_original", translateKeepOriginal, "requests_per_second", translationRPS)
+	}
 	
-	// Create context
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// Start the optional health server for orchestrator probes
+	var health *healthServer
+	if healthPort > 0 {
+		health = startHealthServer(healthPort, client, activeEmbeddingProviders())
+		defer health.shutdown()
+	}
 	
-	// Connect to MongoDB
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
-	if err != nil {
-		log.Fatalf("Error connecting to MongoDB: %v", err)
+	// Cap the number of concurrently open source cursors
+	if maxOpenCursors > 0 {
+		cursorSlots = make(chan struct{}, maxOpenCursors)
+		slog.Info("Limiting open source cursors", "max_open_cursors", maxOpenCursors)
 	}
-	defer client.Disconnect(ctx)
 	
-	// Ping the database to verify connection
-	if err = client.Ping(ctx, nil); err != nil {
-		log.Fatalf("Error pinging MongoDB: %v", err)
+	// Give each worker its own _id range so it only fetches its share of the
+	// source. Merging partitions by business key instead and scans everything.
+	scanRanges := make([]idRange, workers)
+	var checkpoint *checkpointState
+	if checkpointFile != "" {
+		checkpoint, err = loadCheckpoint(checkpointFile)
+		if err != nil {
+			log.Fatalf("Error loading checkpoint: %v", err)
+		}
+	}
+	// Transactional batches store each worker's checkpoint with the batch, which
+	// is newer than the file when the run stopped between two file writes
+	if checkpointFile != "" && useTransactions && !dryRun {
+		stored := mongoCollection{client.Database(dbName).Collection(checkpointCollection)}
+		if checkpoint != nil {
+			lastIDs, err := loadBatchCheckpoints(ctx, stored)
+			if err == nil {
+				err = checkpoint.applyStored(lastIDs)
+			}
+			if err != nil {
+				log.Fatalf("Can't resume from %s: %v", checkpointFile, err)
+			}
+		} else if err := removeBatchCheckpoints(ctx, stored); err != nil {
+			log.Fatalf("Error clearing old checkpoints: %v", err)
+		}
+	}
+	if checkpoint != nil {
+		scanRanges, err = checkpoint.resumeRanges(workers)
+		if err != nil {
+			log.Fatalf("Can't resume from %s: %v", checkpointFile, err)
+		}
+		slog.Info("Resuming each worker from checkpoint", "path", checkpointFile)
+	} else if mergeKey == "" && inputFile == "" {
+		scanRanges, err = computeWorkerRanges(ctx, mongoCollection{client.Database(dbName).Collection(sourceCollection)}, workers)
+		if err != nil {
+			log.Fatalf("Error partitioning properties: %v", err)
+		}
 	}
-	log.Println("Connected to MongoDB")
 	
-	// Count total properties
-	totalProperties, err := countTotalProperties(ctx, client)
-	if err != nil {
-		log.Fatalf("Error counting properties: %v", err)
+	// Periodically record each worker's progress for resuming
+	if checkpointFile != "" && !dryRun {
+		checkpoints = newCheckpointer(checkpointFile, scanRanges)
+		// Write the ranges right away, stored checkpoints are useless without them
+		if err := checkpoints.save(); err != nil {
+			log.Fatalf("Error writing checkpoint: %v", err)
+		}
+		go checkpoints.run(ctx)
 	}
-	log.Printf("Will process a total of %d properties", totalProperties)
 	
-	// Initialize Gemini client for embeddings
-	aiClient, err := genai.NewClient(ctx, option.WithAPIKey(apiKey))
-	if err != nil {
-		log.Fatalf("Error creating Gemini client: %v", err)
+	// Open the input and output files shared by all workers
+	if inputFile != "" {
+		inputSource, err = openJSONLSource(inputFile)
+		if err != nil {
+			log.Fatalf("Error reading properties: %v", err)
+		}
+		defer inputSource.Close()
+		slog.Info("Reading properties", "input_file", inputFile)
+	}
+	if outputFile != "" && !dryRun {
+		outputWriter, err = createJSONLWriter(outputFile)
+		if err != nil {
+			log.Fatalf("Error writing embeddings: %v", err)
+		}
+		slog.Info("Writing embedded properties", "output_file", outputFile)
+	}
+	
+	// Report properties skipped for a too short description
+	if skippedFile != "" && minDescriptionLength > 0 {
+		skippedReport, err = createSkippedIDWriter(skippedFile)
+		if err != nil {
+			log.Fatalf("Error creating skipped report: %v", err)
+		}
+		defer skippedReport.Close()
+	}
+	
+	// Periodically log overall progress against the total count
+	progressCtx, stopProgress := context.WithCancel(ctx)
+	defer stopProgress()
+	if progressInterval > 0 {
+		go runProgressReporter(progressCtx, totalProperties, progressInterval)
+	}
+	
+	// Workers read and write through the store, which is nil without a MongoDB connection
+	var store propertyStore
+	if client != nil {
+		store = mongoStore{client: client}
+	}
+	
+	// Create the target indexes once, before any worker writes, unless nothing is written to MongoDB
+	if !dryRun && outputWriter == nil {
+		if err := ensureTargetIndexes(ctx, store.Target()); err != nil {
+			log.Fatalf("Error preparing target collection: %v", err)
+		}
 	}
-	defer aiClient.Close()
 	
 	// Create a wait group to wait for all workers
 	var wg sync.WaitGroup
@@ -444,15 +1974,29 @@ func main() {
 		go func(workerID int) {
 			defer wg.Done()
 			
-			log.Printf("Starting worker %d", workerID)
+			slog.Info("Starting worker", "worker_id", workerID)
+			if health != nil {
+				health.workerStarted()
+				defer health.workerFinished()
+			}
 			
 			// Process properties
-			propertiesProcessed, err := processProperties(ctx, workerID, workers, client, aiClient)
+			var stats workerStats
+			var err error
+			if *workersAuto {
+				stats, err = processPropertiesAutoScaled(ctx, store, aiClient, scanRanges[0], *minWorkers, *maxWorkers)
+			} else {
+				stats, err = processProperties(ctx, workerID, workers, scanRanges[workerID-1], store, aiClient)
+			}
 			
 			// Send result
 			results <- WorkerResult{
 				WorkerID:            workerID,
-				PropertiesProcessed: propertiesProcessed,
+				PropertiesProcessed: stats.Processed,
+				Skipped:             stats.Skipped,
+				TooShort:            stats.TooShort,
+				FailedEmbedding:     stats.FailedEmbedding,
+				FailedInsert:        stats.FailedInsert,
 				Error:               err,
 			}
 		}(i)
@@ -467,20 +2011,117 @@ func main() {
 	// Collect results
 	completedWorkers := 0
 	totalProcessed := 0
+	var workerResults []WorkerResult
 	
 	for result := range results {
 		completedWorkers++
+		workerResults = append(workerResults, result)
 		
 		if result.Error != nil {
-			log.Printf("Worker %d encountered an error: %v", result.WorkerID, result.Error)
+			slog.Error("Worker encountered an error", "worker_id", result.WorkerID, "error", result.Error)
 		} else {
-			log.Printf("Worker %d completed processing %d properties", result.WorkerID, result.PropertiesProcessed)
+			slog.Info("Worker completed", "worker_id", result.WorkerID, "processed_count", result.PropertiesProcessed)
 			totalProcessed += result.PropertiesProcessed
 		}
 		
 		if completedWorkers == workers {
-			log.Printf("All workers completed. Total properties processed: %d", totalProcessed)
-			log.Println("Import completed successfully")
+			stopProgress()
+			if outputWriter != nil {
+				if err := outputWriter.Close(); err != nil {
+					log.Fatalf("Error writing embeddings: %v", err)
+				}
+			}
+			slog.Info("All workers completed", "processed_count", totalProcessed)
+			printWorkerSummary(os.Stdout, workerResults)
+			slog.Info("Skipped properties that already had embeddings", "skipped_count", propertiesSkipped.Load())
+			if minDescriptionLength > 0 {
+				slog.Info("Skipped properties with short descriptions", "skipped_count", propertiesTooShort.Load(), "min_length", minDescriptionLength)
+				if skippedReport != nil {
+					slog.Info("Skipped property IDs are listed in the skipped file", "path", skippedFile)
+				}
+			}
+			failed, processed := propertiesFailed.Load(), propertiesProcessed.Load()
+			slog.Info("Failed properties", "failed_count", failed, "processed_count", processed, "failure_percent", failureRate(failed, processed))
+			if outputFile == "" {
+				slog.Info("Checked for existing embeddings", "queries", existenceQueries.Load(), "existence_check", existenceCheckMode)
+			}
+			if dryRun {
+				slog.Info("Dry run: properties would be embedded", "embed_count", propertiesWouldEmbed.Load())
+				slog.Info("Dry run: estimated embedding input", "tokens", dryRunTokens.Load(), "cost", formatTokenCost(dryRunTokens.Load()))
+			} else {
+				if embedCacheSize > 0 {
+					slog.Info("Embedding cache", "hits", embedCacheHits.Load(), "misses", embedCacheMisses.Load(),
+						"hit_rate_percent", embedCacheHitRate())
+				}
+				slog.Info("Sent input to the embedding API", "tokens", embeddedTokens.Load(), "cost", formatTokenCost(embeddedTokens.Load()))
+			}
+			
+			// Keep the checkpoint for resuming an aborted run, drop it once done
+			if checkpoints != nil {
+				if context.Cause(ctx) != nil {
+					err = checkpoints.save()
+				} else {
+					err = checkpoints.remove()
+					if err == nil && useTransactions {
+						err = removeBatchCheckpoints(ctx, mongoCollection{client.Database(dbName).Collection(checkpointCollection)})
+					}
+				}
+				if err != nil {
+					slog.Warn("Error saving checkpoints", "error", err)
+				}
+			}
+			
+			// Advance the high-water mark only after a complete run, so nothing is missed next time
+			if highWaterMarkFile != "" && !dryRun && context.Cause(ctx) == nil {
+				if failed > 0 {
+					slog.Warn("Not advancing the high-water mark because properties failed", "failed_count", failed)
+				} else if mark := currentHighWaterMark(); mark.After(updatedSince) {
+					if err := saveHighWaterMark(highWaterMarkFile, mark); err != nil {
+						slog.Warn("Error storing the high-water mark", "error", err)
+					} else {
+						slog.Info("Stored high-water mark", "high_water_mark", mark.Format(time.RFC3339), "path", highWaterMarkFile)
+					}
+				}
+			}
+			
+			// Pruning is global, so only the first shard does it, and never after an aborted run
+			if reconcile && shardIndex == 0 && !dryRun && context.Cause(ctx) == nil {
+				deleted, err := pruneDeletedProperties(ctx, client)
+				if err != nil {
+					log.Fatalf("Error pruning deleted properties: %v", err)
+				}
+				slog.Info("Reconcile", "added_count", documentsAdded.Load(), "updated_count", documentsUpdated.Load(),
+					"deleted_count", deleted)
+			}
+			
+			// Drop the recorded failures of properties that are stored now, even after an aborted retry
+			if retryFailures && !dryRun {
+				removed, err := removeRecoveredFailures(context.WithoutCancel(ctx), store, retryIDs, retryStart)
+				if err != nil {
+					slog.Warn("Error removing recovered failures", "error", err)
+				}
+				slog.Info("Retry: failed properties recovered", "recovered_count", removed, "failed_count", len(retryIDs),
+					"remaining_count", int64(len(retryIDs))-removed, "collection", dbName+"."+failuresCollection)
+			}
+			if cause := context.Cause(ctx); cause != nil {
+				log.Fatalf("Import aborted: %v", cause)
+			}
+			
+			// Let schedulers notice failed workers or too many failed properties
+			var workerErrors int
+			for _, result := range workerResults {
+				if result.Error != nil {
+					workerErrors++
+				}
+			}
+			if workerErrors > 0 || failThreshold.exceeded(failed, processed) {
+				slog.Error("Import failed", "worker_errors", workerErrors, "failed_count", failed, "processed_count", processed)
+				if client != nil {
+					client.Disconnect(ctx)
+				}
+				os.Exit(1)
+			}
+			slog.Info("Import completed successfully")
 		}
 	}
 } 
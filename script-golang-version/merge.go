@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"strings"
+)
+
+// Conflict resolution policies when merging documents that share a business key
+const (
+	mergePreferFirst = "prefer-first"
+	mergePreferLast  = "prefer-last"
+)
+
+// Get the business key of a property as a string, empty when unset
+func mergeKeyValue(property *Property) string {
+	value, ok := fieldPathValue(reflect.ValueOf(property).Elem(), strings.Split(mergeKey, "."))
+	if !ok || value.IsZero() {
+		return ""
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+// The key a property is partitioned by when merging: its business key, or
+// its _id for documents without one, which are never merged
+func mergePartitionKey(property *Property) string {
+	if key := mergeKeyValue(property); key != "" {
+		return key
+	}
+	return property.ID.Hex()
+}
+
+// Hash a business key to the slot that picks its external shard and worker
+func keySlot(key string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return int(hash.Sum32())
+}
+
+// Check whether a business key belongs to this process's external shard
+func keyBelongsToShard(key string) bool {
+	return keySlot(key)%totalShards == shardIndex
+}
+
+// Check whether a business key belongs to this worker. Merging partitions by
+// key rather than scan position so every document of a group reaches the same worker.
+func keyBelongsToWorker(key string, workerID, totalWorkers int) bool {
+	slot := keySlot(key)
+	if slot%totalShards != shardIndex {
+		return false
+	}
+	return (slot/totalShards)%totalWorkers == workerID-1
+}
+
+// Merge two partial documents of the same listing into one property.
+// Non-empty fields win over empty ones; when both are set the policy decides
+// which document is preferred. Slices are combined without duplicates and the
+// first document's _id is kept so the stored document stays stable.
+func mergeProperties(first, second *Property, policy string) Property {
+	preferred, fallback := first, second
+	if policy == mergePreferLast {
+		preferred, fallback = second, first
+	}
+
+	merged := mergeStruct(reflect.ValueOf(*preferred), reflect.ValueOf(*fallback)).Interface().(Property)
+	merged.ID = first.ID
+	return merged
+}
+
+// Merge two struct values field by field, preferring non-empty values from preferred
+func mergeStruct(preferred, fallback reflect.Value) reflect.Value {
+	merged := reflect.New(preferred.Type()).Elem()
+	merged.Set(preferred)
+
+	for i := 0; i < merged.NumField(); i++ {
+		field := merged.Field(i)
+		other := fallback.Field(i)
+
+		switch field.Kind() {
+		case reflect.Ptr:
+			if field.IsNil() {
+				field.Set(other)
+			} else if !other.IsNil() && field.Elem().Kind() == reflect.Struct {
+				nested := reflect.New(field.Type().Elem())
+				nested.Elem().Set(mergeStruct(field.Elem(), other.Elem()))
+				field.Set(nested)
+			}
+		case reflect.Slice:
+			field.Set(unionSlices(field, other))
+		default:
+			if field.IsZero() {
+				field.Set(other)
+			}
+		}
+	}
+	return merged
+}
+
+// Append the elements of other that aren't already in values
+func unionSlices(values, other reflect.Value) reflect.Value {
+	if other.Len() == 0 {
+		return values
+	}
+	if values.Len() == 0 {
+		return other
+	}
+
+	seen := make(map[string]bool, values.Len())
+	union := reflect.MakeSlice(values.Type(), 0, values.Len()+other.Len())
+	for _, slice := range []reflect.Value{values, other} {
+		for i := 0; i < slice.Len(); i++ {
+			key := fmt.Sprint(slice.Index(i).Interface())
+			if !seen[key] {
+				seen[key] = true
+				union = reflect.Append(union, slice.Index(i))
+			}
+		}
+	}
+	return union
+}
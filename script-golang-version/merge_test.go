@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMergeProperties(t *testing.T) {
+	firstID, secondID := primitive.NewObjectID(), primitive.NewObjectID()
+	first := Property{
+		ID:       firstID,
+		City:     "Curitiba",
+		Ad:       &Ad{Title: "Flat"},
+		Bedrooms: 2,
+		Features: []string{"pool", "gym"},
+	}
+	second := Property{
+		ID:        secondID,
+		City:      "Curitiba - PR",
+		State:     "PR",
+		Ad:        &Ad{Title: "Flat downtown", Description: "Sunny"},
+		Company:   &Company{Name: "Imob"},
+		Bedrooms:  3,
+		Bathrooms: 1,
+		Features:  []string{"gym", "balcony"},
+	}
+
+	tests := []struct {
+		policy string
+		want   Property
+	}{
+		{policy: mergePreferFirst, want: Property{
+			ID:        firstID,
+			City:      "Curitiba",
+			State:     "PR",
+			Ad:        &Ad{Title: "Flat", Description: "Sunny"},
+			Company:   &Company{Name: "Imob"},
+			Bedrooms:  2,
+			Bathrooms: 1,
+			Features:  []string{"pool", "gym", "balcony"},
+		}},
+		{policy: mergePreferLast, want: Property{
+			ID:        firstID,
+			City:      "Curitiba - PR",
+			State:     "PR",
+			Ad:        &Ad{Title: "Flat downtown", Description: "Sunny"},
+			Company:   &Company{Name: "Imob"},
+			Bedrooms:  3,
+			Bathrooms: 1,
+			Features:  []string{"gym", "balcony", "pool"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			got := mergeProperties(&first, &second, tt.policy)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeProperties = %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+
+	// Merging builds new nested documents instead of changing the inputs
+	merged := mergeProperties(&first, &second, mergePreferFirst)
+	merged.Ad.Title = "Changed"
+	if first.Ad.Title != "Flat" || first.Ad.Description != "" || second.Ad.Title != "Flat downtown" {
+		t.Errorf("merging changed its inputs: %+v and %+v", first.Ad, second.Ad)
+	}
+}
+
+func TestKeyBelongsToWorker(t *testing.T) {
+	defer func(index, total int) { shardIndex, totalShards = index, total }(shardIndex, totalShards)
+	shardIndex, totalShards = 0, 1
+
+	// Every key goes to exactly one worker
+	for _, key := range []string{"A-1", "A-2", "B-17", "listing-42", ""} {
+		owners := 0
+		for workerID := 1; workerID <= 4; workerID++ {
+			if keyBelongsToWorker(key, workerID, 4) {
+				owners++
+			}
+		}
+		if owners != 1 {
+			t.Errorf("key %q belongs to %d workers, want 1", key, owners)
+		}
+	}
+}
+
+// Shard totals when merging follow the business key, like the workers do,
+// so the parts of a listing are counted by the shard that merges them
+func TestCountShardPropertiesUsesTheMergeKey(t *testing.T) {
+	defer func(index, total int, key string) {
+		shardIndex, totalShards, mergeKey = index, total, key
+	}(shardIndex, totalShards, mergeKey)
+	mergeKey = "commercialId"
+
+	source := &memoryCollection{}
+	var documents []interface{}
+	for i := 0; i < 60; i++ {
+		document := bson.M{"_id": primitive.NewObjectID()}
+		// Listings split over three documents, and some without a key
+		if i%4 != 0 {
+			document["commercialId"] = fmt.Sprintf("L-%d", i/3)
+		}
+		documents = append(documents, document)
+	}
+	if _, err := source.InsertMany(context.Background(), documents); err != nil {
+		t.Fatal(err)
+	}
+
+	totalShards = 3
+	var total int64
+	for shardIndex = 0; shardIndex < totalShards; shardIndex++ {
+		count, err := countShardProperties(context.Background(), source)
+		if err != nil {
+			t.Fatalf("countShardProperties: %v", err)
+		}
+		var want int64
+		for _, document := range documents {
+			key, _ := document.(bson.M)["commercialId"].(string)
+			property := Property{ID: document.(bson.M)["_id"].(primitive.ObjectID), CommercialID: key}
+			for workerID := 1; workerID <= 2; workerID++ {
+				if keyBelongsToWorker(mergePartitionKey(&property), workerID, 2) {
+					want++
+				}
+			}
+		}
+		if count != want {
+			t.Errorf("shard %d counts %d properties, its workers handle %d", shardIndex, count, want)
+		}
+		total += count
+	}
+	if total != int64(len(documents)) {
+		t.Errorf("shards count %d properties in total, want %d", total, len(documents))
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+)
+
+// Metric names reported by the pipeline
+const (
+	metricPropertiesProcessed = "properties_processed"
+	metricEmbeddingsGenerated = "embeddings_generated"
+	metricErrors              = "errors"
+	metricDocumentsInserted   = "documents_inserted"
+	metricEmbeddingLatency    = "embedding_latency"
+	metricSlowEmbeddings      = "slow_embeddings"
+	metricEmbeddingsInFlight  = "embeddings_in_flight"
+	metricInsertLatency       = "insert_latency"
+)
+
+// Metrics reports pipeline counters, gauges and timers to a monitoring backend
+type Metrics interface {
+	Count(name string, value int64, tags ...string)
+	Gauge(name string, value float64, tags ...string)
+	Timing(name string, duration time.Duration, tags ...string)
+	Close() error
+}
+
+// Metrics sink used by the pipeline, replaced in main when a backend is configured
+var metrics Metrics = noopMetrics{}
+
+// noopMetrics discards everything
+type noopMetrics struct{}
+
+func (noopMetrics) Count(string, int64, ...string)          {}
+func (noopMetrics) Gauge(string, float64, ...string)        {}
+func (noopMetrics) Timing(string, time.Duration, ...string) {}
+func (noopMetrics) Close() error                            { return nil }
+
+// statsdMetrics sends metrics over UDP in the DogStatsD line format,
+// which plain StatsD servers also accept when no tags are given
+type statsdMetrics struct {
+	conn   net.Conn
+	prefix string
+}
+
+// Create a StatsD emitter for the given host:port
+func newStatsdMetrics(addr, prefix string) (*statsdMetrics, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to StatsD at %s: %w", addr, err)
+	}
+	return &statsdMetrics{conn: conn, prefix: prefix}, nil
+}
+
+func (m *statsdMetrics) Count(name string, value int64, tags ...string) {
+	m.send(fmt.Sprintf("%s%s:%d|c", m.prefix, name, value), tags)
+}
+
+func (m *statsdMetrics) Gauge(name string, value float64, tags ...string) {
+	m.send(fmt.Sprintf("%s%s:%g|g", m.prefix, name, value), tags)
+}
+
+func (m *statsdMetrics) Timing(name string, duration time.Duration, tags ...string) {
+	m.send(fmt.Sprintf("%s%s:%d|ms", m.prefix, name, duration.Milliseconds()), tags)
+}
+
+func (m *statsdMetrics) Close() error {
+	return m.conn.Close()
+}
+
+// Write a single metric line; UDP send failures are not worth failing the run over
+func (m *statsdMetrics) send(line string, tags []string) {
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	if _, err := m.conn.Write([]byte(line)); err != nil {
+		slog.Warn("Failed to send metric to StatsD", "error", err)
+	}
+}
+
+// Create the metrics backend selected by configuration
+func newMetrics(backend, statsdAddr string, port int, prefix string) (Metrics, error) {
+	switch backend {
+	case "", "none":
+		return noopMetrics{}, nil
+	case "statsd":
+		return newStatsdMetrics(statsdAddr, prefix)
+	case "prometheus":
+		return newPrometheusMetrics(port, prefix), nil
+	default:
+		return nil, fmt.Errorf("unknown metrics backend %q", backend)
+	}
+}
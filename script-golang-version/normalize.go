@@ -0,0 +1,25 @@
+package main
+
+import "math"
+
+// L2-normalize every embedding before it's stored or used as a query
+var normalizeEmbeddings bool
+
+// Scale a vector to unit length so dot product equals cosine similarity.
+// A zero vector has no direction and is returned unchanged.
+func normalize(embedding []float32) []float32 {
+	var norm float64
+	for _, value := range embedding {
+		norm += float64(value) * float64(value)
+	}
+	if norm == 0 {
+		return embedding
+	}
+	norm = math.Sqrt(norm)
+
+	normalized := make([]float32, len(embedding))
+	for i, value := range embedding {
+		normalized[i] = float32(float64(value) / norm)
+	}
+	return normalized
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name      string
+		embedding []float32
+		want      []float32
+	}{
+		{name: "axis", embedding: []float32{0, 3, 0}, want: []float32{0, 1, 0}},
+		{name: "3-4-5", embedding: []float32{3, -4}, want: []float32{0.6, -0.8}},
+		{name: "already unit", embedding: []float32{0.6, 0.8}, want: []float32{0.6, 0.8}},
+		{name: "tiny values", embedding: []float32{1e-20, 1e-20}, want: []float32{math.Sqrt2 / 2, math.Sqrt2 / 2}},
+		{name: "zero vector", embedding: []float32{0, 0, 0}, want: []float32{0, 0, 0}},
+		{name: "empty", embedding: []float32{}, want: []float32{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := normalize(tt.embedding)
+			if len(got) != len(tt.want) {
+				t.Fatalf("normalize(%v) = %v, want %v", tt.embedding, got, tt.want)
+			}
+			var norm float64
+			for i, value := range got {
+				if math.IsNaN(float64(value)) || math.Abs(float64(value-tt.want[i])) > 1e-6 {
+					t.Fatalf("normalize(%v) = %v, want %v", tt.embedding, got, tt.want)
+				}
+				norm += float64(value) * float64(value)
+			}
+			// Only a zero vector, which has no direction, stays off unit length
+			if norm != 0 && math.Abs(math.Sqrt(norm)-1) > 1e-6 {
+				t.Errorf("norm of %v = %v, want 1", got, math.Sqrt(norm))
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// OpenAI embeddings endpoint
+const openAIEmbeddingsURL = "https://api.openai.com/v1/embeddings"
+
+// Output dimensions of known OpenAI embedding models
+var openAIModelDimensions = map[string]int{
+	"text-embedding-3-small": 1536,
+	"text-embedding-3-large": 3072,
+	"text-embedding-ada-002": 1536,
+}
+
+// OpenAI API key, required when EMBEDDING_PROVIDER=openai
+var openAIAPIKey string
+
+// OpenAIProvider calls the OpenAI embeddings API
+type OpenAIProvider struct {
+	apiKey string
+	model  string
+	client *http.Client
+}
+
+// openAIError is a non-2xx response from the OpenAI API
+type openAIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *openAIError) Error() string {
+	return fmt.Sprintf("OpenAI API error (HTTP %d): %s", e.StatusCode, e.Message)
+}
+
+func (p OpenAIProvider) Model() string { return p.model }
+
+func (p OpenAIProvider) Dimensions() int { return openAIModelDimensions[p.model] }
+
+func (p OpenAIProvider) Embed(ctx context.Context, text string) ([]float32, error) {
+	embeddings, err := p.EmbedBatch(ctx, []string{text})
+	if err != nil || len(embeddings) == 0 {
+		return nil, err
+	}
+	return embeddings[0], nil
+}
+
+func (p OpenAIProvider) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	body, err := json.Marshal(map[string]interface{}{"model": p.model, "input": texts})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, openAIEmbeddingsURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		message := string(raw)
+		if json.Unmarshal(raw, &failure) == nil && failure.Error.Message != "" {
+			message = failure.Error.Message
+		}
+		return nil, &openAIError{StatusCode: resp.StatusCode, Message: message}
+	}
+
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error decoding OpenAI response: %w", err)
+	}
+
+	// Place each embedding by its index, leaving missing ones empty
+	embeddings := make([][]float32, len(texts))
+	for _, data := range result.Data {
+		if data.Index >= 0 && data.Index < len(embeddings) {
+			embeddings[data.Index] = data.Embedding
+		}
+	}
+	return embeddings, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// idRange is a half-open range [from, to) of source _ids; a zero bound is
+// open. A resumed range also skips everything up to and including after.
+type idRange struct {
+	from  primitive.ObjectID
+	to    primitive.ObjectID
+	after primitive.ObjectID
+}
+
+// Add the range bounds to a filter's _id condition
+func (r idRange) apply(filter bson.M) bson.M {
+	if r.from.IsZero() && r.to.IsZero() && r.after.IsZero() {
+		return filter
+	}
+	condition, _ := filter["_id"].(bson.M)
+	if condition == nil {
+		condition = bson.M{}
+	}
+	if !r.from.IsZero() {
+		condition["$gte"] = r.from
+	}
+	if !r.to.IsZero() {
+		condition["$lt"] = r.to
+	}
+	if !r.after.IsZero() {
+		condition["$gt"] = r.after
+	}
+	filter["_id"] = condition
+	return filter
+}
+
+// Split the selected source properties within a range into parts contiguous
+// _id ranges of roughly equal size. Boundaries are found by skipping along the
+// _id index, so each worker can fetch only its own slice. Properties inserted
+// after the split still land in exactly one range.
+func computeIDRanges(ctx context.Context, collection propertyCollection, within idRange, parts int) ([]idRange, error) {
+	ranges := make([]idRange, parts)
+	ranges[0].from = within.from
+	ranges[parts-1].to = within.to
+	if parts == 1 {
+		return ranges, nil
+	}
+
+	filter := within.apply(sourceFilter())
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("error counting properties: %w", err)
+	}
+
+	previous := within.from
+	for part := 1; part < parts; part++ {
+		var boundary struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		err := collection.FindOne(ctx, filter, options.FindOne().
+			SetSort(bson.D{{Key: "_id", Value: 1}}).
+			SetSkip(total*int64(part)/int64(parts)).
+			SetProjection(bson.M{"_id": 1}),
+		).Decode(&boundary)
+		if err == mongo.ErrNoDocuments {
+			// Fewer properties than parts: the remaining ranges stay empty
+			boundary.ID = previous
+		} else if err != nil {
+			return nil, fmt.Errorf("error finding partition boundary: %w", err)
+		}
+		ranges[part-1].to = boundary.ID
+		ranges[part].from = boundary.ID
+		previous = boundary.ID
+	}
+	return ranges, nil
+}
+
+// Split the source into one _id range per worker. The ranges only divide the
+// work within this process; which properties belong to its external shard is
+// decided per property by shardFilter, so instances don't need to agree on
+// their worker counts, start times or data.
+func computeWorkerRanges(ctx context.Context, collection propertyCollection, workers int) ([]idRange, error) {
+	return computeIDRanges(ctx, collection, idRange{}, workers)
+}
+
+// Filter selecting this process's external shard on the server, so each
+// instance only reads its own slice of the source (nil without external
+// shards). A property's shard is the last three hex digits of its _id, the
+// low 12 bits of the counter, modulo -total-shards. The counter grows with
+// every ObjectId a client generates, so shards stay even even for a bulk
+// import within one second, and the shard only depends on the _id, so every
+// instance assigns each property to the same shard no matter when it starts
+// or which filter it runs with.
+func shardFilter() bson.M {
+	if totalShards <= 1 {
+		return nil
+	}
+	hex := bson.M{"$toString": "$_id"}
+	var digits bson.A
+	for i, weight := range []int{256, 16, 1} {
+		digit := bson.M{"$indexOfCP": bson.A{"0123456789abcdef", bson.M{"$substrCP": bson.A{hex, 21 + i, 1}}}}
+		digits = append(digits, bson.M{"$multiply": bson.A{digit, weight}})
+	}
+	return bson.M{"$expr": bson.M{"$eq": bson.A{
+		bson.M{"$mod": bson.A{bson.M{"$add": digits}, totalShards}},
+		shardIndex,
+	}}}
+}
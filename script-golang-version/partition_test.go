@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestShardsAreDisjointAndCoverEveryProperty(t *testing.T) {
+	defer func(index, total int) { shardIndex, totalShards = index, total }(shardIndex, totalShards)
+
+	// IDs created in bulk within the same second, like an import, and spread over time
+	var ids []primitive.ObjectID
+	for i := 0; i < 5000; i++ {
+		ids = append(ids, primitive.NewObjectID())
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5000; i++ {
+		ids = append(ids, primitive.NewObjectIDFromTimestamp(start.Add(time.Duration(i)*time.Minute)))
+	}
+
+	for _, shards := range []int{2, 3, 8} {
+		totalShards = shards
+		counts := make([]int, shards)
+		owners := make(map[primitive.ObjectID]int)
+		for shardIndex = 0; shardIndex < shards; shardIndex++ {
+			filter := shardFilter()["$expr"]
+			for _, id := range ids {
+				if evalExpression(t, filter, id) == true {
+					owners[id]++
+					counts[shardIndex]++
+				}
+			}
+		}
+		for _, id := range ids {
+			if owners[id] != 1 {
+				t.Fatalf("%d shards: property %s belongs to %d shards, want exactly 1", shards, id.Hex(), owners[id])
+			}
+		}
+
+		// Every shard gets a fair share
+		for shard, count := range counts {
+			if want := len(ids) / shards; count < want*8/10 {
+				t.Errorf("%d shards: shard %d has %d properties, want about %d", shards, shard, count, want)
+			}
+		}
+	}
+}
+
+// Instances of different versions must keep agreeing on shards, so the
+// assignment is pinned
+func TestShardFilterIsPinned(t *testing.T) {
+	defer func(index, total int) { shardIndex, totalShards = index, total }(shardIndex, totalShards)
+	tests := []struct {
+		id     string
+		shards int
+		want   int
+	}{
+		{"65a1b2c3d4e5f60718293a4b", 8, 3},
+		{"000000000000000000000000", 8, 0},
+		{"000000000000000000000fff", 3, 0},
+		{"ffffffffffffffffffffffff", 8, 7},
+	}
+	for _, tt := range tests {
+		id, err := primitive.ObjectIDFromHex(tt.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		totalShards = tt.shards
+		for shardIndex = 0; shardIndex < tt.shards; shardIndex++ {
+			if got := evalExpression(t, shardFilter()["$expr"], id) == true; got != (shardIndex == tt.want) {
+				t.Errorf("property %s in shard %d of %d: %t, want shard %d", tt.id, shardIndex, tt.shards, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestSourceFilterSelectsTheShard(t *testing.T) {
+	defer func(index, total int, key string) {
+		shardIndex, totalShards, mergeKey = index, total, key
+	}(shardIndex, totalShards, mergeKey)
+
+	shardIndex, totalShards, mergeKey = 0, 1, ""
+	if _, ok := sourceFilter()["$expr"]; ok {
+		t.Error("a single shard filters the source")
+	}
+	totalShards = 4
+	if _, ok := sourceFilter()["$expr"]; !ok {
+		t.Error("the source filter doesn't select the shard")
+	}
+	// Merging assigns shards by business key instead
+	mergeKey = "ad.id"
+	if _, ok := sourceFilter()["$expr"]; ok {
+		t.Error("merging filters the source by _id shard")
+	}
+}
+
+// Evaluate an aggregation expression for a document with the given _id, as
+// the server would, for the operators shardFilter uses
+func evalExpression(t *testing.T, expression interface{}, id primitive.ObjectID) interface{} {
+	switch e := expression.(type) {
+	case int:
+		return e
+	case string:
+		if e == "$_id" {
+			return id
+		}
+		return e
+	case bson.M:
+		for operator, argument := range e {
+			args, _ := argument.(bson.A)
+			arg := func(i int) interface{} { return evalExpression(t, args[i], id) }
+			switch operator {
+			case "$toString":
+				return evalExpression(t, argument, id).(primitive.ObjectID).Hex()
+			case "$substrCP":
+				s, start, length := arg(0).(string), arg(1).(int), arg(2).(int)
+				return s[start : start+length]
+			case "$indexOfCP":
+				return strings.Index(arg(0).(string), arg(1).(string))
+			case "$multiply":
+				return arg(0).(int) * arg(1).(int)
+			case "$add":
+				sum := 0
+				for i := range args {
+					sum += arg(i).(int)
+				}
+				return sum
+			case "$mod":
+				return arg(0).(int) % arg(1).(int)
+			case "$eq":
+				return arg(0) == arg(1)
+			}
+			t.Fatalf("unsupported operator %s", operator)
+		}
+	}
+	t.Fatalf("unsupported expression %v", expression)
+	return nil
+}
+
+// Each worker fetches only its own _id range, and together the ranges return
+// every property exactly once
+func TestWorkerRangesFetchEveryPropertyOnce(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct{ properties, workers int }{
+		{properties: 1003, workers: 1},
+		{properties: 1003, workers: 4},
+		{properties: 1003, workers: 7},
+		{properties: 3, workers: 5},
+		{properties: 0, workers: 3},
+	} {
+		t.Run(fmt.Sprintf("%d properties, %d workers", tt.properties, tt.workers), func(t *testing.T) {
+			source := &memoryCollection{}
+			start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			var documents []interface{}
+			for i := 0; i < tt.properties; i++ {
+				documents = append(documents, bson.M{"_id": primitive.NewObjectIDFromTimestamp(start.Add(time.Duration(i) * time.Second))})
+			}
+			if len(documents) > 0 {
+				if _, err := source.InsertMany(ctx, documents); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			ranges, err := computeWorkerRanges(ctx, source, tt.workers)
+			if err != nil {
+				t.Fatalf("computeWorkerRanges: %v", err)
+			}
+			if len(ranges) != tt.workers {
+				t.Fatalf("got %d ranges, want %d", len(ranges), tt.workers)
+			}
+			seen := make(map[primitive.ObjectID]int)
+			for i, r := range ranges {
+				fetched := fetchIDs(t, source, r.apply(sourceFilter()))
+				if want := tt.properties / tt.workers; tt.properties >= tt.workers && (len(fetched) < want || len(fetched) > want+1) {
+					t.Errorf("worker %d fetched %d properties, want about %d", i+1, len(fetched), want)
+				}
+				for _, id := range fetched {
+					seen[id]++
+				}
+			}
+			if len(seen) != tt.properties {
+				t.Errorf("fetched %d distinct properties, want %d", len(seen), tt.properties)
+			}
+			for id, count := range seen {
+				if count != 1 {
+					t.Errorf("property %s fetched %d times", id.Hex(), count)
+				}
+			}
+		})
+	}
+}
+
+// The _ids of the documents a Find with filter returns
+func fetchIDs(t *testing.T, collection propertyCollection, filter bson.M) []primitive.ObjectID {
+	t.Helper()
+	ctx := context.Background()
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	defer cursor.Close(ctx)
+	var ids []primitive.ObjectID
+	for cursor.Next(ctx) {
+		ids = append(ids, cursor.Current.Lookup("_id").ObjectID())
+	}
+	return ids
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Text embedded once by the pre-flight check
+const preflightText = "preflight check"
+
+// Check that the source collection exists and holds documents, so a wrong
+// DB_NAME or SOURCE_COLLECTION fails before any work is done rather than
+// looking like a run with nothing to process
+func checkSourceCollection(ctx context.Context, client *mongo.Client) error {
+	db := client.Database(dbName)
+	names, err := db.ListCollectionNames(ctx, bson.M{"name": sourceCollection})
+	if err != nil {
+		return fmt.Errorf("error listing collections in %s: %w", dbName, err)
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("source collection %s.%s doesn't exist", dbName, sourceCollection)
+	}
+
+	count, err := db.Collection(sourceCollection).CountDocuments(ctx, bson.M{}, options.Count().SetLimit(1))
+	if err != nil {
+		return fmt.Errorf("error reading source collection %s.%s: %w", dbName, sourceCollection, err)
+	}
+	if count == 0 {
+		return fmt.Errorf("source collection %s.%s is empty", dbName, sourceCollection)
+	}
+	return nil
+}
+
+// Embed a short text once, so a missing API key, a key without embedding
+// permission or an unknown model fails before any property is processed
+func checkEmbeddingModel(ctx context.Context) error {
+	embedding, err := generateEmbedding(ctx, preflightText)
+	if err != nil {
+		return fmt.Errorf("test embedding with %s failed: %w", embeddingModelLabel(), err)
+	}
+	slog.Info("Preflight: test embedding returned", "model", embeddingModelLabel(), "dimensions", len(embedding))
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// How price ranges are rendered in the description
+const (
+	priceRangeOff     = "off"     // exact price only
+	priceRangeAdd     = "add"     // exact price followed by its range
+	priceRangeReplace = "replace" // range instead of the exact price
+)
+
+// Price range rendering and the width of each range
+var (
+	priceRangeMode     string
+	priceRangeSaleStep float64
+	priceRangeRentStep float64
+)
+
+// Check a PRICE_RANGE_MODE value
+func validPriceRangeMode(mode string) bool {
+	return mode == priceRangeOff || mode == priceRangeAdd || mode == priceRangeReplace
+}
+
+// Lower and upper bound of the range containing price. Ranges are half-open,
+// so a price exactly on a boundary starts the next range.
+func priceRange(price, step float64) (float64, float64) {
+	lower := math.Floor(price/step) * step
+	return lower, lower + step
+}
+
+// Render the price range line, e.g. "Price range: Sale $500k–$600k", with
+// kind already localized
+func priceRangeLine(kind string, price, step float64) string {
+	lower, upper := priceRange(price, step)
+	return fmt.Sprintf("%s: %s $%s–$%s", labels.PriceRange, kind, compactAmount(lower), compactAmount(upper))
+}
+
+// Format an amount with a k or m suffix, e.g. 1500000 as "1.5m"
+func compactAmount(amount float64) string {
+	switch {
+	case amount >= 1e6:
+		return trimAmount(amount/1e6) + "m"
+	case amount >= 1e3:
+		return trimAmount(amount/1e3) + "k"
+	default:
+		return trimAmount(amount)
+	}
+}
+
+// Format with as few digits as needed, e.g. 1.5 or 600
+func trimAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', -1, 64)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPriceRange(t *testing.T) {
+	tests := []struct {
+		price, step  float64
+		lower, upper float64
+	}{
+		{price: 550000, step: 100000, lower: 500000, upper: 600000},
+		{price: 500000, step: 100000, lower: 500000, upper: 600000},
+		{price: 599999.99, step: 100000, lower: 500000, upper: 600000},
+		{price: 2500, step: 500, lower: 2500, upper: 3000},
+		{price: 40, step: 500, lower: 0, upper: 500},
+	}
+	for _, tt := range tests {
+		if lower, upper := priceRange(tt.price, tt.step); lower != tt.lower || upper != tt.upper {
+			t.Errorf("priceRange(%v, %v) = %v–%v, want %v–%v", tt.price, tt.step, lower, upper, tt.lower, tt.upper)
+		}
+	}
+}
+
+func TestCompactAmount(t *testing.T) {
+	tests := map[float64]string{
+		0:       "0",
+		500:     "500",
+		1000:    "1k",
+		2500:    "2.5k",
+		600000:  "600k",
+		1000000: "1m",
+		1500000: "1.5m",
+	}
+	for amount, want := range tests {
+		if got := compactAmount(amount); got != want {
+			t.Errorf("compactAmount(%v) = %q, want %q", amount, got, want)
+		}
+	}
+}
+
+// The built-in description shows the exact price, its range or both
+func TestDescriptionPriceRangeModes(t *testing.T) {
+	defer func(mode string, sale, rent float64) {
+		priceRangeMode, priceRangeSaleStep, priceRangeRentStep = mode, sale, rent
+	}(priceRangeMode, priceRangeSaleStep, priceRangeRentStep)
+	priceRangeSaleStep, priceRangeRentStep = 100000, 500
+	sale := Property{Ad: &Ad{TransactionType: "SALE"}, AskingPrice: 550000}
+	rent := Property{Ad: &Ad{TransactionType: "RENT"}, RentPrice: 2750}
+
+	tests := []struct {
+		mode     string
+		property Property
+		want     []string
+		notWant  []string
+	}{
+		{mode: priceRangeOff, property: sale, want: []string{"Price: Sale $550000.00"}, notWant: []string{"Price range"}},
+		{mode: priceRangeAdd, property: sale, want: []string{"Price: Sale $550000.00", "Price range: Sale $500k–$600k"}},
+		{mode: priceRangeReplace, property: sale, want: []string{"Price range: Sale $500k–$600k"}, notWant: []string{"550000"}},
+		{mode: priceRangeReplace, property: rent, want: []string{"Price range: Rent $2.5k–$3k"}, notWant: []string{"2750"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			priceRangeMode = tt.mode
+			description := createPropertyDescription(&tt.property)
+			for _, want := range tt.want {
+				if !strings.Contains(description, want+"\n") && !strings.HasSuffix(description, want) {
+					t.Errorf("description lacks %q:\n%s", want, description)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(description, notWant) {
+					t.Errorf("description contains %q:\n%s", notWant, description)
+				}
+			}
+		})
+	}
+}
+
+func TestValidPriceRangeMode(t *testing.T) {
+	for mode, want := range map[string]bool{"off": true, "add": true, "replace": true, "": false, "range": false} {
+		if got := validPriceRangeMode(mode); got != want {
+			t.Errorf("validPriceRangeMode(%q) = %t, want %t", mode, got, want)
+		}
+	}
+}
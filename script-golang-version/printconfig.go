@@ -0,0 +1,220 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Placeholder printed instead of secrets
+const redacted = "REDACTED"
+
+// Print the resolved configuration (environment, .env file and flags) with
+// secrets redacted
+func printConfig(w io.Writer) {
+	settings := []struct {
+		name  string
+		value interface{}
+	}{
+		{"MONGODB_URI", redactMongoURI(mongoURI)},
+		{"MONGODB_DB_NAME", dbName},
+		{"SOURCE_COLLECTION", sourceCollection},
+		{"TARGET_COLLECTION", targetCollection},
+		{"FAILURES_COLLECTION", failuresCollection},
+		{"GOOGLE_GENERATIVE_AI_API_KEY", redactSecret(apiKey)},
+		{"LOG_LEVEL", logLevel},
+		{"LOG_FORMAT", logFormat},
+		{"EMBEDDING_PROVIDER", embeddingProviderName},
+		{"EMBEDDING_MODEL", embeddingModelName},
+		{"GEMINI_TASK_TYPE", taskTypeName(geminiDocumentTaskType)},
+		{"GEMINI_QUERY_TASK_TYPE", taskTypeName(geminiQueryTaskType)},
+		{"OPENAI_API_KEY", redactSecret(openAIAPIKey)},
+		{"SOURCE_PIPELINE", formatPipeline(sourcePipeline)},
+		{"TENANT_ID", tenantID},
+		{"TENANT_FIELD", tenantField},
+		{"MERGE_KEY", mergeKey},
+		{"MERGE_POLICY", mergePolicy},
+		{"PRIORITY_SORT", formatSort(prioritySort)},
+		{"SUMMARY_MODEL", summaryModel},
+		{"SUMMARY_MODE", summaryMode},
+		{"SUMMARY_RPS", summaryRPS},
+		{"TRANSLATE_TO", translateTo},
+		{"TRANSLATION_MODEL", translationModel},
+		{"TRANSLATE_KEEP_ORIGINAL", translateKeepOriginal},
+		{"TRANSLATION_RPS", translationRPS},
+		{"WORKER_COUNT", workerCount},
+		{"MAX_OPEN_CURSORS", maxOpenCursors},
+		{"MONGO_MAX_POOL_SIZE", mongoMaxPoolSize},
+		{"MONGO_MIN_POOL_SIZE", mongoMinPoolSize},
+		{"MONGO_SERVER_SELECTION_TIMEOUT", mongoServerSelectionTimeout},
+		{"EMBED_MAX_CONCURRENCY", embedMaxConcurrency},
+		{"EMBED_RPM", embedRPM},
+		{"EMBED_RPS", embedRPS},
+		{"EMBED_MAX_TOKENS", embedMaxTokens},
+		{"LONG_TEXT_STRATEGY", longTextStrategy},
+		{"BATCH_SIZE", batchSize},
+		{"INSERT_QUEUE_SIZE", insertQueueSize},
+		{"EMBED_BATCH_SIZE", embedBatchSize},
+		{"EMBED_CONCURRENCY", embedConcurrency},
+		{"EMBED_CACHE_SIZE", embedCacheSize},
+		{"CACHE_FILE", cacheFile},
+		{"EXISTENCE_CHECK_WINDOW", existenceCheckWindow},
+		{"EXISTENCE_CHECK", existenceCheckMode},
+		{"EXISTENCE_PRELOAD_CHUNK", existencePreloadChunk},
+		{"PRICE_RANGE_MODE", priceRangeMode},
+		{"PRICE_RANGE_SALE_STEP", priceRangeSaleStep},
+		{"PRICE_RANGE_RENT_STEP", priceRangeRentStep},
+		{"DESCRIPTION_LOCALE", descriptionLocale},
+		{"DESCRIPTION_TEMPLATE", descriptionTemplateFile},
+		{"DESCRIPTION_FIELD_CAPS", formatFieldCaps(fieldCaps)},
+		{"MIN_DESCRIPTION_LENGTH", minDescriptionLength},
+		{"SKIPPED_FILE", skippedFile},
+		{"AREA_CONFLICT_POLICY", areaConflictPolicy},
+		{"METADATA_FIELDS", strings.Join(metadataFields, ",")},
+		{"METADATA_FIELD", metadataField},
+		{"VECTOR_FIELD", vectorField},
+		{"CHECKPOINT_FILE", checkpointFile},
+		{"PROGRESS_INTERVAL", progressInterval},
+		{"VECTOR_INDEX", vectorIndexName},
+		{"SEARCH_DIMENSION_CHECK", searchDimensionCheck},
+		{"INCREMENTAL_SINCE", formatTime(incrementalSince)},
+		{"HIGH_WATER_MARK_FILE", highWaterMarkFile},
+		{"EMBED_SOURCE_FIELD", embedSourceField},
+		{"FIELD_EMBEDDINGS", strings.Join(fieldEmbeddingNames, ",")},
+		{"METRICS_BACKEND", metricsBackend},
+		{"STATSD_ADDR", statsdAddr},
+		{"METRICS_PORT", metricsPort},
+		{"METRICS_PREFIX", metricsPrefix},
+		{"EMBED_DIMENSIONS", embedDimensions},
+		{"EMBED_DIMENSION_MISMATCH", dimensionMismatchPolicy},
+		{"EMBED_TIMEOUT", embedTimeout},
+		{"RETRY_MAX_BACKOFF", retryMaxBackoff},
+		{"EMBED_MAX_RETRIES", embedMaxRetries},
+		{"EMBED_COST_PER_1K", embedCostPer1K},
+		{"EMBED_INITIAL_BACKOFF_MS", embedInitialBackoff.Milliseconds()},
+		{"ENSEMBLE_MODEL", ensembleModel},
+		{"ENSEMBLE_STRATEGY", ensembleStrategy},
+		{"ENSEMBLE_WEIGHT", ensembleWeight},
+	}
+	for _, setting := range settings {
+		fmt.Fprintf(w, "%s=%v\n", setting.name, setting.value)
+	}
+
+	flag.VisitAll(func(f *flag.Flag) {
+		fmt.Fprintf(w, "-%s=%s\n", f.Name, f.Value.String())
+	})
+}
+
+// Hide a secret, keeping only whether it is set
+func redactSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
+// Hide the password in a MongoDB connection string, and any query option that
+// may carry credentials. Parsed by hand because multi-host URIs such as
+// mongodb://user:pass@a:27017,b:27017/ aren't valid URLs for net/url.
+func redactMongoURI(uri string) string {
+	scheme, rest, ok := strings.Cut(uri, "://")
+	if !ok {
+		return redactSecret(uri)
+	}
+
+	hosts, path, hasPath := strings.Cut(rest, "/")
+	if at := strings.LastIndex(hosts, "@"); at >= 0 {
+		if user, _, hasPassword := strings.Cut(hosts[:at], ":"); hasPassword {
+			hosts = user + ":" + redacted + hosts[at:]
+		}
+	}
+	if !hasPath {
+		return scheme + "://" + hosts
+	}
+
+	database, query, hasQuery := strings.Cut(path, "?")
+	if !hasQuery {
+		return scheme + "://" + hosts + "/" + database
+	}
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		if isSecretOption(key) {
+			params[i] = key + "=" + redacted
+		}
+	}
+	return scheme + "://" + hosts + "/" + database + "?" + strings.Join(params, "&")
+}
+
+// Connection string options that can hold credentials
+func isSecretOption(key string) bool {
+	key, err := url.QueryUnescape(key)
+	if err != nil {
+		return true
+	}
+	key = strings.ToLower(key)
+	for _, marker := range []string{"password", "secret", "token", "authmechanismproperties"} {
+		if strings.Contains(key, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// Format pipeline stages as Extended JSON
+func formatPipeline(stages []bson.D) string {
+	if len(stages) == 0 {
+		return ""
+	}
+	parts := make([]string, len(stages))
+	for i, stage := range stages {
+		data, err := bson.MarshalExtJSON(stage, false, false)
+		if err != nil {
+			parts[i] = fmt.Sprint(stage)
+			continue
+		}
+		parts[i] = string(data)
+	}
+	return "[" + strings.Join(parts, ",") + "]"
+}
+
+// Format a sort as "field desc, field asc"
+func formatSort(sort bson.D) string {
+	parts := make([]string, len(sort))
+	for i, field := range sort {
+		direction := "asc"
+		if field.Value == -1 {
+			direction = "desc"
+		}
+		parts[i] = field.Key + " " + direction
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Format field caps as "name=limit" pairs in name order
+func formatFieldCaps(caps map[string]int) string {
+	names := make([]string, 0, len(caps))
+	for name := range caps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s=%d", name, caps[name])
+	}
+	return strings.Join(parts, ",")
+}
+
+// Format a timestamp as RFC 3339, or empty when unset
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"time"
+)
+
+// How often overall progress is logged (disabled when 0)
+var progressInterval time.Duration
+
+// Periodically log how many of the total properties all workers have
+// processed, the throughput since the last report and the estimated time left
+func runProgressReporter(ctx context.Context, total int64, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	last, lastAt := propertiesProcessed.Load(), start
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			processed := propertiesProcessed.Load()
+			throughput := float64(processed-last) / now.Sub(lastAt).Seconds()
+			last, lastAt = processed, now
+
+			attrs := []any{
+				"processed_count", processed,
+				"total_count", total,
+				"percent", progressPercent(processed, total),
+				"properties_per_sec", round2(throughput),
+			}
+			if eta, ok := estimateRemaining(processed, total, now.Sub(start)); ok {
+				attrs = append(attrs, "eta", eta.Round(time.Second).String())
+			}
+			slog.Info("Progress", attrs...)
+		}
+	}
+}
+
+// Share of the total processed, in percent
+func progressPercent(processed, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return round2(float64(processed) / float64(total) * 100)
+}
+
+// Estimate the time left from the average rate so far, which is steadier than
+// the throughput of a single interval. There's no estimate before any progress.
+func estimateRemaining(processed, total int64, elapsed time.Duration) (time.Duration, bool) {
+	if processed <= 0 || elapsed <= 0 {
+		return 0, false
+	}
+	remaining := total - processed
+	if remaining <= 0 {
+		return 0, true
+	}
+	return time.Duration(float64(elapsed) / float64(processed) * float64(remaining)), true
+}
+
+// Round to two decimals for readable logs
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Build the stored metadata from the configured field list.
+// The _id is always kept since it keys the target documents.
+func projectProperty(property *Property, fields []string) Property {
+	if len(fields) == 0 {
+		return *property
+	}
+
+	projected := Property{ID: property.ID}
+	src := reflect.ValueOf(property).Elem()
+	dst := reflect.ValueOf(&projected).Elem()
+	for _, field := range fields {
+		copyFieldPath(src, dst, strings.Split(field, "."))
+	}
+	return projected
+}
+
+// Copy the value at a dotted bson path from src to dst, allocating nested structs as needed
+func copyFieldPath(src, dst reflect.Value, path []string) {
+	index, ok := bsonFieldIndex(src.Type(), path[0])
+	if !ok {
+		return
+	}
+	srcField := src.Field(index)
+	dstField := dst.Field(index)
+
+	if len(path) == 1 {
+		dstField.Set(srcField)
+		return
+	}
+
+	if srcField.Kind() == reflect.Ptr {
+		if srcField.IsNil() {
+			return
+		}
+		if dstField.IsNil() {
+			dstField.Set(reflect.New(srcField.Type().Elem()))
+		}
+		srcField, dstField = srcField.Elem(), dstField.Elem()
+	}
+	copyFieldPath(srcField, dstField, path[1:])
+}
+
+// Check that every configured path names a field of Property
+func validateMetadataFields(fields []string) error {
+	for _, field := range fields {
+		if err := validateFieldPath(field); err != nil {
+			return fmt.Errorf("metadata field %w", err)
+		}
+	}
+	return nil
+}
+
+// Check that a dotted bson path names a field of Property
+func validateFieldPath(field string) error {
+	t := reflect.TypeOf(Property{})
+	for _, name := range strings.Split(field, ".") {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return fmt.Errorf("%q: %q is not a nested document", field, name)
+		}
+		index, ok := bsonFieldIndex(t, name)
+		if !ok {
+			return fmt.Errorf("%q: unknown field %q", field, name)
+		}
+		t = t.Field(index).Type
+	}
+	return nil
+}
+
+// Read the value at a dotted bson path, reporting false when a parent is nil
+func fieldPathValue(value reflect.Value, path []string) (reflect.Value, bool) {
+	for _, name := range path {
+		if value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				return reflect.Value{}, false
+			}
+			value = value.Elem()
+		}
+		index, ok := bsonFieldIndex(value.Type(), name)
+		if !ok {
+			return reflect.Value{}, false
+		}
+		value = value.Field(index)
+	}
+	return value, true
+}
+
+// Find a struct field by its bson tag name
+func bsonFieldIndex(t reflect.Type, name string) (int, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.Split(t.Field(i).Tag.Get("bson"), ",")[0]
+		if tag == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Split a comma-separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestProjectProperty(t *testing.T) {
+	id := primitive.NewObjectID()
+	fee := 450.0
+	property := Property{
+		ID:          id,
+		City:        "Curitiba",
+		State:       "PR",
+		Ad:          &Ad{Title: "Flat", TransactionType: "RENT"},
+		Company:     &Company{Name: "Imob", SmallLogo: "logo.png"},
+		Bedrooms:    2,
+		CondoFee:    &fee,
+		Features:    []string{"pool"},
+		AskingPrice: 300000,
+	}
+
+	tests := []struct {
+		name     string
+		property Property
+		fields   []string
+		want     Property
+	}{
+		{name: "no fields keeps everything", property: property, want: property},
+		{name: "top-level fields", property: property, fields: []string{"city", "bedrooms", "features"},
+			want: Property{ID: id, City: "Curitiba", Bedrooms: 2, Features: []string{"pool"}}},
+		{name: "nested field", property: property, fields: []string{"company.name"},
+			want: Property{ID: id, Company: &Company{Name: "Imob"}}},
+		{name: "whole nested document", property: property, fields: []string{"ad", "condoFee"},
+			want: Property{ID: id, Ad: property.Ad, CondoFee: &fee}},
+		{name: "nil parent is skipped", property: Property{ID: id, City: "Curitiba"}, fields: []string{"city", "agent.name"},
+			want: Property{ID: id, City: "Curitiba"}},
+		{name: "unknown field is ignored", property: property, fields: []string{"state", "unknown"},
+			want: Property{ID: id, State: "PR"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := projectProperty(&tt.property, tt.fields); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("projectProperty(%v) = %+v, want %+v", tt.fields, got, tt.want)
+			}
+		})
+	}
+
+	// Projecting a nested field copies it rather than sharing the source's document
+	projected := projectProperty(&property, []string{"company.name"})
+	projected.Company.Name = "Other"
+	if property.Company.Name != "Imob" {
+		t.Error("changing the projection changed the source property")
+	}
+}
+
+func TestValidateMetadataFields(t *testing.T) {
+	tests := []struct {
+		fields  []string
+		wantErr bool
+	}{
+		{fields: nil},
+		{fields: []string{"city", "ad.title", "company.largeLogo"}},
+		{fields: []string{"cidade"}, wantErr: true},
+		{fields: []string{"ad.price"}, wantErr: true},
+		{fields: []string{"city.name"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := validateMetadataFields(tt.fields); (err != nil) != tt.wantErr {
+			t.Errorf("validateMetadataFields(%v) error = %v, want error %t", tt.fields, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Latency histogram buckets, from 10ms to about 40s
+var latencyBuckets = prometheus.ExponentialBuckets(0.01, 2, 13)
+
+// prometheusMetrics serves metrics on /metrics for Prometheus to scrape.
+// Collectors are created on first use, with one label per "key:value" tag.
+type prometheusMetrics struct {
+	server   *http.Server
+	registry *prometheus.Registry
+	prefix   string
+
+	mu         sync.Mutex
+	counters   map[string]*prometheus.CounterVec
+	gauges     map[string]*prometheus.GaugeVec
+	histograms map[string]*prometheus.HistogramVec
+}
+
+// Start serving Prometheus metrics on the given port
+func newPrometheusMetrics(port int, prefix string) *prometheusMetrics {
+	m := &prometheusMetrics{
+		registry:   prometheus.NewRegistry(),
+		prefix:     strings.NewReplacer(".", "_", "-", "_").Replace(prefix),
+		counters:   make(map[string]*prometheus.CounterVec),
+		gauges:     make(map[string]*prometheus.GaugeVec),
+		histograms: make(map[string]*prometheus.HistogramVec),
+	}
+	m.registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	m.server = &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: mux}
+	go func() {
+		if err := m.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server error", "error", err)
+		}
+	}()
+	return m
+}
+
+func (m *prometheusMetrics) Count(name string, value int64, tags ...string) {
+	labels, values := splitTags(tags)
+	m.mu.Lock()
+	counter, ok := m.counters[name]
+	if !ok {
+		counter = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: m.prefix + name + "_total",
+			Help: "Total " + strings.ReplaceAll(name, "_", " "),
+		}, labels)
+		m.register(counter)
+		m.counters[name] = counter
+	}
+	m.mu.Unlock()
+	if c, err := counter.GetMetricWithLabelValues(values...); err == nil {
+		c.Add(float64(value))
+	}
+}
+
+func (m *prometheusMetrics) Gauge(name string, value float64, tags ...string) {
+	labels, values := splitTags(tags)
+	m.mu.Lock()
+	gauge, ok := m.gauges[name]
+	if !ok {
+		gauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: m.prefix + name,
+			Help: "Current " + strings.ReplaceAll(name, "_", " "),
+		}, labels)
+		m.register(gauge)
+		m.gauges[name] = gauge
+	}
+	m.mu.Unlock()
+	if g, err := gauge.GetMetricWithLabelValues(values...); err == nil {
+		g.Set(value)
+	}
+}
+
+func (m *prometheusMetrics) Timing(name string, duration time.Duration, tags ...string) {
+	labels, values := splitTags(tags)
+	m.mu.Lock()
+	histogram, ok := m.histograms[name]
+	if !ok {
+		histogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    m.prefix + name + "_seconds",
+			Help:    strings.ReplaceAll(name, "_", " ") + " in seconds",
+			Buckets: latencyBuckets,
+		}, labels)
+		m.register(histogram)
+		m.histograms[name] = histogram
+	}
+	m.mu.Unlock()
+	if h, err := histogram.GetMetricWithLabelValues(values...); err == nil {
+		h.Observe(duration.Seconds())
+	}
+}
+
+// Stop the metrics server, waiting briefly for in-flight scrapes
+func (m *prometheusMetrics) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return m.server.Shutdown(ctx)
+}
+
+// Register a collector; a clash only loses that metric, so it's just logged
+func (m *prometheusMetrics) register(collector prometheus.Collector) {
+	if err := m.registry.Register(collector); err != nil {
+		slog.Warn("Failed to register metric", "error", err)
+	}
+}
+
+// Split "key:value" tags into label names and values
+func splitTags(tags []string) ([]string, []string) {
+	labels := make([]string, len(tags))
+	values := make([]string, len(tags))
+	for i, tag := range tags {
+		labels[i], values[i], _ = strings.Cut(tag, ":")
+	}
+	return labels, values
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Embed missing and changed properties, then prune deleted ones
+var reconcile bool
+
+// Stored documents added and replaced across all workers
+var (
+	documentsAdded   atomic.Int64
+	documentsUpdated atomic.Int64
+)
+
+// Delete stored documents whose source property no longer exists. Only
+// documents from the configured source database and collection (or written
+// before provenance was recorded) are considered, and existence is checked
+// against the whole source collection, ignoring any source filter.
+func pruneDeletedProperties(ctx context.Context, client *mongo.Client) (int64, error) {
+	sourceDB := client.Database(dbName).Collection(sourceCollection)
+	targetDB := client.Database(dbName).Collection(targetCollection)
+	idPath := storedPath("metadata._id")
+
+	filter := bson.M{"$or": bson.A{
+		bson.M{"source_db": dbName, "source_collection": sourceCollection},
+		bson.M{"source_db": bson.M{"$exists": false}},
+	}}
+	cursor, err := targetDB.Find(ctx, filter, options.Find().SetProjection(bson.M{idPath: 1}))
+	if err != nil {
+		return 0, fmt.Errorf("error scanning stored documents: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var deleted int64
+	pruneChunk := func(ids []primitive.ObjectID) error {
+		existing, err := existingSourceIDs(ctx, sourceDB, ids)
+		if err != nil {
+			return err
+		}
+		var missing []primitive.ObjectID
+		for _, id := range ids {
+			if !existing[id] {
+				missing = append(missing, id)
+			}
+		}
+		if len(missing) == 0 {
+			return nil
+		}
+		result, err := targetDB.DeleteMany(ctx, bson.M{idPath: bson.M{"$in": missing}})
+		if err != nil {
+			return fmt.Errorf("error deleting stored documents: %w", err)
+		}
+		deleted += result.DeletedCount
+		return nil
+	}
+
+	var ids []primitive.ObjectID
+	for cursor.Next(ctx) {
+		var doc PropertyWithEmbedding
+		if err := decodeStoredDocument(cursor.Current, &doc); err != nil {
+			return deleted, fmt.Errorf("error decoding stored document: %w", err)
+		}
+		ids = append(ids, doc.Metadata.ID)
+		if len(ids) >= 1000 {
+			if err := pruneChunk(ids); err != nil {
+				return deleted, err
+			}
+			ids = nil
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return deleted, fmt.Errorf("cursor error: %w", err)
+	}
+	if len(ids) > 0 {
+		if err := pruneChunk(ids); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// Find which of the given ids still exist in the source collection
+func existingSourceIDs(ctx context.Context, sourceDB *mongo.Collection, ids []primitive.ObjectID) (map[primitive.ObjectID]bool, error) {
+	cursor, err := sourceDB.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("error checking source properties: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	existing := make(map[primitive.ObjectID]bool, len(ids))
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("error decoding property id: %w", err)
+		}
+		existing[doc.ID] = true
+	}
+	return existing, cursor.Err()
+}
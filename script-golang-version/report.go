@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// Print a table of each worker's counts and the totals across workers
+func printWorkerSummary(w io.Writer, results []WorkerResult) {
+	sort.Slice(results, func(i, j int) bool { return results[i].WorkerID < results[j].WorkerID })
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(table, "Worker\tProcessed\tSkipped\tToo short\tFailed embedding\tFailed insert\tStatus\t")
+	var total WorkerResult
+	for _, result := range results {
+		status := "ok"
+		if result.Error != nil {
+			status = "error"
+		}
+		fmt.Fprintf(table, "%d\t%d\t%d\t%d\t%d\t%d\t%s\t\n", result.WorkerID, result.PropertiesProcessed,
+			result.Skipped, result.TooShort, result.FailedEmbedding, result.FailedInsert, status)
+		total.PropertiesProcessed += result.PropertiesProcessed
+		total.Skipped += result.Skipped
+		total.TooShort += result.TooShort
+		total.FailedEmbedding += result.FailedEmbedding
+		total.FailedInsert += result.FailedInsert
+	}
+	fmt.Fprintf(table, "Total\t%d\t%d\t%d\t%d\t%d\t\t\n", total.PropertiesProcessed,
+		total.Skipped, total.TooShort, total.FailedEmbedding, total.FailedInsert)
+	table.Flush()
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Check whether a failed API request may succeed when retried. Errors without
+// a status, such as network failures and empty responses, count as transient.
+func isRetryable(err error) bool {
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded, codes.Internal:
+			return true
+		}
+		return false
+	}
+	var apiErr *apierror.APIError
+	if errors.As(err, &apiErr) && apiErr.HTTPCode() > 0 {
+		return retryableHTTPStatus(apiErr.HTTPCode())
+	}
+	var openAIErr *openAIError
+	if errors.As(err, &openAIErr) {
+		return retryableHTTPStatus(openAIErr.StatusCode)
+	}
+	return true
+}
+
+// Rate limiting, timeouts and server errors are worth retrying
+func retryableHTTPStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusRequestTimeout || code >= 500
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// An attempt cut off by EMBED_TIMEOUT is transient, however the client reports it
+func TestIsRetryableTimeouts(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{name: "context deadline", err: context.DeadlineExceeded},
+		{name: "wrapped context deadline", err: fmt.Errorf("embedding: %w", context.DeadlineExceeded)},
+		{name: "gRPC deadline status", err: status.Error(codes.DeadlineExceeded, "deadline exceeded")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !isRetryable(tt.err) {
+				t.Errorf("isRetryable(%v) = false, want true", tt.err)
+			}
+		})
+	}
+}
+
+// hangingProvider blocks its first hangs requests until their context is
+// done, like an API that stopped answering, and answers the rest
+type hangingProvider struct {
+	hangs    int
+	requests int
+}
+
+func (p *hangingProvider) Model() string   { return "hanging" }
+func (p *hangingProvider) Dimensions() int { return 1 }
+
+func (p *hangingProvider) Embed(ctx context.Context, text string) ([]float32, error) {
+	p.requests++
+	if p.requests <= p.hangs {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return []float32{1}, nil
+}
+
+func (p *hangingProvider) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	values, err := p.Embed(ctx, texts[0])
+	if err != nil {
+		return nil, err
+	}
+	return [][]float32{values}, nil
+}
+
+// A hung request fails once EMBED_TIMEOUT passes and is retried, instead of
+// blocking the worker
+func TestGenerateEmbeddingsWithRetryTimesOutHungAttempts(t *testing.T) {
+	setupWorkerTest(t, stubProvider{}, 10)
+	defer func(backoff time.Duration) { retryMaxBackoff = backoff }(retryMaxBackoff)
+	retryMaxBackoff = time.Millisecond
+	embedTimeout = 20 * time.Millisecond
+
+	provider := &hangingProvider{hangs: 2}
+	start := time.Now()
+	embeddings, err := generateEmbeddingsWithRetry(context.Background(), provider, []string{"casa"}, 3, time.Millisecond)
+	if err != nil {
+		t.Fatalf("generateEmbeddingsWithRetry: %v", err)
+	}
+	if len(embeddings) != 1 || provider.requests != 3 {
+		t.Errorf("got %d embeddings after %d requests, want 1 after 3", len(embeddings), provider.requests)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %s, want each hung attempt cut off after %s", elapsed, embedTimeout)
+	}
+}
+
+// Full jitter draws each delay from zero up to the doubled backoff, capped at RETRY_MAX_BACKOFF
+func TestRetryBackoffStaysWithinBounds(t *testing.T) {
+	defer func(max time.Duration) { retryMaxBackoff = max }(retryMaxBackoff)
+	retryMaxBackoff = 5 * time.Second
+
+	tests := []struct {
+		retries int
+		ceiling time.Duration
+	}{
+		{retries: 0, ceiling: time.Second},
+		{retries: 1, ceiling: 2 * time.Second},
+		{retries: 2, ceiling: 4 * time.Second},
+		{retries: 3, ceiling: 5 * time.Second},
+		{retries: 30, ceiling: 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("retry %d", tt.retries), func(t *testing.T) {
+			var longest time.Duration
+			for i := 0; i < 1000; i++ {
+				delay := retryBackoff(time.Second, tt.retries)
+				if delay < 0 || delay > tt.ceiling {
+					t.Fatalf("retryBackoff = %v, want within [0, %v]", delay, tt.ceiling)
+				}
+				longest = max(longest, delay)
+			}
+			// 1000 uniform draws all landing in the lower half is vanishingly unlikely
+			if longest < tt.ceiling/2 {
+				t.Errorf("longest of 1000 delays = %v, want jitter across [0, %v]", longest, tt.ceiling)
+			}
+		})
+	}
+}
+
+// statusProvider fails every request with a gRPC status of its code
+type statusProvider struct {
+	code     codes.Code
+	requests int
+}
+
+func (p *statusProvider) Model() string   { return "status" }
+func (p *statusProvider) Dimensions() int { return 1 }
+
+func (p *statusProvider) Embed(ctx context.Context, text string) ([]float32, error) {
+	p.requests++
+	return nil, status.Error(p.code, p.code.String())
+}
+
+func (p *statusProvider) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	_, err := p.Embed(ctx, texts[0])
+	return nil, err
+}
+
+// Transient statuses use every attempt; permanent ones fail on the first
+func TestGenerateEmbeddingsWithRetryByStatus(t *testing.T) {
+	setupWorkerTest(t, stubProvider{}, 10)
+	defer func(backoff time.Duration) { retryMaxBackoff = backoff }(retryMaxBackoff)
+	retryMaxBackoff = time.Millisecond
+
+	tests := []struct {
+		code     codes.Code
+		requests int
+	}{
+		{code: codes.Unavailable, requests: 3},
+		{code: codes.ResourceExhausted, requests: 3},
+		{code: codes.DeadlineExceeded, requests: 3},
+		{code: codes.Internal, requests: 3},
+		{code: codes.InvalidArgument, requests: 1},
+		{code: codes.PermissionDenied, requests: 1},
+		{code: codes.Unauthenticated, requests: 1},
+		{code: codes.NotFound, requests: 1},
+		{code: codes.FailedPrecondition, requests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code.String(), func(t *testing.T) {
+			provider := &statusProvider{code: tt.code}
+			_, err := generateEmbeddingsWithRetry(context.Background(), provider, []string{"casa"}, 3, time.Millisecond)
+			if status.Code(errors.Unwrap(err)) != tt.code {
+				t.Errorf("error = %v, want status %s", err, tt.code)
+			}
+			if provider.requests != tt.requests {
+				t.Errorf("made %d requests, want %d", provider.requests, tt.requests)
+			}
+		})
+	}
+}
+
+func TestIsRetryableHTTPErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "network error", err: errors.New("connection reset"), want: true},
+		{name: "empty embedding", err: errEmptyEmbedding, want: true},
+		{name: "OpenAI rate limit", err: &openAIError{StatusCode: http.StatusTooManyRequests}, want: true},
+		{name: "OpenAI server error", err: &openAIError{StatusCode: http.StatusBadGateway}, want: true},
+		{name: "OpenAI bad request", err: &openAIError{StatusCode: http.StatusBadRequest}, want: false},
+		{name: "wrapped OpenAI auth error", err: fmt.Errorf("embed: %w", &openAIError{StatusCode: http.StatusUnauthorized}), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.want {
+				t.Errorf("isRetryable(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// The overall context bounds the retries: once it's done no further attempt
+// starts, however many are left
+func TestGenerateEmbeddingsWithRetryStopsAtTheOverallDeadline(t *testing.T) {
+	setupWorkerTest(t, stubProvider{}, 10)
+	defer func(backoff time.Duration) { retryMaxBackoff = backoff }(retryMaxBackoff)
+	retryMaxBackoff = time.Millisecond
+	embedTimeout = 20 * time.Millisecond
+
+	tests := []struct {
+		name        string
+		timeout     time.Duration // of the overall context, done up front when zero
+		maxRequests int
+	}{
+		{name: "already canceled", maxRequests: 0},
+		// Hung attempts take 20ms each, so at most four start in 70ms
+		{name: "deadline during retries", timeout: 70 * time.Millisecond, maxRequests: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			if tt.timeout == 0 {
+				cancel()
+			}
+			provider := &hangingProvider{hangs: 1000}
+			start := time.Now()
+			_, err := generateEmbeddingsWithRetry(ctx, provider, []string{"casa"}, 1000, time.Millisecond)
+			if err == nil || ctx.Err() == nil || !errors.Is(err, ctx.Err()) {
+				t.Errorf("error = %v, want the overall context's %v", err, ctx.Err())
+			}
+			if provider.requests > tt.maxRequests {
+				t.Errorf("sent %d requests, want at most %d", provider.requests, tt.maxRequests)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("took %s, want to stop at the overall deadline", elapsed)
+			}
+		})
+	}
+}
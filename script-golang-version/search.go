@@ -0,0 +1,326 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SearchResult is a stored property with its similarity to the query
+type SearchResult struct {
+	Property Property `json:"property"`
+	Score    float64  `json:"score"`
+}
+
+// Search the target collection by loading embeddings into memory and ranking
+// them by cosine similarity. Meant for development against MongoDB servers
+// without Atlas Vector Search; at most maxDocuments are loaded. Results are
+// always restricted to the given tenant. With field weights, documents are
+// ranked by their weighted per-field similarity instead.
+func localSearch(
+	ctx context.Context,
+	targetDB propertyCollection,
+	query string,
+	tenant string,
+	k int,
+	maxDocuments int64,
+	fieldWeights map[string]float64,
+) ([]SearchResult, error) {
+	filter := tenantFilter(tenant)
+	total, err := targetDB.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("error counting embeddings: %w", err)
+	}
+	if total > maxDocuments {
+		slog.Warn("Target has more documents for this tenant than are searched", "total_count", total, "searched_count", maxDocuments)
+	}
+
+	queryEmbedding, err := generateEmbedding(withQueryEmbedding(ctx), query)
+	if err != nil {
+		return nil, fmt.Errorf("error embedding query: %w", err)
+	}
+
+	cursor, err := targetDB.Find(ctx, filter, options.Find().
+		SetProjection(bson.M{metadataField: 1, vectorField: 1, "field_embeddings": 1}).
+		SetLimit(maxDocuments))
+	if err != nil {
+		return nil, fmt.Errorf("error loading embeddings: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	top := newTopResults(k)
+	for cursor.Next(ctx) {
+		var doc PropertyWithEmbedding
+		if err := decodeStoredDocument(cursor.Current, &doc); err != nil {
+			return nil, fmt.Errorf("error decoding embedding document: %w", err)
+		}
+		if err := checkQueryDimensions(queryEmbedding, &doc); err != nil {
+			return nil, err
+		}
+		var score float64
+		if len(fieldWeights) > 0 {
+			var ok bool
+			score, ok = weightedSimilarity(queryEmbedding, doc.Embeddings, doc.FieldEmbeddings, fieldWeights)
+			if !ok {
+				continue
+			}
+		} else {
+			score = cosineSimilarity(queryEmbedding, doc.Embeddings)
+		}
+		top.add(SearchResult{Property: doc.Metadata, Score: score})
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("cursor error: %w", err)
+	}
+	return top.sorted(), nil
+}
+
+// Search the target collection by streaming every stored embedding matching
+// the tenant and an optional pre-filter, keeping only the best k in memory.
+// Works on any MongoDB server, at the cost of a full scan of the matches.
+func bruteForceSearch(ctx context.Context, targetDB propertyCollection, query string, tenant string, k int, preFilter bson.M) ([]SearchResult, error) {
+	queryEmbedding, err := generateEmbedding(withQueryEmbedding(ctx), query)
+	if err != nil {
+		return nil, fmt.Errorf("error embedding query: %w", err)
+	}
+
+	filter := tenantFilter(tenant)
+	if len(preFilter) > 0 {
+		filter = bson.M{"$and": bson.A{filter, preFilter}}
+	}
+	cursor, err := targetDB.Find(ctx, filter, options.Find().
+		SetProjection(bson.M{metadataField: 1, vectorField: 1}).
+		SetBatchSize(500))
+	if err != nil {
+		return nil, fmt.Errorf("error loading embeddings: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	top := newTopResults(k)
+	for cursor.Next(ctx) {
+		var doc PropertyWithEmbedding
+		if err := decodeStoredDocument(cursor.Current, &doc); err != nil {
+			return nil, fmt.Errorf("error decoding embedding document: %w", err)
+		}
+		if err := checkQueryDimensions(queryEmbedding, &doc); err != nil {
+			return nil, err
+		}
+		top.add(SearchResult{Property: doc.Metadata, Score: cosineSimilarity(queryEmbedding, doc.Embeddings)})
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("cursor error: %w", err)
+	}
+	return top.sorted(), nil
+}
+
+// Check that a stored document's vectors have the query embedding's
+// dimension. A mismatch means the query was embedded with another model than
+// the collection, so no stored vector can be scored against it.
+func checkQueryDimensions(query []float32, doc *PropertyWithEmbedding) error {
+	if len(doc.Embeddings) != len(query) {
+		return fmt.Errorf("%w: query embedding has %d dimensions, property %s is stored with %d",
+			errDimensionMismatch, len(query), doc.Metadata.ID.Hex(), len(doc.Embeddings))
+	}
+	for name, embedding := range doc.FieldEmbeddings {
+		if len(embedding) > 0 && len(embedding) != len(query) {
+			return fmt.Errorf("%w: query embedding has %d dimensions, field %s of property %s is stored with %d",
+				errDimensionMismatch, len(query), name, doc.Metadata.ID.Hex(), len(embedding))
+		}
+	}
+	return nil
+}
+
+// Check that the query embedding has the dimension VECTOR_INDEX is configured
+// for, since Atlas rejects a mismatch with an error that names neither. An
+// index that doesn't exist yet passes, leaving Atlas to report it.
+func checkIndexDimensions(ctx context.Context, targetDB propertyCollection, query []float32) error {
+	dimensions, err := targetDB.VectorIndexDimensions(ctx, vectorIndexName)
+	if err != nil {
+		return fmt.Errorf("error reading vector search index %q: %w", vectorIndexName, err)
+	}
+	if dimensions > 0 && dimensions != len(query) {
+		return fmt.Errorf("%w: query embedding has %d dimensions, vector search index %q is configured for %d",
+			errDimensionMismatch, len(query), vectorIndexName, dimensions)
+	}
+	return nil
+}
+
+// Parse an Extended JSON query document given to -search-filter or -filter (none when empty)
+func parseQueryFilter(value string) (bson.M, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var filter bson.M
+	if err := bson.UnmarshalExtJSON([]byte(value), false, &filter); err != nil {
+		return nil, fmt.Errorf("filter must be a JSON query document: %w", err)
+	}
+	return filter, nil
+}
+
+// topResults keeps the k best-scoring results seen so far in a min-heap
+type topResults struct {
+	k       int
+	results []SearchResult
+}
+
+func newTopResults(k int) *topResults {
+	return &topResults{k: k}
+}
+
+func (t *topResults) Len() int           { return len(t.results) }
+func (t *topResults) Less(i, j int) bool { return t.results[i].Score < t.results[j].Score }
+func (t *topResults) Swap(i, j int)      { t.results[i], t.results[j] = t.results[j], t.results[i] }
+func (t *topResults) Push(x interface{}) { t.results = append(t.results, x.(SearchResult)) }
+func (t *topResults) Pop() interface{} {
+	last := t.results[len(t.results)-1]
+	t.results = t.results[:len(t.results)-1]
+	return last
+}
+
+// Offer a result, dropping the worst one once more than k are kept
+func (t *topResults) add(result SearchResult) {
+	if len(t.results) < t.k {
+		heap.Push(t, result)
+	} else if result.Score > t.results[0].Score {
+		t.results[0] = result
+		heap.Fix(t, 0)
+	}
+}
+
+// The kept results, best first
+func (t *topResults) sorted() []SearchResult {
+	results := append([]SearchResult(nil), t.results...)
+	sort.Slice(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	return results
+}
+
+// Candidates considered by $vectorSearch per requested result; more
+// candidates trade latency for recall
+const vectorSearchCandidatesPerResult = 20
+
+// Search the target collection with an Atlas $vectorSearch on VECTOR_INDEX.
+// Tenant searches use the index's tenant_id filter field; untenanted searches
+// drop tagged documents after the search, so they may return fewer than k.
+func vectorSearch(ctx context.Context, targetDB propertyCollection, query string, tenant string, k int) ([]SearchResult, error) {
+	var results []SearchResult
+	err := streamVectorSearch(ctx, targetDB, query, tenant, k, func(result SearchResult) error {
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Run vectorSearch, passing each result to emit, best first, as soon as the
+// server returns it
+func streamVectorSearch(ctx context.Context, targetDB propertyCollection, query string, tenant string, k int, emit func(SearchResult) error) error {
+	queryEmbedding, err := generateEmbedding(withQueryEmbedding(ctx), query)
+	if err != nil {
+		return fmt.Errorf("error embedding query: %w", err)
+	}
+	if searchDimensionCheck {
+		if err := checkIndexDimensions(ctx, targetDB, queryEmbedding); err != nil {
+			return err
+		}
+	}
+
+	search := bson.M{
+		"index":         vectorIndexName,
+		"path":          vectorField,
+		"queryVector":   queryEmbedding,
+		"numCandidates": min(k*vectorSearchCandidatesPerResult, 10000),
+		"limit":         k,
+	}
+	pipeline := mongo.Pipeline{}
+	if tenant != "" {
+		search["filter"] = bson.M{"tenant_id": bson.M{"$eq": tenant}}
+		pipeline = append(pipeline, bson.D{{Key: "$vectorSearch", Value: search}})
+	} else {
+		pipeline = append(pipeline,
+			bson.D{{Key: "$vectorSearch", Value: search}},
+			bson.D{{Key: "$match", Value: tenantFilter("")}})
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$project", Value: bson.M{
+		metadataField: 1,
+		"score":       bson.M{"$meta": "vectorSearchScore"},
+	}}})
+
+	cursor, err := targetDB.Aggregate(ctx, pipeline)
+	if err != nil {
+		return fmt.Errorf("error running $vectorSearch on index %q: %w", vectorIndexName, err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc PropertyWithEmbedding
+		if err := decodeStoredDocument(cursor.Current, &doc); err != nil {
+			return fmt.Errorf("error decoding search result: %w", err)
+		}
+		score, _ := cursor.Current.Lookup("score").DoubleOK()
+		if err := emit(SearchResult{Property: doc.Metadata, Score: score}); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("cursor error: %w", err)
+	}
+	return nil
+}
+
+// Cosine similarity between two vectors of the same dimension, 0 for zero vectors
+func cosineSimilarity(a, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// Print search results as an indented JSON array
+func printSearchResultsJSON(w io.Writer, results []SearchResult) error {
+	if results == nil {
+		results = []SearchResult{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(results)
+}
+
+// Print search results as a table
+func printSearchResults(results []SearchResult) {
+	fmt.Printf("%-4s %-8s %-24s %-40s %s\n", "#", "SCORE", "ID", "TITLE", "LOCATION")
+	for i, result := range results {
+		var title string
+		if result.Property.Ad != nil {
+			title = result.Property.Ad.Title
+		}
+		fmt.Printf("%-4d %-8.4f %-24s %-40s %s, %s\n", i+1, result.Score, result.Property.ID.Hex(),
+			truncateTitle(title, 40), result.Property.City, result.Property.State)
+	}
+}
+
+// Shorten a title to at most width characters, ending with "..." when cut.
+// Cuts between runes so accented titles stay valid UTF-8.
+func truncateTitle(title string, width int) string {
+	runes := []rune(title)
+	if len(runes) <= width {
+		return title
+	}
+	return string(runes[:width-3]) + "..."
+}
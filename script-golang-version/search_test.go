@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestCheckQueryDimensions(t *testing.T) {
+	query := []float32{1, 0, 0}
+	tests := []struct {
+		name    string
+		doc     PropertyWithEmbedding
+		wantErr string // substring of the error, empty for none
+	}{
+		{
+			name: "matching dimensions",
+			doc:  PropertyWithEmbedding{Embeddings: []float32{0, 1, 0}},
+		},
+		{
+			name: "empty field embeddings are ignored",
+			doc: PropertyWithEmbedding{
+				Embeddings:      []float32{0, 1, 0},
+				FieldEmbeddings: map[string][]float32{"title": nil},
+			},
+		},
+		{
+			name:    "stored vector of another model",
+			doc:     PropertyWithEmbedding{Embeddings: []float32{0, 1}},
+			wantErr: "query embedding has 3 dimensions, property 000000000000000000000000 is stored with 2",
+		},
+		{
+			name: "field vector of another model",
+			doc: PropertyWithEmbedding{
+				Embeddings:      []float32{0, 1, 0},
+				FieldEmbeddings: map[string][]float32{"title": {1, 0, 0, 0}},
+			},
+			wantErr: "field title of property 000000000000000000000000 is stored with 4",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkQueryDimensions(query, &tt.doc)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, errDimensionMismatch) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want errDimensionMismatch containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTruncateTitle(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{title: "", want: ""},
+		{title: "Apartamento", want: "Apartamento"},
+		{title: "Casa térrea", want: "Casa térrea"},
+		{title: "Apartamento amplo", want: "Apartame..."},
+		{title: "Ótimo imóvel", want: "Ótimo im..."},
+		{title: "Árvores à beira-mar", want: "Árvores ..."},
+	}
+	for _, tt := range tests {
+		got := truncateTitle(tt.title, 11)
+		if got != tt.want || !utf8.ValidString(got) {
+			t.Errorf("truncateTitle(%q, 11) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+// A query embedding of another dimension than the vector search index fails
+// before the aggregation runs, unless the check is disabled
+func TestStreamVectorSearchChecksIndexDimensions(t *testing.T) {
+	setupWorkerTest(t, stubProvider{}, 10)
+	defer func(check bool, index string) {
+		searchDimensionCheck, vectorIndexName = check, index
+	}(searchDimensionCheck, vectorIndexName)
+	vectorIndexName = "vector_index"
+
+	tests := []struct {
+		name       string
+		dimensions int
+		check      bool
+		wantErr    string // substring of the error, empty for none
+	}{
+		{name: "matching index", dimensions: 3, check: true},
+		{name: "index without the vector field", dimensions: 0, check: true},
+		{
+			name:       "index of another model",
+			dimensions: 768,
+			check:      true,
+			wantErr:    `query embedding has 3 dimensions, vector search index "vector_index" is configured for 768`,
+		},
+		{name: "check disabled", dimensions: 768, check: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			searchDimensionCheck = tt.check
+			target := &pipelineCollection{memoryCollection: &memoryCollection{indexDimensions: tt.dimensions}}
+			err := streamVectorSearch(context.Background(), target, "flat", "", 5, func(SearchResult) error { return nil })
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if target.pipeline == nil {
+					t.Error("the $vectorSearch aggregation didn't run")
+				}
+				return
+			}
+			if !errors.Is(err, errDimensionMismatch) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want errDimensionMismatch containing %q", err, tt.wantErr)
+			}
+			if target.pipeline != nil {
+				t.Error("the $vectorSearch aggregation ran despite the mismatch")
+			}
+		})
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Serve on-demand embedding and search on this port instead of running a batch (disabled when 0)
+var servePort int
+
+// Largest request body the server reads
+const maxServeRequestBytes = 1 << 20
+
+// How long shutdown waits for in-flight requests to finish
+const serveShutdownTimeout = 30 * time.Second
+
+// embedResponse is the body returned by POST /embed
+type embedResponse struct {
+	Text       string    `json:"text"`
+	Model      string    `json:"model"`
+	Dimensions int       `json:"dimensions"`
+	Embedding  []float32 `json:"embedding"`
+}
+
+// searchRequest is the body accepted by POST /search
+type searchRequest struct {
+	Query  string `json:"query"`
+	K      int    `json:"k,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// embeddingServer embeds properties and texts and searches the target on request
+type embeddingServer struct {
+	target propertyCollection
+}
+
+// Serve POST /embed and POST /search until interrupted, then let in-flight
+// requests finish before returning
+func runEmbeddingServer(ctx context.Context, port int, client *mongo.Client) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	s := &embeddingServer{target: mongoStore{client}.Target()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/embed", s.handleEmbed)
+	mux.HandleFunc("/search", s.handleSearch)
+	server := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: mux}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+	slog.Info("Serving embeddings (POST /embed, POST /search)", "port", port)
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+	slog.Info("Shutting down, waiting for in-flight requests")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Embed a Property sent as JSON, described like in a batch run, or raw text.
+// Raw text is embedded as a search query with ?type=query.
+func (s *embeddingServer) handleEmbed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxServeRequestBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	ctx := r.Context()
+	var text string
+	if isJSONRequest(r) {
+		var property Property
+		if err := json.Unmarshal(body, &property); err != nil {
+			http.Error(w, "invalid property: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx = withPropertyID(ctx, property.ID)
+		text = embeddingText(&property)
+	} else {
+		text = strings.TrimSpace(string(body))
+	}
+	if text == "" {
+		http.Error(w, "nothing to embed", http.StatusBadRequest)
+		return
+	}
+
+	// Query embeddings use another task type, so they bypass the cache
+	var embedding []float32
+	if r.URL.Query().Get("type") == "query" {
+		embedding, err = generateEmbedding(withQueryEmbedding(ctx), text)
+	} else {
+		embedding, err = embedDocumentText(ctx, text)
+	}
+	if err != nil {
+		slog.Error("Error embedding request", "error", err)
+		http.Error(w, "embedding failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, embedResponse{
+		Text:       text,
+		Model:      embeddingModelLabel(),
+		Dimensions: len(embedding),
+		Embedding:  embedding,
+	})
+}
+
+// Embed a document text like a batch run does, splitting it by
+// LONG_TEXT_STRATEGY and going through the description cache
+func embedDocumentText(ctx context.Context, text string) ([]float32, error) {
+	inputs, _ := splitLongText(text)
+	embeddings, err := generateEmbeddingsBatch(ctx, inputs)
+	if err != nil {
+		return nil, err
+	}
+	return averageEmbeddings(embeddings), nil
+}
+
+// Return the top-K stored properties for a text query, as one JSON array or,
+// when the client asks for a stream, as server-sent events
+func (s *embeddingServer) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	var request searchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxServeRequestBytes)).Decode(&request); err != nil {
+		http.Error(w, "invalid search request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(request.Query) == "" {
+		http.Error(w, "query is required", http.StatusBadRequest)
+		return
+	}
+	if request.K <= 0 {
+		request.K = searchK
+	}
+	tenant, err := resolveSearchTenant(request.Tenant)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	request.Tenant = tenant
+
+	if wantsSearchStream(r) {
+		s.streamSearch(r.Context(), w, request)
+		return
+	}
+	var results []SearchResult
+	err = s.search(r.Context(), request, func(result SearchResult) error {
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		slog.Error("Error searching", "error", err)
+		http.Error(w, "search failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := printSearchResultsJSON(w, results); err != nil {
+		slog.Error("Error writing search results", "error", err)
+	}
+}
+
+// Run the configured search, passing each result to emit once its rank is
+// final. $vectorSearch returns results in rank order, so they're passed on as
+// they arrive; brute-force ranking needs the whole scan first.
+func (s *embeddingServer) search(ctx context.Context, request searchRequest, emit func(SearchResult) error) error {
+	if searchMode != searchModeBrute {
+		return streamVectorSearch(ctx, s.target, request.Query, request.Tenant, request.K, emit)
+	}
+	results, err := bruteForceSearch(ctx, s.target, request.Query, request.Tenant, request.K, searchFilter)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		if err := emit(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check whether the client asked for search results as server-sent events,
+// with Accept: text/event-stream or ?stream=true
+func wantsSearchStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream") || r.URL.Query().Get("stream") == "true"
+}
+
+// Stream a search as server-sent events: a "result" event per result, best
+// first, then "done" with the number of results. A search failing before the
+// first result gets a plain error response; one failing later ends the stream
+// with an "error" event.
+func (s *embeddingServer) streamSearch(ctx context.Context, w http.ResponseWriter, request searchRequest) {
+	stream := &searchStream{w: w}
+	err := s.search(ctx, request, stream.result)
+	switch {
+	case err != nil && !stream.started:
+		slog.Error("Error searching", "error", err)
+		http.Error(w, "search failed: "+err.Error(), http.StatusBadGateway)
+		return
+	case err != nil:
+		slog.Error("Error searching", "error", err)
+		err = stream.event("error", map[string]string{"error": "search failed: " + err.Error()})
+	default:
+		err = stream.event("done", map[string]int{"count": stream.count})
+	}
+	if err != nil {
+		slog.Error("Error writing search results", "error", err)
+	}
+}
+
+// searchStream writes server-sent events, sending the headers with the first one
+type searchStream struct {
+	w       http.ResponseWriter
+	started bool
+	count   int // results sent
+}
+
+// Send a search result
+func (s *searchStream) result(result SearchResult) error {
+	s.count++
+	return s.event("result", result)
+}
+
+// Send an event with a JSON payload, flushing it to the client right away
+func (s *searchStream) event(name string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if !s.started {
+		s.w.Header().Set("Content-Type", "text/event-stream")
+		s.w.Header().Set("Cache-Control", "no-cache")
+		s.started = true
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+		return err
+	}
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// Pick the tenant a search request may read. With TENANT_ID set the server
+// only ever searches that tenant, so callers can't read another tenant's
+// properties; otherwise the request's tenant wins over -tenant.
+func resolveSearchTenant(requested string) (string, error) {
+	switch {
+	case tenantID != "" && requested != "" && requested != tenantID:
+		return "", fmt.Errorf("tenant %q is not allowed, this server only searches TENANT_ID", requested)
+	case tenantID != "":
+		return tenantID, nil
+	case requested != "":
+		return requested, nil
+	}
+	return searchTenant, nil
+}
+
+// Check whether the request body is JSON
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// Write a value as a JSON response
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		slog.Error("Error writing response", "error", err)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResolveSearchTenant(t *testing.T) {
+	defer func(tenant, search string) { tenantID, searchTenant = tenant, search }(tenantID, searchTenant)
+
+	tests := []struct {
+		name      string
+		tenantID  string
+		search    string
+		requested string
+		want      string
+		wantErr   bool
+	}{
+		{name: "no tenant anywhere", want: ""},
+		{name: "request tenant without TENANT_ID", requested: "b", want: "b"},
+		{name: "request tenant wins over -tenant", search: "a", requested: "b", want: "b"},
+		{name: "-tenant as default", search: "a", want: "a"},
+		{name: "TENANT_ID when the request has none", tenantID: "a", want: "a"},
+		{name: "TENANT_ID matching the request", tenantID: "a", requested: "a", want: "a"},
+		{name: "TENANT_ID wins over -tenant", tenantID: "a", search: "b", want: "a"},
+		{name: "other tenant rejected", tenantID: "a", requested: "b", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tenantID, searchTenant = tt.tenantID, tt.search
+			got, err := resolveSearchTenant(tt.requested)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("tenant = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleSearchRejectsOtherTenant(t *testing.T) {
+	defer func(tenant string) { tenantID = tenant }(tenantID)
+	tenantID = "tenant-a"
+
+	s := &embeddingServer{}
+	request := httptest.NewRequest(http.MethodPost, "/search", strings.NewReader(`{"query": "casa", "tenant": "tenant-b"}`))
+	recorder := httptest.NewRecorder()
+	s.handleSearch(recorder, request)
+
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d (body %q)", recorder.Code, http.StatusForbidden, recorder.Body.String())
+	}
+}
+
+func TestSearchStreamEvents(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	stream := &searchStream{w: recorder}
+	for _, score := range []float64{0.9, 0.5} {
+		if err := stream.result(SearchResult{Score: score}); err != nil {
+			t.Fatalf("result: %v", err)
+		}
+	}
+	if err := stream.event("done", map[string]int{"count": stream.count}); err != nil {
+		t.Fatalf("done: %v", err)
+	}
+
+	if got := recorder.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", got)
+	}
+	events := strings.Split(strings.TrimSuffix(recorder.Body.String(), "\n\n"), "\n\n")
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3: %q", len(events), recorder.Body.String())
+	}
+	if !strings.HasPrefix(events[0], "event: result\ndata: {") || !strings.Contains(events[0], `"score":0.9`) {
+		t.Errorf("first event = %q, want the 0.9 result", events[0])
+	}
+	if !strings.Contains(events[1], `"score":0.5`) {
+		t.Errorf("second event = %q, want the 0.5 result", events[1])
+	}
+	if events[2] != "event: done\ndata: {\"count\":2}" {
+		t.Errorf("last event = %q, want done with count 2", events[2])
+	}
+	if !recorder.Flushed {
+		t.Error("events weren't flushed")
+	}
+}
+
+func TestWantsSearchStream(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		accept string
+		want   bool
+	}{
+		{name: "plain request", target: "/search", want: false},
+		{name: "JSON accept", target: "/search", accept: "application/json", want: false},
+		{name: "event stream accept", target: "/search", accept: "text/event-stream", want: true},
+		{name: "stream parameter", target: "/search?stream=true", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, tt.target, nil)
+			if tt.accept != "" {
+				request.Header.Set("Accept", tt.accept)
+			}
+			if got := wantsSearchStream(request); got != tt.want {
+				t.Errorf("wantsSearchStream = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+// A search failing before its first result still gets an HTTP error status
+func TestHandleSearchStreamFailsBeforeResults(t *testing.T) {
+	setupWorkerTest(t, stubProvider{fail: "casa", err: errors.New("invalid argument")}, 10)
+
+	s := &embeddingServer{}
+	request := httptest.NewRequest(http.MethodPost, "/search?stream=true", strings.NewReader(`{"query": "casa"}`))
+	recorder := httptest.NewRecorder()
+	s.handleSearch(recorder, request)
+
+	if recorder.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want %d (body %q)", recorder.Code, http.StatusBadGateway, recorder.Body.String())
+	}
+	if strings.Contains(recorder.Body.String(), "event:") {
+		t.Errorf("body = %q, want a plain error", recorder.Body.String())
+	}
+}
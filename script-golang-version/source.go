@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Query from -filter selecting the subset of the source to process (all when empty)
+var sourceQuery bson.M
+
+// Build the query used to select properties from the source collection. The
+// -filter query is wrapped in $and so the resume, incremental and partition
+// conditions added here can't overwrite its own _id or updatedAt conditions.
+func sourceFilter() bson.M {
+	filter := bson.M{}
+	if len(sourceQuery) > 0 {
+		filter["$and"] = bson.A{sourceQuery}
+	}
+	if !resumeFromID.IsZero() {
+		filter["_id"] = bson.M{"$gt": resumeFromID}
+	}
+	if !updatedSince.IsZero() {
+		filter["updatedAt"] = bson.M{"$gt": updatedSince}
+	}
+	// Merging assigns shards by business key, which the server can't hash
+	if shard := shardFilter(); shard != nil && mergeKey == "" {
+		filter["$expr"] = shard["$expr"]
+	}
+	return filter
+}
+
+// Describe the source query as Extended JSON for logs
+func formatSourceFilter() string {
+	data, err := bson.MarshalExtJSON(sourceFilter(), false, false)
+	if err != nil {
+		return fmt.Sprint(sourceFilter())
+	}
+	return string(data)
+}
+
+// Build the sort applied to the source scan, if any. Resuming from an _id or
+// a checkpoint needs a stable _id ordering, and merging needs documents
+// grouped by key.
+func sourceSort() bson.D {
+	if mergeKey != "" {
+		return bson.D{{Key: mergeKey, Value: 1}, {Key: "_id", Value: 1}}
+	}
+	if len(prioritySort) > 0 {
+		// Break ties on _id for a deterministic order
+		return append(append(bson.D{}, prioritySort...), bson.E{Key: "_id", Value: 1})
+	}
+	if !resumeFromID.IsZero() || checkpointFile != "" {
+		return bson.D{{Key: "_id", Value: 1}}
+	}
+	return nil
+}
+
+// Parse a priority sort such as "isExclusive desc, updatedAt desc"; fields
+// sort ascending unless followed by "desc"
+func parsePrioritySort(value string) (bson.D, error) {
+	var sort bson.D
+	for _, entry := range splitList(value) {
+		parts := strings.Fields(entry)
+		if len(parts) > 2 {
+			return nil, fmt.Errorf("invalid sort field %q", entry)
+		}
+		if err := validateFieldPath(parts[0]); err != nil {
+			return nil, fmt.Errorf("sort field %w", err)
+		}
+		direction := 1
+		if len(parts) == 2 {
+			switch strings.ToLower(parts[1]) {
+			case "asc":
+			case "desc":
+				direction = -1
+			default:
+				return nil, fmt.Errorf("invalid sort direction in %q: must be asc or desc", entry)
+			}
+		}
+		sort = append(sort, bson.E{Key: parts[0], Value: direction})
+	}
+	return sort, nil
+}
+
+// Build the find options used to scan the source collection
+func sourceFindOptions() *options.FindOptions {
+	findOptions := options.Find()
+	if sort := sourceSort(); sort != nil {
+		findOptions.SetSort(sort)
+	}
+	return findOptions
+}
+
+// Build the aggregation used when a source pipeline is configured: the source
+// filter is applied first, then the configured stages, then the scan ordering.
+func sourceAggregation(filter bson.M, projection bson.M) []bson.D {
+	stages := []bson.D{{{Key: "$match", Value: filter}}}
+	stages = append(stages, sourcePipeline...)
+	if sort := sourceSort(); sort != nil {
+		stages = append(stages, bson.D{{Key: "$sort", Value: sort}})
+	}
+	if projection != nil {
+		stages = append(stages, bson.D{{Key: "$project", Value: projection}})
+	}
+	return stages
+}
+
+// Open a cursor over the selected source properties, optionally projected
+func openSourceCursor(ctx context.Context, collection propertyCollection, projection bson.M) (*mongo.Cursor, error) {
+	return openSourceRangeCursor(ctx, collection, idRange{}, projection)
+}
+
+// Open a cursor over the selected source properties within an _id range
+func openSourceRangeCursor(ctx context.Context, collection propertyCollection, r idRange, projection bson.M) (*mongo.Cursor, error) {
+	filter := r.apply(sourceFilter())
+	if len(sourcePipeline) > 0 {
+		return collection.Aggregate(ctx, sourceAggregation(filter, projection))
+	}
+
+	findOptions := sourceFindOptions()
+	if projection != nil {
+		findOptions.SetProjection(projection)
+	}
+	return collection.Find(ctx, filter, findOptions)
+}
+
+// Decode the cursor's current property, reading EMBED_SOURCE_FIELD into EmbedText
+func decodeSourceProperty(cursor *mongo.Cursor, property *Property) error {
+	if err := cursor.Decode(property); err != nil {
+		return err
+	}
+	if embedSourceField != "" {
+		value, err := cursor.Current.LookupErr(strings.Split(embedSourceField, ".")...)
+		if err == nil {
+			property.EmbedText, _ = value.StringValueOK()
+		}
+	}
+	return nil
+}
+
+// Count the selected source properties of this process's external shard
+func countSourceProperties(ctx context.Context, collection propertyCollection) (int64, error) {
+	if totalShards > 1 && mergeKey != "" {
+		return countShardProperties(ctx, collection)
+	}
+	if len(sourcePipeline) == 0 {
+		return collection.CountDocuments(ctx, sourceFilter())
+	}
+
+	stages := append(sourceAggregation(sourceFilter(), nil), bson.D{{Key: "$count", Value: "count"}})
+	cursor, err := collection.Aggregate(ctx, stages)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var result struct {
+		Count int64 `bson:"count"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&result); err != nil {
+			return 0, err
+		}
+	}
+	return result.Count, cursor.Err()
+}
+
+// Count the selected source properties in this shard when merging. Merging
+// assigns shards by a hash of the business key, which the server can't
+// compute, so the keys are read and hashed like the workers do.
+func countShardProperties(ctx context.Context, collection propertyCollection) (int64, error) {
+	cursor, err := openSourceCursor(ctx, collection, bson.M{"_id": 1, mergeKey: 1})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var count int64
+	for cursor.Next(ctx) {
+		var property Property
+		if err := cursor.Decode(&property); err != nil {
+			return 0, err
+		}
+		if keyBelongsToShard(mergePartitionKey(&property)) {
+			count++
+		}
+	}
+	return count, cursor.Err()
+}
+
+// Parse the source aggregation pipeline from an inline JSON array or a file
+// containing one. Stages use MongoDB Extended JSON.
+func loadSourcePipeline(inline, path string) ([]bson.D, error) {
+	if inline != "" && path != "" {
+		return nil, fmt.Errorf("set only one of SOURCE_PIPELINE and SOURCE_PIPELINE_FILE")
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", path, err)
+		}
+		inline = string(data)
+	}
+	if inline == "" {
+		return nil, nil
+	}
+
+	// Extended JSON must be a document at the top level, so wrap the array
+	var wrapper struct {
+		Pipeline []bson.D `bson:"pipeline"`
+	}
+	if err := bson.UnmarshalExtJSON([]byte(`{"pipeline": `+inline+`}`), false, &wrapper); err != nil {
+		return nil, fmt.Errorf("pipeline must be a JSON array of stages: %w", err)
+	}
+	return wrapper.Pipeline, nil
+}
+
+// Semaphore limiting concurrently open source cursors, nil when unlimited
+var cursorSlots chan struct{}
+
+// Take a cursor slot, logging when the worker has to wait for one
+func acquireCursorSlot(ctx context.Context, workerID int) error {
+	if cursorSlots == nil {
+		return nil
+	}
+
+	select {
+	case cursorSlots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	slog.Debug("Waiting for a source cursor slot", "worker_id", workerID, "open_cursors", cap(cursorSlots))
+	select {
+	case cursorSlots <- struct{}{}:
+		slog.Debug("Acquired a source cursor slot", "worker_id", workerID)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Give back a cursor slot
+func releaseCursorSlot() {
+	if cursorSlots != nil {
+		<-cursorSlots
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestDecodeSourcePropertyReadsEmbedSourceField(t *testing.T) {
+	defer func(field string) { embedSourceField = field }(embedSourceField)
+	document := bson.M{
+		"city":    "Curitiba",
+		"summary": "Top-level summary",
+		"ai":      bson.M{"text": "Nested summary", "score": 3},
+	}
+
+	tests := []struct {
+		field string
+		want  string
+	}{
+		{field: "", want: ""},
+		{field: "summary", want: "Top-level summary"},
+		{field: "ai.text", want: "Nested summary"},
+		{field: "ai.score", want: ""},
+		{field: "missing", want: ""},
+		{field: "ai.missing", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			embedSourceField = tt.field
+			cursor, err := mongo.NewCursorFromDocuments([]interface{}{document}, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cursor.Next(context.Background()) {
+				t.Fatal("empty cursor")
+			}
+			var property Property
+			if err := decodeSourceProperty(cursor, &property); err != nil {
+				t.Fatalf("decodeSourceProperty: %v", err)
+			}
+			if property.EmbedText != tt.want || property.City != "Curitiba" {
+				t.Errorf("decoded EmbedText %q and city %q, want %q and Curitiba", property.EmbedText, property.City, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSourcePipeline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pipeline.json")
+	if err := os.WriteFile(path, []byte(`[{"$match": {"active": true}}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	lookup := bson.D{{Key: "$lookup", Value: bson.D{
+		{Key: "from", Value: "companies"},
+		{Key: "localField", Value: "companyId"},
+		{Key: "foreignField", Value: "_id"},
+		{Key: "as", Value: "company"},
+	}}}
+
+	tests := []struct {
+		name    string
+		inline  string
+		path    string
+		want    []bson.D
+		wantErr bool
+	}{
+		{name: "unset", want: nil},
+		{name: "inline", inline: `[{"$lookup": {"from": "companies", "localField": "companyId", "foreignField": "_id", "as": "company"}}, {"$unwind": "$company"}]`,
+			want: []bson.D{lookup, {{Key: "$unwind", Value: "$company"}}}},
+		{name: "file", path: path, want: []bson.D{{{Key: "$match", Value: bson.D{{Key: "active", Value: true}}}}}},
+		{name: "both", inline: `[]`, path: path, wantErr: true},
+		{name: "missing file", path: filepath.Join(t.TempDir(), "missing.json"), wantErr: true},
+		{name: "not an array", inline: `{"$match": {}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadSourcePipeline(tt.inline, tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadSourcePipeline error = %v, want error %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadSourcePipeline = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// The source filter runs before the configured stages, and the scan order and projection after them
+func TestSourceAggregation(t *testing.T) {
+	defer func(pipeline []bson.D, resume primitive.ObjectID) {
+		sourcePipeline, resumeFromID = pipeline, resume
+	}(sourcePipeline, resumeFromID)
+	unwind := bson.D{{Key: "$unwind", Value: "$company"}}
+	sourcePipeline = []bson.D{unwind}
+	filter := bson.M{"city": "Curitiba"}
+	projection := bson.M{"_id": 1}
+
+	tests := []struct {
+		name       string
+		resume     primitive.ObjectID
+		projection bson.M
+		want       []bson.D
+	}{
+		{name: "unsorted", want: []bson.D{{{Key: "$match", Value: filter}}, unwind}},
+		{name: "resumed scan is sorted", resume: primitive.NewObjectID(), projection: projection, want: []bson.D{
+			{{Key: "$match", Value: filter}},
+			unwind,
+			{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+			{{Key: "$project", Value: projection}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resumeFromID = tt.resume
+			if got := sourceAggregation(filter, tt.projection); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sourceAggregation = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// pipelineCollection records the pipeline of its Aggregate calls
+type pipelineCollection struct {
+	*memoryCollection
+	pipeline interface{}
+}
+
+func (c *pipelineCollection) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	c.pipeline = pipeline
+	return mongo.NewCursorFromDocuments(nil, nil, nil)
+}
+
+// With a source pipeline a worker's _id range is scanned with an aggregation
+// rather than a find
+func TestOpenSourceRangeCursorUsesThePipeline(t *testing.T) {
+	defer func(pipeline []bson.D) { sourcePipeline = pipeline }(sourcePipeline)
+	sourcePipeline = []bson.D{{{Key: "$unwind", Value: "$company"}}}
+	r := idRange{from: primitive.NewObjectID(), to: primitive.NewObjectID()}
+	collection := &pipelineCollection{memoryCollection: &memoryCollection{}}
+
+	cursor, err := openSourceRangeCursor(context.Background(), collection, r, nil)
+	if err != nil {
+		t.Fatalf("openSourceRangeCursor: %v", err)
+	}
+	cursor.Close(context.Background())
+
+	want := sourceAggregation(r.apply(sourceFilter()), nil)
+	if !reflect.DeepEqual(collection.pipeline, want) {
+		t.Errorf("aggregated %v, want %v", collection.pipeline, want)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Profile the source collection and exit
+var profileSource bool
+
+// Fields whose fill rate -stats reports, in order
+var profiledFields = []struct {
+	name   string
+	filled func(*Property) bool
+}{
+	{"title", func(p *Property) bool { return p.Ad != nil && p.Ad.Title != "" }},
+	{"description", func(p *Property) bool { return p.Ad != nil && p.Ad.Description != "" }},
+	{"city", func(p *Property) bool { return p.City != "" }},
+	{"propertyType", func(p *Property) bool { return p.PropertyType != "" }},
+	{"transactionType", func(p *Property) bool { return p.Ad != nil && p.Ad.TransactionType != "" }},
+	{"area", func(p *Property) bool { return p.Area > 0 }},
+	{"totalArea", func(p *Property) bool { return p.TotalArea > 0 }},
+	{"price", func(p *Property) bool { return p.AskingPrice > 0 || p.RentPrice > 0 }},
+	{"condoFee", func(p *Property) bool { return p.CondoFee != nil }},
+	{"bedrooms", func(p *Property) bool { return p.Bedrooms > 0 }},
+	{"bathrooms", func(p *Property) bool { return p.Bathrooms > 0 }},
+	{"parkingSpots", func(p *Property) bool { return p.ParkingSpots > 0 }},
+	{"features", func(p *Property) bool { return len(p.Features) > 0 }},
+	{"images", func(p *Property) bool { return len(p.Images) > 0 }},
+}
+
+// sourceProfile aggregates the selected source properties
+type sourceProfile struct {
+	Total            int64
+	DescriptionChars int64 // characters of ad descriptions, for those that have one
+	EmbeddingChars   int64 // characters of the generated embedding text
+	Filled           map[string]int64
+	PropertyTypes    map[string]int64
+	TransactionTypes map[string]int64
+}
+
+// Scan the selected source properties and aggregate what they contain
+func profileSourceProperties(ctx context.Context, client *mongo.Client) (sourceProfile, error) {
+	profile := sourceProfile{
+		Filled:           make(map[string]int64),
+		PropertyTypes:    make(map[string]int64),
+		TransactionTypes: make(map[string]int64),
+	}
+	cursor, err := openSourceCursor(ctx, mongoCollection{client.Database(dbName).Collection(sourceCollection)}, nil)
+	if err != nil {
+		return profile, fmt.Errorf("error finding properties: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var property Property
+		if err := decodeSourceProperty(cursor, &property); err != nil {
+			return profile, fmt.Errorf("error decoding property: %w", err)
+		}
+		profile.add(&property)
+	}
+	if err := cursor.Err(); err != nil {
+		return profile, fmt.Errorf("cursor error: %w", err)
+	}
+	return profile, nil
+}
+
+// Count one property
+func (s *sourceProfile) add(property *Property) {
+	s.Total++
+	for _, field := range profiledFields {
+		if field.filled(property) {
+			s.Filled[field.name]++
+		}
+	}
+	if property.Ad != nil && property.Ad.Description != "" {
+		s.DescriptionChars += int64(len([]rune(property.Ad.Description)))
+	}
+	s.EmbeddingChars += int64(len([]rune(embeddingText(property))))
+
+	s.PropertyTypes[orNone(property.PropertyType)]++
+	transactionType := ""
+	if property.Ad != nil {
+		transactionType = property.Ad.TransactionType
+	}
+	s.TransactionTypes[orNone(transactionType)]++
+}
+
+// Label empty values in distributions
+func orNone(value string) string {
+	if value = strings.TrimSpace(value); value == "" {
+		return "(none)"
+	}
+	return value
+}
+
+// Print the profile as tables
+func printSourceProfile(w io.Writer, s sourceProfile) {
+	fmt.Fprintf(w, "Source:                 %s.%s\n", dbName, sourceCollection)
+	fmt.Fprintf(w, "Properties:             %d\n", s.Total)
+	described := s.Filled["description"]
+	fmt.Fprintf(w, "With a description:     %d (%.2f%%)\n", described, progressPercent(described, s.Total))
+	if described > 0 {
+		fmt.Fprintf(w, "Average description:    %d characters\n", s.DescriptionChars/described)
+	}
+	if s.Total > 0 {
+		average := s.EmbeddingChars / s.Total
+		fmt.Fprintf(w, "Average embedding text: %d characters (~%d tokens)\n", average, (average+charsPerToken-1)/charsPerToken)
+		fmt.Fprintf(w, "Estimated total input:  %s\n", formatTokenCost((s.EmbeddingChars+charsPerToken-1)/charsPerToken))
+	}
+
+	fmt.Fprintln(w)
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "Field\tFilled\tRate\t")
+	for _, field := range profiledFields {
+		filled := s.Filled[field.name]
+		fmt.Fprintf(table, "%s\t%d\t%.2f%%\t\n", field.name, filled, progressPercent(filled, s.Total))
+	}
+	table.Flush()
+
+	printDistribution(w, "Property type", s.PropertyTypes, s.Total)
+	printDistribution(w, "Transaction type", s.TransactionTypes, s.Total)
+}
+
+// Print value counts, most common first
+func printDistribution(w io.Writer, title string, counts map[string]int64, total int64) {
+	values := make([]string, 0, len(counts))
+	for value := range counts {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if counts[values[i]] != counts[values[j]] {
+			return counts[values[i]] > counts[values[j]]
+		}
+		return values[i] < values[j]
+	})
+
+	fmt.Fprintln(w)
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "%s\tCount\tShare\t\n", title)
+	for _, value := range values {
+		fmt.Fprintf(table, "%s\t%d\t%.2f%%\t\n", value, counts[value], progressPercent(counts[value], total))
+	}
+	table.Flush()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// propertyCollection is the part of a MongoDB collection the workers use, so
+// tests can run them against an in-memory collection instead of a live
+// server. Fakes can build their results with mongo.NewCursorFromDocuments
+// and mongo.NewSingleResultFromDocument.
+type propertyCollection interface {
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
+	FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
+	Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error)
+	CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error)
+	InsertMany(ctx context.Context, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error)
+	ReplaceOne(ctx context.Context, filter interface{}, replacement interface{}, opts ...*options.ReplaceOptions) (*mongo.UpdateResult, error)
+	UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	// Create an index, succeeding if an identical one already exists
+	CreateIndex(ctx context.Context, model mongo.IndexModel) error
+	// Dimensions of the vector field in the named vector search index, 0 when
+	// the index doesn't exist or doesn't declare it
+	VectorIndexDimensions(ctx context.Context, name string) (int, error)
+	// Write a batch as one all-or-nothing transaction, returning how many
+	// replacements were upserted
+	WriteInTransaction(ctx context.Context, batch writeBatch) (int, error)
+}
+
+// propertyStore gives access to the configured source, target and failures collections
+type propertyStore interface {
+	Source() propertyCollection
+	Target() propertyCollection
+	Failures() propertyCollection
+}
+
+// mongoStore is the propertyStore of a MongoDB connection
+type mongoStore struct {
+	client *mongo.Client
+}
+
+func (s mongoStore) Source() propertyCollection {
+	return mongoCollection{s.client.Database(dbName).Collection(sourceCollection)}
+}
+
+func (s mongoStore) Target() propertyCollection {
+	return mongoCollection{s.client.Database(dbName).Collection(targetCollection)}
+}
+
+func (s mongoStore) Failures() propertyCollection {
+	return mongoCollection{s.client.Database(dbName).Collection(failuresCollection)}
+}
+
+// mongoCollection adapts a *mongo.Collection to propertyCollection
+type mongoCollection struct {
+	*mongo.Collection
+}
+
+func (c mongoCollection) CreateIndex(ctx context.Context, model mongo.IndexModel) error {
+	_, err := c.Indexes().CreateOne(ctx, model)
+	return err
+}
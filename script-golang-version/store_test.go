@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errUnsupportedFake = errors.New("not supported by the in-memory collection")
+
+// memoryCollection is an in-memory propertyCollection. It understands only
+// the filters the tests need: ObjectIDs at a path matched by equality, $in or
+// the $gt, $gte and $lt bounds of _id ranges, strings matched by equality,
+// $exists and $and. Documents are kept in insertion order, which FindOne
+// relies on for its sort.
+type memoryCollection struct {
+	mu           sync.Mutex
+	documents    []bson.Raw
+	inserts      [][]interface{}               // documents of every successful InsertMany, in order
+	transactions []writeBatch                  // every successful WriteInTransaction, in order
+	failures     map[primitive.ObjectID]bson.M // $set of UpdateOne upserts keyed by _id, with attempts
+	insertErr    error                         // returned by every InsertMany when set
+	// Stored properties InsertMany rejects with a write error, writing the
+	// rest of the batch as an unordered insert would
+	rejectInserts map[primitive.ObjectID]bool
+	// numDimensions of the vector search index, none when 0
+	indexDimensions int
+}
+
+// memoryStore is a propertyStore of in-memory collections
+type memoryStore struct {
+	source, target, failures *memoryCollection
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{source: &memoryCollection{}, target: &memoryCollection{}, failures: &memoryCollection{}}
+}
+
+func (s *memoryStore) Source() propertyCollection   { return s.source }
+func (s *memoryStore) Target() propertyCollection   { return s.target }
+func (s *memoryStore) Failures() propertyCollection { return s.failures }
+
+// The IDs at a dotted path of the stored documents, in insertion order
+func (c *memoryCollection) ids(path string) []primitive.ObjectID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ids []primitive.ObjectID
+	for _, doc := range c.documents {
+		if id, ok := doc.Lookup(strings.Split(path, ".")...).ObjectIDOK(); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Parse a {path: {$in: ids}} or {path: id} filter
+func idFilter(filter interface{}) (string, []primitive.ObjectID, error) {
+	m, ok := filter.(bson.M)
+	if !ok || len(m) != 1 {
+		return "", nil, errUnsupportedFake
+	}
+	for path, value := range m {
+		switch v := value.(type) {
+		case primitive.ObjectID:
+			return path, []primitive.ObjectID{v}, nil
+		case bson.M:
+			if ids, ok := v["$in"].([]primitive.ObjectID); ok {
+				return path, ids, nil
+			}
+		}
+	}
+	return "", nil, errUnsupportedFake
+}
+
+// Check whether a document has one of ids at path
+func matchesID(doc bson.Raw, path string, ids []primitive.ObjectID) bool {
+	id, ok := doc.Lookup(strings.Split(path, ".")...).ObjectIDOK()
+	return ok && slices.Contains(ids, id)
+}
+
+// Check whether a document matches a filter of ObjectID conditions, string
+// equality, $exists and $and
+func matchesFilter(doc bson.Raw, filter interface{}) (bool, error) {
+	m, ok := filter.(bson.M)
+	if !ok {
+		return false, errUnsupportedFake
+	}
+	for path, value := range m {
+		if path == "$and" {
+			conditions, ok := value.(bson.A)
+			if !ok {
+				return false, errUnsupportedFake
+			}
+			for _, condition := range conditions {
+				if match, err := matchesFilter(doc, condition); err != nil || !match {
+					return false, err
+				}
+			}
+			continue
+		}
+		field, err := doc.LookupErr(strings.Split(path, ".")...)
+		exists := err == nil
+		id, found := field.ObjectIDOK()
+		text, isText := field.StringValueOK()
+		switch v := value.(type) {
+		case primitive.ObjectID:
+			if !found || id != v {
+				return false, nil
+			}
+		case string:
+			if !isText || text != v {
+				return false, nil
+			}
+		case bson.M:
+			for operator, operand := range v {
+				match := false
+				switch operator {
+				case "$in":
+					ids, _ := operand.([]primitive.ObjectID)
+					match = found && slices.Contains(ids, id)
+				case "$gt", "$gte", "$lt":
+					bound, ok := operand.(primitive.ObjectID)
+					if !ok {
+						return false, errUnsupportedFake
+					}
+					cmp := bytes.Compare(id[:], bound[:])
+					match = found && (operator == "$gt" && cmp > 0 || operator == "$gte" && cmp >= 0 || operator == "$lt" && cmp < 0)
+				case "$exists":
+					want, ok := operand.(bool)
+					if !ok {
+						return false, errUnsupportedFake
+					}
+					match = exists == want
+				default:
+					return false, errUnsupportedFake
+				}
+				if !match {
+					return false, nil
+				}
+			}
+		default:
+			return false, errUnsupportedFake
+		}
+	}
+	return true, nil
+}
+
+// The stored documents matching a filter, in insertion order
+func (c *memoryCollection) matching(filter interface{}) ([]bson.Raw, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var matches []bson.Raw
+	for _, doc := range c.documents {
+		ok, err := matchesFilter(doc, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matches = append(matches, doc)
+		}
+	}
+	return matches, nil
+}
+
+// Honors the limit option and a sort on one ObjectID or date field
+func (c *memoryCollection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	matches, err := c.matching(filter)
+	if err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if sort, ok := opt.Sort.(bson.D); ok && len(sort) == 1 {
+			sortDocuments(matches, sort[0].Key, sort[0].Value == -1)
+		}
+		if opt.Limit != nil && *opt.Limit > 0 && int(*opt.Limit) < len(matches) {
+			matches = matches[:*opt.Limit]
+		}
+	}
+	documents := make([]interface{}, len(matches))
+	for i, doc := range matches {
+		documents[i] = doc
+	}
+	return mongo.NewCursorFromDocuments(documents, nil, nil)
+}
+
+// Honors the skip option and a sort on one ObjectID or date field; other
+// documents come in insertion order
+func (c *memoryCollection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	matches, err := c.matching(filter)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+	var skip int64
+	for _, opt := range opts {
+		if opt == nil {
+			continue
+		}
+		if opt.Skip != nil {
+			skip = *opt.Skip
+		}
+		if sort, ok := opt.Sort.(bson.D); ok && len(sort) == 1 {
+			sortDocuments(matches, sort[0].Key, sort[0].Value == -1)
+		}
+	}
+	if skip >= int64(len(matches)) {
+		return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+	}
+	return mongo.NewSingleResultFromDocument(matches[skip], nil, nil)
+}
+
+// Sort documents by an ObjectID or date field, stably
+func sortDocuments(documents []bson.Raw, path string, descending bool) {
+	key := func(doc bson.Raw) []byte {
+		value := doc.Lookup(strings.Split(path, ".")...)
+		if id, ok := value.ObjectIDOK(); ok {
+			return id[:]
+		}
+		if millis, ok := value.DateTimeOK(); ok {
+			return binary.BigEndian.AppendUint64(nil, uint64(millis)^1<<63)
+		}
+		return nil
+	}
+	slices.SortStableFunc(documents, func(a, b bson.Raw) int {
+		if descending {
+			return bytes.Compare(key(b), key(a))
+		}
+		return bytes.Compare(key(a), key(b))
+	})
+}
+
+func (c *memoryCollection) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	return nil, errUnsupportedFake
+}
+
+func (c *memoryCollection) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	matches, err := c.matching(filter)
+	return int64(len(matches)), err
+}
+
+func (c *memoryCollection) InsertMany(ctx context.Context, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+	if c.insertErr != nil {
+		return nil, c.insertErr
+	}
+	var raws []bson.Raw
+	var inserted []interface{}
+	var bulkErr mongo.BulkWriteException
+	for i, doc := range documents {
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		var stored PropertyWithEmbedding
+		if decodeStoredDocument(data, &stored) == nil && c.rejectInserts[stored.Metadata.ID] {
+			bulkErr.WriteErrors = append(bulkErr.WriteErrors, mongo.BulkWriteError{
+				WriteError: mongo.WriteError{Index: i, Code: 11000, Message: "duplicate key"},
+			})
+			continue
+		}
+		raws = append(raws, data)
+		inserted = append(inserted, doc)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.documents = append(c.documents, raws...)
+	c.inserts = append(c.inserts, inserted)
+	result := &mongo.InsertManyResult{InsertedIDs: make([]interface{}, len(inserted))}
+	if len(bulkErr.WriteErrors) > 0 {
+		return result, bulkErr
+	}
+	return result, nil
+}
+
+func (c *memoryCollection) ReplaceOne(ctx context.Context, filter interface{}, replacement interface{}, opts ...*options.ReplaceOptions) (*mongo.UpdateResult, error) {
+	path, ids, err := idFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	data, err := bson.Marshal(replacement)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, doc := range c.documents {
+		if matchesID(doc, path, ids) {
+			c.documents[i] = data
+			return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+		}
+	}
+	c.documents = append(c.documents, data)
+	return &mongo.UpdateResult{UpsertedCount: 1}, nil
+}
+
+// Only the failure upserts of recordFailedProperties are supported
+func (c *memoryCollection) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	_, ids, err := idFilter(filter)
+	if err != nil || len(ids) != 1 {
+		return nil, errUnsupportedFake
+	}
+	set, _ := update.(bson.M)["$set"].(bson.M)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failures == nil {
+		c.failures = make(map[primitive.ObjectID]bson.M)
+	}
+	attempts := 1
+	if previous, ok := c.failures[ids[0]]; ok {
+		attempts += previous["attempts"].(int)
+	}
+	entry := bson.M{"attempts": attempts}
+	for key, value := range set {
+		entry[key] = value
+	}
+	c.failures[ids[0]] = entry
+	return &mongo.UpdateResult{UpsertedCount: 1}, nil
+}
+
+func (c *memoryCollection) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return nil, errUnsupportedFake
+}
+
+func (c *memoryCollection) CreateIndex(ctx context.Context, model mongo.IndexModel) error {
+	return nil
+}
+
+func (c *memoryCollection) VectorIndexDimensions(ctx context.Context, name string) (int, error) {
+	return c.indexDimensions, nil
+}
+
+// Without sessions the batch is simply written and recorded, all or nothing
+// only as far as insertErr goes
+func (c *memoryCollection) WriteInTransaction(ctx context.Context, batch writeBatch) (int, error) {
+	if len(batch.inserts) > 0 {
+		if _, err := c.InsertMany(ctx, batch.inserts); err != nil {
+			return 0, err
+		}
+	}
+	upserted := 0
+	for i, document := range batch.replacements {
+		result, err := c.ReplaceOne(ctx, bson.M{storedPath("metadata._id"): batch.replacedIDs[i]}, document)
+		if err != nil {
+			return 0, err
+		}
+		upserted += int(result.UpsertedCount)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.transactions = append(c.transactions, batch)
+	return upserted, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Default names of the renameable top-level fields in stored documents
+const (
+	defaultMetadataField = "metadata"
+	defaultVectorField   = "embeddings"
+)
+
+// Configured names of the metadata wrapper and vector fields
+var (
+	metadataField = defaultMetadataField
+	vectorField   = defaultVectorField
+)
+
+// Check that the configured names are usable and don't collide with other fields
+func validateStoredFieldNames() error {
+	for _, name := range []string{metadataField, vectorField} {
+		if name == "" || strings.ContainsAny(name, ".$") {
+			return fmt.Errorf("invalid stored field name %q: must be non-empty without '.' or '$'", name)
+		}
+	}
+	if metadataField == vectorField {
+		return fmt.Errorf("METADATA_FIELD and VECTOR_FIELD must differ, both are %q", vectorField)
+	}
+
+	// Other top-level fields keep their names
+	docType := reflect.TypeOf(PropertyWithEmbedding{})
+	for i := 0; i < docType.NumField(); i++ {
+		key, _, _ := strings.Cut(docType.Field(i).Tag.Get("bson"), ",")
+		if key != defaultMetadataField && key != defaultVectorField && (key == metadataField || key == vectorField) {
+			return fmt.Errorf("stored field name %q is already used", key)
+		}
+	}
+	return nil
+}
+
+// Map a default top-level field name to its configured name
+func storedFieldName(name string) string {
+	switch name {
+	case defaultMetadataField:
+		return metadataField
+	case defaultVectorField:
+		return vectorField
+	}
+	return name
+}
+
+// Map a configured top-level field name back to its default name
+func defaultFieldName(name string) string {
+	switch name {
+	case metadataField:
+		return defaultMetadataField
+	case vectorField:
+		return defaultVectorField
+	}
+	return name
+}
+
+// Rewrite a dotted path such as "metadata._id" to use the configured names
+func storedPath(path string) string {
+	head, rest, nested := strings.Cut(path, ".")
+	if !nested {
+		return storedFieldName(head)
+	}
+	return storedFieldName(head) + "." + rest
+}
+
+// Convert a document to the form written to the target collection
+func storedDocument(doc PropertyWithEmbedding) (bson.D, error) {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var stored bson.D
+	if err := bson.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	for i := range stored {
+		stored[i].Key = storedFieldName(stored[i].Key)
+	}
+	return stored, nil
+}
+
+// The property ID of a document built by storedDocument, for logging
+func storedPropertyID(document interface{}) string {
+	raw, err := bson.Marshal(document)
+	if err != nil {
+		return "unknown"
+	}
+	var doc PropertyWithEmbedding
+	if err := decodeStoredDocument(raw, &doc); err != nil {
+		return "unknown"
+	}
+	return doc.Metadata.ID.Hex()
+}
+
+// Decode a document read from the target collection
+func decodeStoredDocument(raw bson.Raw, doc *PropertyWithEmbedding) error {
+	var stored bson.D
+	if err := bson.Unmarshal(raw, &stored); err != nil {
+		return err
+	}
+	for i := range stored {
+		stored[i].Key = defaultFieldName(stored[i].Key)
+	}
+	data, err := bson.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, doc)
+}
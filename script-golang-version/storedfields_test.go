@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Set the stored field names for a test, restoring the defaults when it ends
+func setStoredFieldNames(t *testing.T, metadata, vector string) {
+	t.Helper()
+	t.Cleanup(func() { metadataField, vectorField = defaultMetadataField, defaultVectorField })
+	metadataField, vectorField = metadata, vector
+}
+
+func TestValidateStoredFieldNames(t *testing.T) {
+	tests := []struct {
+		metadata, vector string
+		wantErr          bool
+	}{
+		{metadata: "metadata", vector: "embeddings"},
+		{metadata: "property", vector: "vector"},
+		{metadata: "embeddings", vector: "metadata"},
+		{metadata: "", vector: "vector", wantErr: true},
+		{metadata: "property.data", vector: "vector", wantErr: true},
+		{metadata: "property", vector: "$vector", wantErr: true},
+		{metadata: "vector", vector: "vector", wantErr: true},
+		{metadata: "property", vector: "description_text", wantErr: true},
+		{metadata: "tenant_id", vector: "vector", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.metadata+","+tt.vector, func(t *testing.T) {
+			setStoredFieldNames(t, tt.metadata, tt.vector)
+			if err := validateStoredFieldNames(); (err != nil) != tt.wantErr {
+				t.Errorf("validateStoredFieldNames error = %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStoredPath(t *testing.T) {
+	setStoredFieldNames(t, "property", "vector")
+	tests := map[string]string{
+		"metadata":          "property",
+		"metadata._id":      "property._id",
+		"metadata.ad.title": "property.ad.title",
+		"embeddings":        "vector",
+		"description_text":  "description_text",
+		"tenant_id":         "tenant_id",
+	}
+	for path, want := range tests {
+		if got := storedPath(path); got != want {
+			t.Errorf("storedPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+// Documents are written under the configured names and read back unchanged,
+// even when the two names are swapped
+func TestStoredDocumentRoundTrip(t *testing.T) {
+	doc := PropertyWithEmbedding{
+		Metadata:        Property{ID: primitive.NewObjectID(), City: "Curitiba"},
+		TenantID:        "acme",
+		DescriptionText: "Flat in Curitiba",
+		Embeddings:      []float32{1, 0, 0},
+		EmbeddedAt:      time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		CreatedAt:       time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct{ metadata, vector string }{
+		{metadata: "metadata", vector: "embeddings"},
+		{metadata: "property", vector: "vector"},
+		{metadata: "embeddings", vector: "metadata"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.metadata+","+tt.vector, func(t *testing.T) {
+			setStoredFieldNames(t, tt.metadata, tt.vector)
+			stored, err := storedDocument(doc)
+			if err != nil {
+				t.Fatalf("storedDocument: %v", err)
+			}
+			raw, err := bson.Marshal(stored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id, ok := bson.Raw(raw).Lookup(tt.metadata, "_id").ObjectIDOK(); !ok || id != doc.Metadata.ID {
+				t.Errorf("stored document has no %s._id: %v", tt.metadata, bson.Raw(raw))
+			}
+			if _, ok := bson.Raw(raw).Lookup(tt.vector).ArrayOK(); !ok {
+				t.Errorf("stored document has no %s array: %v", tt.vector, bson.Raw(raw))
+			}
+
+			var decoded PropertyWithEmbedding
+			if err := decodeStoredDocument(raw, &decoded); err != nil {
+				t.Fatalf("decodeStoredDocument: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, doc) {
+				t.Errorf("decoded %+v, want %+v", decoded, doc)
+			}
+			if got := storedPropertyID(stored); got != doc.Metadata.ID.Hex() {
+				t.Errorf("storedPropertyID = %s, want %s", got, doc.Metadata.ID.Hex())
+			}
+		})
+	}
+}
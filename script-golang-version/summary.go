@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"golang.org/x/time/rate"
+)
+
+// How the generated summary is used as embedding input
+const (
+	summaryModeReplace = "replace"
+	summaryModeAppend  = "append"
+)
+
+// Instruction sent to the generative model ahead of the property description
+const summaryPrompt = `Write a concise, factual summary (at most three sentences) of the following real estate listing for a property search app.
+Use only the information given and reply with the summary text only.
+
+`
+
+// Shared limiter for summary requests across all workers, set in main
+var summaryLimiter *rate.Limiter
+
+// Generate a short natural-language summary of a property description with retry
+func generateSummary(
+	ctx context.Context,
+	description string,
+	client *genai.Client,
+	maxRetries int,
+	initialBackoff time.Duration,
+) (string, error) {
+	return generateTextWithRetry(ctx, client, summaryModel, summaryPrompt+description, summaryLimiter, "summary",
+		maxRetries, initialBackoff)
+}
+
+// Generate text with a generative model, rate limited and retried with backoff
+func generateTextWithRetry(
+	ctx context.Context,
+	client *genai.Client,
+	modelName string,
+	prompt string,
+	limiter *rate.Limiter,
+	kind string,
+	maxRetries int,
+	initialBackoff time.Duration,
+) (string, error) {
+	model := client.GenerativeModel(modelName)
+	model.SetTemperature(0.2)
+
+	for retries := 0; retries < maxRetries; retries++ {
+		if err := limiter.Wait(ctx); err != nil {
+			return "", err
+		}
+
+		resp, err := model.GenerateContent(ctx, genai.Text(prompt))
+		var text string
+		if err == nil {
+			text = responseText(resp)
+			if text == "" {
+				err = fmt.Errorf("%s response contained no text", kind)
+			}
+		}
+		if err != nil {
+			metrics.Count(metricErrors, 1, "type:"+kind)
+			if !isRetryable(err) {
+				return "", fmt.Errorf("%s request failed with a permanent error: %w", kind, err)
+			}
+			if retries == maxRetries-1 {
+				return "", fmt.Errorf("failed to generate %s after %d attempts: %w", kind, maxRetries, err)
+			}
+
+			backoff := retryBackoff(initialBackoff, retries)
+			slog.Warn("Generative API error, retrying", "kind", kind, "backoff_ms", backoff.Milliseconds(),
+				"attempt", retries+1, "max_attempts", maxRetries, "error", err)
+			if err := sleepContext(ctx, backoff); err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		return text, nil
+	}
+
+	return "", fmt.Errorf("max retries exceeded")
+}
+
+// Concatenate the text parts of the first candidate
+func responseText(resp *genai.GenerateContentResponse) string {
+	if resp == nil || len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+		return ""
+	}
+	var text strings.Builder
+	for _, part := range resp.Candidates[0].Content.Parts {
+		if t, ok := part.(genai.Text); ok {
+			text.WriteString(string(t))
+		}
+	}
+	return strings.TrimSpace(text.String())
+}
+
+// Build the embedding input from the templated description and the summary
+func summaryEmbeddingInput(description, summary string) string {
+	if summaryMode == summaryModeReplace {
+		return summary
+	}
+	return description + "\nSummary: " + summary
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Tenant tagging: a fixed tenant for the whole run, or a Property field holding it
+var (
+	tenantID    string
+	tenantField string
+)
+
+// Resolve the tenant a property belongs to
+func propertyTenant(property *Property) string {
+	if tenantField == "" {
+		return tenantID
+	}
+	value, ok := fieldPathValue(reflect.ValueOf(property).Elem(), strings.Split(tenantField, "."))
+	if !ok || value.IsZero() {
+		return tenantID
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+// Build the mandatory tenant filter for searches. Without a tenant, only
+// untagged documents match, so tagged data never leaks into untenanted searches.
+func tenantFilter(tenant string) bson.M {
+	if tenant == "" {
+		return bson.M{"tenant_id": bson.M{"$exists": false}}
+	}
+	return bson.M{"tenant_id": tenant}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPropertyTenant(t *testing.T) {
+	defer func(id, field string) { tenantID, tenantField = id, field }(tenantID, tenantField)
+
+	tests := []struct {
+		name     string
+		id       string
+		field    string
+		property Property
+		want     string
+	}{
+		{name: "untenanted", want: ""},
+		{name: "fixed tenant", id: "acme", want: "acme"},
+		{name: "field", field: "company.name", property: Property{Company: &Company{Name: "Imob"}}, want: "Imob"},
+		{name: "numeric field", field: "bedrooms", property: Property{Bedrooms: 3}, want: "3"},
+		{name: "empty field falls back", id: "acme", field: "company.name", property: Property{Company: &Company{}}, want: "acme"},
+		{name: "nil parent falls back", id: "acme", field: "company.name", want: "acme"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tenantID, tenantField = tt.id, tt.field
+			if got := propertyTenant(&tt.property); got != tt.want {
+				t.Errorf("propertyTenant = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Properties stored for one tenant never show up in another tenant's search,
+// and untenanted searches only see untagged properties
+func TestSearchesStayWithinTheirTenant(t *testing.T) {
+	setupWorkerTest(t, stubProvider{}, 10)
+	defer func(id string) { tenantID = id }(tenantID)
+	store := newMemoryStore()
+	owned := make(map[string][]primitive.ObjectID)
+	for _, tenant := range []string{"acme", "globex", ""} {
+		tenantID = tenant
+		properties := testProperties(tenant+" one", tenant+" two")
+		runWorker(store, properties)
+		owned[tenant] = propertyIDs(properties)
+	}
+	tenantID = ""
+
+	searches := map[string]func(tenant string) ([]SearchResult, error){
+		"local": func(tenant string) ([]SearchResult, error) {
+			return localSearch(context.Background(), store.target, "casa", tenant, 10, 100, nil)
+		},
+		"brute force": func(tenant string) ([]SearchResult, error) {
+			return bruteForceSearch(context.Background(), store.target, "casa", tenant, 10, nil)
+		},
+	}
+	for name, search := range searches {
+		for tenant, want := range owned {
+			t.Run(name+"/"+tenant, func(t *testing.T) {
+				results, err := search(tenant)
+				if err != nil {
+					t.Fatalf("search: %v", err)
+				}
+				var got []primitive.ObjectID
+				for _, result := range results {
+					got = append(got, result.Property.ID)
+				}
+				slices.SortFunc(got, func(a, b primitive.ObjectID) int { return slices.Compare(a[:], b[:]) })
+				if !slices.Equal(got, want) {
+					t.Errorf("tenant %q found %v, want %v", tenant, got, want)
+				}
+			})
+		}
+	}
+}
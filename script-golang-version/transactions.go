@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Wrap each batch insert in a transaction, set in main once support is confirmed
+var useTransactions bool
+
+// Collection in the target database holding each worker's checkpoint with
+// -transactional, written in the same transaction as the batch it covers
+const checkpointCollection = "embedding_checkpoints"
+
+// batchCheckpoint is the last property a worker finished, as stored in
+// checkpointCollection for its CHECKPOINT_FILE
+type batchCheckpoint struct {
+	File   string             `bson:"file"`
+	Worker int                `bson:"worker"`
+	LastID primitive.ObjectID `bson:"last_id"`
+}
+
+// Check whether the deployment supports transactions (replica sets and sharded clusters)
+func supportsTransactions(ctx context.Context, client *mongo.Client) (bool, error) {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return false, fmt.Errorf("error checking deployment type: %w", err)
+	}
+	return helloSupportsTransactions(hello.SetName, hello.Msg), nil
+}
+
+// Replica set members report their set name and mongos routers "isdbgrid";
+// a standalone server reports neither
+func helloSupportsTransactions(setName, msg string) bool {
+	return setName != "" || msg == "isdbgrid"
+}
+
+// Write a batch as a single all-or-nothing transaction, returning how many of
+// its replacements recreated a deleted document
+func (c mongoCollection) WriteInTransaction(ctx context.Context, batch writeBatch) (int, error) {
+	session, err := c.Database().Client().StartSession()
+	if err != nil {
+		return 0, fmt.Errorf("error starting session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	var upserted int
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		// The callback runs again when the transaction is retried
+		upserted = 0
+		if len(batch.inserts) > 0 {
+			if _, err := c.InsertMany(sessionCtx, batch.inserts); err != nil {
+				return nil, err
+			}
+		}
+		for i, document := range batch.replacements {
+			result, err := c.ReplaceOne(sessionCtx, bson.M{storedPath("metadata._id"): batch.replacedIDs[i]}, document,
+				options.Replace().SetUpsert(true))
+			if err != nil {
+				return nil, err
+			}
+			upserted += int(result.UpsertedCount)
+		}
+		if batch.checkpoint != nil {
+			_, err := c.Database().Collection(checkpointCollection).ReplaceOne(sessionCtx,
+				bson.M{"file": batch.checkpoint.File, "worker": batch.checkpoint.Worker}, batch.checkpoint,
+				options.Replace().SetUpsert(true))
+			if err != nil {
+				return nil, fmt.Errorf("error writing checkpoint: %w", err)
+			}
+		}
+		return nil, nil
+	})
+	return upserted, err
+}
+
+// Load the last property each worker stored in a transaction for CHECKPOINT_FILE, by worker ID
+func loadBatchCheckpoints(ctx context.Context, collection propertyCollection) (map[int]primitive.ObjectID, error) {
+	cursor, err := collection.Find(ctx, bson.M{"file": checkpointFile})
+	if err != nil {
+		return nil, fmt.Errorf("error reading stored checkpoints: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	stored := make(map[int]primitive.ObjectID)
+	for cursor.Next(ctx) {
+		var checkpoint batchCheckpoint
+		if err := cursor.Decode(&checkpoint); err != nil {
+			return nil, fmt.Errorf("error decoding stored checkpoint: %w", err)
+		}
+		stored[checkpoint.Worker] = checkpoint.LastID
+	}
+	return stored, cursor.Err()
+}
+
+// Delete the checkpoints stored for CHECKPOINT_FILE
+func removeBatchCheckpoints(ctx context.Context, collection propertyCollection) error {
+	if _, err := collection.DeleteMany(ctx, bson.M{"file": checkpointFile}); err != nil {
+		return fmt.Errorf("error removing stored checkpoints: %w", err)
+	}
+	return nil
+}
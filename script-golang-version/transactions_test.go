@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestHelloSupportsTransactions(t *testing.T) {
+	tests := []struct {
+		name    string
+		setName string
+		msg     string
+		want    bool
+	}{
+		{name: "standalone server", want: false},
+		{name: "replica set member", setName: "rs0", want: true},
+		{name: "mongos router", msg: "isdbgrid", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := helloSupportsTransactions(tt.setName, tt.msg); got != tt.want {
+				t.Errorf("helloSupportsTransactions(%q, %q) = %t, want %t", tt.setName, tt.msg, got, tt.want)
+			}
+		})
+	}
+}
+
+// A standalone server leaves useTransactions off, and batches become plain inserts
+func TestInsertBatchFallsBackToPlainInserts(t *testing.T) {
+	setupWorkerTest(t, stubProvider{}, 10)
+	target := &memoryCollection{}
+	documents := []interface{}{
+		map[string]primitive.ObjectID{"_id": primitive.NewObjectID()},
+		map[string]primitive.ObjectID{"_id": primitive.NewObjectID()},
+	}
+
+	inserted, err := insertBatch(context.Background(), target, writeBatch{inserts: documents})
+	if err != nil {
+		t.Fatalf("insertBatch: %v", err)
+	}
+	if inserted != 2 || len(target.inserts) != 1 {
+		t.Errorf("inserted %d documents in %d inserts, want 2 in 1", inserted, len(target.inserts))
+	}
+	if len(target.transactions) != 0 {
+		t.Errorf("wrote %d transactions without transaction support", len(target.transactions))
+	}
+}
+
+func TestWorkerWritesCheckpointInTransaction(t *testing.T) {
+	setupWorkerTest(t, stubProvider{}, 2)
+	defer func(file string, c *checkpointer, force bool) {
+		checkpointFile, checkpoints, forceReembed = file, c, force
+	}(checkpointFile, checkpoints, forceReembed)
+	checkpointFile = filepath.Join(t.TempDir(), "checkpoint.json")
+	checkpoints = newCheckpointer(checkpointFile, []idRange{{}})
+	useTransactions = true
+
+	store := newMemoryStore()
+	properties := testProperties("a", "b", "c")
+	runWorker(store, properties[:1])
+	forceReembed = true
+	store.target.transactions = nil
+
+	runWorker(store, properties)
+
+	batches := store.target.transactions
+	if len(batches) != 2 {
+		t.Fatalf("wrote %d transactions, want 2", len(batches))
+	}
+	// The re-embedded property is replaced in the batch's transaction
+	if !slices.Equal(batches[0].replacedIDs, []primitive.ObjectID{properties[0].ID}) || len(batches[0].inserts) != 1 {
+		t.Errorf("first batch replaces %v and inserts %d, want %s and 1",
+			batches[0].replacedIDs, len(batches[0].inserts), properties[0].ID.Hex())
+	}
+	// Nothing was handled before the first batch was queued
+	if batches[0].checkpoint != nil {
+		t.Errorf("first batch checkpoint = %+v, want none", batches[0].checkpoint)
+	}
+	want := batchCheckpoint{File: checkpointFile, Worker: 1, LastID: properties[2].ID}
+	if got := batches[1].checkpoint; got == nil || *got != want {
+		t.Errorf("final batch checkpoint = %+v, want %+v", got, want)
+	}
+	if got := checkpoints.state.Workers[0].LastID; got != properties[2].ID.Hex() {
+		t.Errorf("file checkpoint = %s, want %s", got, properties[2].ID.Hex())
+	}
+}
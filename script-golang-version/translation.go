@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"golang.org/x/time/rate"
+)
+
+// Translation holds a translated description and its embedding, stored
+// alongside the original when both are kept
+type Translation struct {
+	Language   string    `bson:"language" json:"language"`
+	Text       string    `bson:"text" json:"text"`
+	Embeddings []float32 `bson:"embeddings" json:"embeddings"`
+}
+
+// Instruction sent to the generative model ahead of the property description
+const translationPrompt = `Translate the following real estate listing into the language with code %q.
+Keep the "Label: value" line structure, translate labels and free text, keep numbers, names and units unchanged,
+and reply with the translation only.
+
+`
+
+// Shared limiter for translation requests across all workers, set in main
+var translationLimiter *rate.Limiter
+
+// Translate a property description into the target language with retry
+func translateDescription(
+	ctx context.Context,
+	description string,
+	client *genai.Client,
+	maxRetries int,
+	initialBackoff time.Duration,
+) (string, error) {
+	prompt := fmt.Sprintf(translationPrompt, translateTo) + description
+	return generateTextWithRetry(ctx, client, translationModel, prompt, translationLimiter, "translation",
+		maxRetries, initialBackoff)
+}
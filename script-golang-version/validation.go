@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Skip properties whose embedding text is shorter than this many characters (disabled when 0)
+var minDescriptionLength int
+
+// File listing the IDs of properties skipped for a too short description (unset when disabled)
+var skippedFile string
+
+// Properties skipped across all workers because their description was too short
+var propertiesTooShort atomic.Int64
+
+// Shared writer for SKIPPED_FILE, set in main when configured
+var skippedReport *skippedIDWriter
+
+// Check that the embedding text carries enough signal to be worth storing.
+// Sparse documents can produce little more than "Exclusive: No".
+func descriptionLongEnough(text string) bool {
+	return minDescriptionLength == 0 || utf8.RuneCountInString(strings.TrimSpace(text)) >= minDescriptionLength
+}
+
+// Record a property skipped for a too short description
+func recordTooShort(property *Property, text string) {
+	propertiesTooShort.Add(1)
+	length := utf8.RuneCountInString(strings.TrimSpace(text))
+	slog.Info("Description below MIN_DESCRIPTION_LENGTH, skipping", "property_id", property.ID.Hex(),
+		"length", length, "min_length", minDescriptionLength)
+	if skippedReport != nil {
+		if err := skippedReport.write(property.ID); err != nil {
+			slog.Warn("Error recording skipped property", "property_id", property.ID.Hex(), "error", err)
+		}
+	}
+}
+
+// skippedIDWriter appends one property ID per line, shared by all workers
+type skippedIDWriter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Create (or truncate) the skipped report
+func createSkippedIDWriter(path string) (*skippedIDWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating skipped report: %w", err)
+	}
+	return &skippedIDWriter{file: file}, nil
+}
+
+func (w *skippedIDWriter) write(id primitive.ObjectID) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := fmt.Fprintln(w.file, id.Hex()); err != nil {
+		return fmt.Errorf("error writing skipped report: %w", err)
+	}
+	return nil
+}
+
+func (w *skippedIDWriter) Close() error {
+	return w.file.Close()
+}
+
+// Policies for properties whose Area exceeds their TotalArea
+const (
+	areaConflictKeep        = "keep"
+	areaConflictSkip        = "skip"
+	areaConflictSwap        = "swap"
+	areaConflictDropSmaller = "drop-smaller"
+)
+
+// Apply the configured policy when Area exceeds TotalArea, which presents
+// contradictory information in the description. Returns false when the
+// property should be skipped.
+func resolveAreaConflict(property *Property, policy string) bool {
+	if property.TotalArea <= 0 || property.Area <= property.TotalArea {
+		return true
+	}
+
+	conflict := fmt.Sprintf("area %.2f exceeds total area %.2f", property.Area, property.TotalArea)
+	switch policy {
+	case areaConflictSkip:
+		slog.Warn("Area conflict, skipping", "property_id", property.ID.Hex(), "conflict", conflict)
+		return false
+	case areaConflictSwap:
+		property.Area, property.TotalArea = property.TotalArea, property.Area
+		slog.Warn("Area conflict, swapped the values", "property_id", property.ID.Hex(), "conflict", conflict)
+	case areaConflictDropSmaller:
+		property.TotalArea = 0
+		slog.Warn("Area conflict, dropped the total area", "property_id", property.ID.Hex(), "conflict", conflict)
+	}
+	return true
+}
+
+// Check that an area conflict policy is known
+func validAreaConflictPolicy(policy string) bool {
+	switch policy {
+	case areaConflictKeep, areaConflictSkip, areaConflictSwap, areaConflictDropSmaller:
+		return true
+	}
+	return false
+}
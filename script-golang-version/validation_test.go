@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestResolveAreaConflict(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        string
+		area, total   float64
+		keep          bool
+		wantArea      float64
+		wantTotalArea float64
+	}{
+		{name: "consistent areas are untouched", policy: areaConflictSwap, area: 80, total: 100, keep: true, wantArea: 80, wantTotalArea: 100},
+		{name: "equal areas are untouched", policy: areaConflictSkip, area: 100, total: 100, keep: true, wantArea: 100, wantTotalArea: 100},
+		{name: "missing total area is untouched", policy: areaConflictSkip, area: 100, keep: true, wantArea: 100},
+		{name: "keep", policy: areaConflictKeep, area: 120, total: 100, keep: true, wantArea: 120, wantTotalArea: 100},
+		{name: "skip", policy: areaConflictSkip, area: 120, total: 100, keep: false, wantArea: 120, wantTotalArea: 100},
+		{name: "swap", policy: areaConflictSwap, area: 120, total: 100, keep: true, wantArea: 100, wantTotalArea: 120},
+		{name: "drop smaller", policy: areaConflictDropSmaller, area: 120, total: 100, keep: true, wantArea: 120},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			property := Property{Area: tt.area, TotalArea: tt.total}
+			if keep := resolveAreaConflict(&property, tt.policy); keep != tt.keep {
+				t.Errorf("resolveAreaConflict = %t, want %t", keep, tt.keep)
+			}
+			if property.Area != tt.wantArea || property.TotalArea != tt.wantTotalArea {
+				t.Errorf("areas = %.0f and %.0f, want %.0f and %.0f", property.Area, property.TotalArea, tt.wantArea, tt.wantTotalArea)
+			}
+		})
+	}
+}
+
+func TestValidAreaConflictPolicy(t *testing.T) {
+	for _, policy := range []string{areaConflictKeep, areaConflictSkip, areaConflictSwap, areaConflictDropSmaller} {
+		if !validAreaConflictPolicy(policy) {
+			t.Errorf("validAreaConflictPolicy(%q) = false, want true", policy)
+		}
+	}
+	for _, policy := range []string{"", "drop", "Swap"} {
+		if validAreaConflictPolicy(policy) {
+			t.Errorf("validAreaConflictPolicy(%q) = true, want false", policy)
+		}
+	}
+}
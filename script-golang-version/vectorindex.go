@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Create the Atlas Vector Search index on the target collection before processing
+var createVectorIndex bool
+
+// Length of the stored vectors: EMBED_DIMENSIONS when set, otherwise what the
+// provider reports, taking a concatenated ensemble into account
+func storedVectorDimensions() (int, error) {
+	if embedDimensions > 0 {
+		return embedDimensions, nil
+	}
+	dimensions := embeddingProvider.Dimensions()
+	if ensembleProvider != nil && ensembleStrategy == ensembleConcat {
+		if ensembleProvider.Dimensions() == 0 {
+			dimensions = 0
+		} else {
+			dimensions += ensembleProvider.Dimensions()
+		}
+	}
+	if dimensions == 0 {
+		return 0, fmt.Errorf("dimensions of model %s are unknown, set EMBED_DIMENSIONS", embeddingProvider.Model())
+	}
+	return dimensions, nil
+}
+
+// Check that the configured model produces vectors of the same length as the
+// ones already stored, so one collection never mixes incompatible embeddings.
+// Unknown dimensions and an empty target pass.
+func checkStoredDimensions(ctx context.Context, targetDB *mongo.Collection) error {
+	dimensions, err := storedVectorDimensions()
+	if err != nil {
+		return nil
+	}
+	var stored PropertyWithEmbedding
+	err = targetDB.FindOne(ctx, bson.M{"dimensions": bson.M{"$gt": 0}},
+		options.FindOne().SetSort(bson.D{{Key: "embedded_at", Value: -1}}).
+			SetProjection(bson.M{"model": 1, "dimensions": 1})).Decode(&stored)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading stored dimensions: %w", err)
+	}
+	if stored.Dimensions != dimensions {
+		return fmt.Errorf("model %s produces %d dimensions but %s.%s stores %d-dimension vectors from %s",
+			embeddingModelLabel(), dimensions, dbName, targetDB.Name(), stored.Dimensions, stored.Model)
+	}
+	if stored.Model != "" && stored.Model != embeddingModelLabel() {
+		slog.Warn("Target holds vectors from another model", "collection", dbName+"."+targetDB.Name(),
+			"stored_model", stored.Model, "model", embeddingModelLabel())
+	}
+	return nil
+}
+
+// Paths of the normalized filter fields, declared in the vector search index
+// so $vectorSearch can pre-filter on them
+var filterFieldPaths = []string{
+	"filter.city",
+	"filter.state",
+	"filter.property_type",
+	"filter.transaction_type",
+	"filter.bedrooms",
+	"filter.price_bucket",
+}
+
+// Fields of the vector search index: the vector with cosine similarity, then
+// tenant_id and the normalized filter fields as filters
+func vectorIndexFields(dimensions int) bson.A {
+	fields := bson.A{
+		bson.M{"type": "vector", "path": vectorField, "numDimensions": dimensions, "similarity": "cosine"},
+		bson.M{"type": "filter", "path": "tenant_id"},
+	}
+	for _, path := range filterFieldPaths {
+		fields = append(fields, bson.M{"type": "filter", "path": path})
+	}
+	return fields
+}
+
+// Create the VECTOR_INDEX vector search index, unless it already exists
+func ensureVectorIndex(ctx context.Context, targetDB *mongo.Collection) error {
+	exists, err := searchIndexExists(ctx, targetDB, vectorIndexName)
+	if err != nil {
+		return fmt.Errorf("error listing search indexes: %w", err)
+	}
+	if exists {
+		slog.Info("Vector search index already exists", "index", vectorIndexName)
+		return nil
+	}
+
+	dimensions, err := storedVectorDimensions()
+	if err != nil {
+		return err
+	}
+
+	// The driver's search index API can't set the index type, so issue the command directly
+	command := bson.D{
+		{Key: "createSearchIndexes", Value: targetDB.Name()},
+		{Key: "indexes", Value: bson.A{bson.M{
+			"name":       vectorIndexName,
+			"type":       "vectorSearch",
+			"definition": bson.M{"fields": vectorIndexFields(dimensions)},
+		}}},
+	}
+	if err := targetDB.Database().RunCommand(ctx, command).Err(); err != nil {
+		return fmt.Errorf("error creating vector search index: %w", err)
+	}
+	slog.Info("Created vector search index; Atlas builds it in the background", "index", vectorIndexName,
+		"field", vectorField, "dimensions", dimensions)
+	return nil
+}
+
+// Read the vector field's numDimensions from the named vector search index
+func (c mongoCollection) VectorIndexDimensions(ctx context.Context, name string) (int, error) {
+	cursor, err := c.SearchIndexes().List(ctx, options.SearchIndexes().SetName(name))
+	if err != nil {
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && cmdErr.Name == "NamespaceNotFound" {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+	if !cursor.Next(ctx) {
+		return 0, cursor.Err()
+	}
+	var index struct {
+		LatestDefinition struct {
+			Fields []struct {
+				Type          string `bson:"type"`
+				Path          string `bson:"path"`
+				NumDimensions int    `bson:"numDimensions"`
+			} `bson:"fields"`
+		} `bson:"latestDefinition"`
+	}
+	if err := cursor.Decode(&index); err != nil {
+		return 0, err
+	}
+	for _, field := range index.LatestDefinition.Fields {
+		if field.Type == "vector" && field.Path == vectorField {
+			return field.NumDimensions, nil
+		}
+	}
+	return 0, nil
+}
+
+// Check whether a search index with the given name exists on the collection
+func searchIndexExists(ctx context.Context, coll *mongo.Collection, name string) (bool, error) {
+	cursor, err := coll.SearchIndexes().List(ctx, options.SearchIndexes().SetName(name))
+	if err != nil {
+		// Collections that don't exist yet have no search indexes
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && cmdErr.Name == "NamespaceNotFound" {
+			return false, nil
+		}
+		return false, err
+	}
+	defer cursor.Close(ctx)
+	return cursor.Next(ctx), cursor.Err()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestVectorIndexDeclaresEveryFilterField(t *testing.T) {
+	declared := map[string]bool{}
+	for _, field := range vectorIndexFields(768) {
+		f := field.(bson.M)
+		if f["type"] == "filter" {
+			declared[f["path"].(string)] = true
+		}
+	}
+
+	want := []string{"tenant_id"}
+	fields := reflect.TypeOf(FilterFields{})
+	for i := 0; i < fields.NumField(); i++ {
+		name, _, _ := strings.Cut(fields.Field(i).Tag.Get("bson"), ",")
+		want = append(want, "filter."+name)
+	}
+	for _, path := range want {
+		if !declared[path] {
+			t.Errorf("vector search index doesn't declare filter path %q", path)
+		}
+	}
+}
+
+func TestVectorIndexVectorField(t *testing.T) {
+	defer func(field string) { vectorField = field }(vectorField)
+	vectorField = "vector"
+
+	vector := vectorIndexFields(1536)[0].(bson.M)
+	if vector["type"] != "vector" || vector["path"] != "vector" || vector["numDimensions"] != 1536 || vector["similarity"] != "cosine" {
+		t.Errorf("vector field = %v", vector)
+	}
+}
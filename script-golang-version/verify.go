@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Report how much of the source has embeddings, then exit
+var verifyCoverage bool
+
+// Number of example IDs listed for each problem found by -verify
+var verifySample int
+
+// Number of source IDs checked against the target with one query
+const verifyChunkSize = 1000
+
+// coverageReport compares the selected source properties with the target
+type coverageReport struct {
+	SourceCount int64                // selected source properties
+	StoredCount int64                // distinct property IDs in the target
+	Embedded    int64                // source properties with a stored document
+	MissingIDs  []primitive.ObjectID // sample of source properties without one
+
+	Dimensions        int   // vector length of the configured model, 0 when unknown
+	WrongDimensions   int64 // stored vectors of another length
+	WrongDimensionIDs []primitive.ObjectID
+}
+
+// Compare the selected source properties with the target collection,
+// keeping up to sample IDs of missing properties and of stale vectors
+func verifyEmbeddingCoverage(ctx context.Context, client *mongo.Client, sample int) (coverageReport, error) {
+	sourceDB := mongoCollection{client.Database(dbName).Collection(sourceCollection)}
+	targetDB := mongoCollection{client.Database(dbName).Collection(targetCollection)}
+	var report coverageReport
+
+	// Walk the source IDs, checking them against the target in chunks
+	cursor, err := openSourceCursor(ctx, sourceDB, bson.M{"_id": 1})
+	if err != nil {
+		return report, fmt.Errorf("error finding properties: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	checkChunk := func(ids []primitive.ObjectID) error {
+		stored, err := lookupExisting(ctx, targetDB, ids)
+		if err != nil {
+			return fmt.Errorf("error looking up embedded properties: %w", err)
+		}
+		report.SourceCount += int64(len(ids))
+		for _, id := range ids {
+			if _, ok := stored[id]; ok {
+				report.Embedded++
+			} else if len(report.MissingIDs) < sample {
+				report.MissingIDs = append(report.MissingIDs, id)
+			}
+		}
+		return nil
+	}
+
+	var ids []primitive.ObjectID
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return report, fmt.Errorf("error decoding property id: %w", err)
+		}
+		ids = append(ids, doc.ID)
+		if len(ids) >= verifyChunkSize {
+			if err := checkChunk(ids); err != nil {
+				return report, err
+			}
+			ids = nil
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return report, fmt.Errorf("cursor error: %w", err)
+	}
+	if len(ids) > 0 {
+		if err := checkChunk(ids); err != nil {
+			return report, err
+		}
+	}
+
+	report.StoredCount, err = countDistinctStored(ctx, targetDB)
+	if err != nil {
+		return report, err
+	}
+
+	// Vectors of another length come from an older model and need re-embedding
+	dimensions, err := storedVectorDimensions()
+	if err != nil {
+		return report, nil
+	}
+	report.Dimensions = dimensions
+	filter := bson.M{"$expr": bson.M{"$ne": bson.A{
+		bson.M{"$size": bson.M{"$ifNull": bson.A{"$" + vectorField, bson.A{}}}},
+		dimensions,
+	}}}
+	report.WrongDimensions, err = targetDB.CountDocuments(ctx, filter)
+	if err != nil {
+		return report, fmt.Errorf("error counting stale vectors: %w", err)
+	}
+	if report.WrongDimensions > 0 && sample > 0 {
+		idPath := storedPath("metadata._id")
+		cursor, err := targetDB.Find(ctx, filter, options.Find().SetProjection(bson.M{idPath: 1}).SetLimit(int64(sample)))
+		if err != nil {
+			return report, fmt.Errorf("error finding stale vectors: %w", err)
+		}
+		defer cursor.Close(ctx)
+		for cursor.Next(ctx) {
+			var doc PropertyWithEmbedding
+			if err := decodeStoredDocument(cursor.Current, &doc); err != nil {
+				return report, fmt.Errorf("error decoding document: %w", err)
+			}
+			report.WrongDimensionIDs = append(report.WrongDimensionIDs, doc.Metadata.ID)
+		}
+		if err := cursor.Err(); err != nil {
+			return report, fmt.Errorf("cursor error: %w", err)
+		}
+	}
+	return report, nil
+}
+
+// Count the distinct property IDs stored in the target
+func countDistinctStored(ctx context.Context, targetDB propertyCollection) (int64, error) {
+	cursor, err := targetDB.Aggregate(ctx, bson.A{
+		bson.M{"$group": bson.M{"_id": "$" + storedPath("metadata._id")}},
+		bson.M{"$count": "count"},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return 0, fmt.Errorf("error counting stored properties: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var result struct {
+		Count int64 `bson:"count"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&result); err != nil {
+			return 0, fmt.Errorf("error counting stored properties: %w", err)
+		}
+	}
+	return result.Count, cursor.Err()
+}
+
+// Print the coverage report
+func printCoverageReport(w io.Writer, report coverageReport) {
+	missing := report.SourceCount - report.Embedded
+	fmt.Fprintf(w, "Source:                 %s.%s\n", dbName, sourceCollection)
+	fmt.Fprintf(w, "Target:                 %s.%s\n", dbName, targetCollection)
+	fmt.Fprintf(w, "Source properties:      %d\n", report.SourceCount)
+	fmt.Fprintf(w, "Stored properties:      %d\n", report.StoredCount)
+	fmt.Fprintf(w, "Embedded from source:   %d\n", report.Embedded)
+	fmt.Fprintf(w, "Missing embeddings:     %d (%.2f%%)\n", missing, progressPercent(missing, report.SourceCount))
+	if len(report.MissingIDs) > 0 {
+		fmt.Fprintf(w, "  e.g. %s\n", formatObjectIDs(report.MissingIDs))
+	}
+	if report.Dimensions == 0 {
+		fmt.Fprintln(w, "Stale vectors:          unknown (set EMBED_DIMENSIONS to check)")
+		return
+	}
+	fmt.Fprintf(w, "Stale vectors:          %d (not %d dimensions)\n", report.WrongDimensions, report.Dimensions)
+	if len(report.WrongDimensionIDs) > 0 {
+		fmt.Fprintf(w, "  e.g. %s\n", formatObjectIDs(report.WrongDimensionIDs))
+	}
+}
+
+// Join ObjectIDs as hex for printing
+func formatObjectIDs(ids []primitive.ObjectID) string {
+	hex := make([]string, len(ids))
+	for i, id := range ids {
+		hex[i] = id.Hex()
+	}
+	return strings.Join(hex, ", ")
+}
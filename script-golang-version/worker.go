@@ -0,0 +1,635 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// How often the aggregate skipped count is logged with -quiet-skips
+const skipReportInterval = 1000
+
+// Server error codes for an index that exists with different options
+const (
+	indexOptionsConflict  = 85
+	indexKeySpecsConflict = 86
+)
+
+// Properties skipped across all workers because they already had embeddings
+var propertiesSkipped atomic.Int64
+
+// propertyWorker embeds and stores properties, batching inserts into the target collection
+type propertyWorker struct {
+	id             int
+	logger         *slog.Logger
+	targetDB       propertyCollection
+	failures       propertyCollection // nil when failures aren't recorded
+	aiClient       *genai.Client
+	batchSize      int
+	batchDocuments []interface{}
+	batchIDs       []primitive.ObjectID // property IDs of batchDocuments, in order
+	inserts        chan insertJob       // full batches waiting for the insert goroutine, nil until the first
+	insertsDone    chan struct{}        // closed once the insert goroutine wrote every queued batch
+	insertsPending atomic.Int64         // batches queued or being written
+	window         []Property
+	preloaded      map[primitive.ObjectID]string // stored IDs loaded up front, nil to look them up per window
+	processed      int
+	lastHandled    primitive.ObjectID
+	checkpointHeld atomic.Bool // set once a property was neither stored nor recorded as failed
+
+	// Re-embedded documents waiting to replace stored ones, only batched with -transactional
+	batchReplacements []interface{}
+	batchReplacedIDs  []primitive.ObjectID
+
+	// Properties skipped or failed by this worker, for the final summary
+	skipped         int
+	tooShort        int
+	failedEmbedding int
+	failedInsert    atomic.Int64 // also counted by the insert goroutine
+}
+
+// workerStats counts what a worker did with the properties it processed
+type workerStats struct {
+	Processed       int
+	Skipped         int
+	TooShort        int
+	FailedEmbedding int
+	FailedInsert    int
+}
+
+// Add another worker's counts
+func (s *workerStats) add(other workerStats) {
+	s.Processed += other.Processed
+	s.Skipped += other.Skipped
+	s.TooShort += other.TooShort
+	s.FailedEmbedding += other.FailedEmbedding
+	s.FailedInsert += other.FailedInsert
+}
+
+// Counts for this worker so far
+func (w *propertyWorker) stats() workerStats {
+	return workerStats{
+		Processed:       w.processed,
+		Skipped:         w.skipped,
+		TooShort:        w.tooShort,
+		FailedEmbedding: w.failedEmbedding,
+		FailedInsert:    int(w.failedInsert.Load()),
+	}
+}
+
+// Record properties that couldn't be embedded
+func (w *propertyWorker) failEmbedding(ctx context.Context, err error, ids ...primitive.ObjectID) {
+	w.failedEmbedding += len(ids)
+	w.recordFailed(ctx, failureStageEmbedding, err, ids)
+	recordFailures(len(ids))
+}
+
+// Record properties that were embedded but couldn't be stored
+func (w *propertyWorker) failInsert(ctx context.Context, err error, ids ...primitive.ObjectID) {
+	w.failedInsert.Add(int64(len(ids)))
+	w.recordFailed(ctx, failureStageInsert, err, ids)
+	recordFailures(len(ids))
+}
+
+// Write failed properties to FAILURES_COLLECTION, even once the run was
+// aborted. Failures that can't be recorded hold the checkpoint, so a resumed
+// run processes them again.
+func (w *propertyWorker) recordFailed(ctx context.Context, stage string, err error, ids []primitive.ObjectID) {
+	if len(ids) == 0 {
+		return
+	}
+	if w.failures == nil {
+		w.checkpointHeld.Store(true)
+		return
+	}
+	if err := recordFailedProperties(context.WithoutCancel(ctx), w.failures, stage, err, ids); err != nil {
+		w.logger.Warn("Error recording failed properties", "properties", len(ids), "error", err)
+		w.checkpointHeld.Store(true)
+	}
+}
+
+// Checkpoint the worker at a property, unless an earlier one was neither
+// stored nor recorded as failed
+func (w *propertyWorker) checkpoint(id primitive.ObjectID) {
+	if checkpoints == nil || id.IsZero() || w.checkpointHeld.Load() {
+		return
+	}
+	checkpoints.record(w.id, id)
+}
+
+// Create a worker writing to the store's target collection, which is nil
+// when writing to an output file without a MongoDB connection
+func newPropertyWorker(id int, store propertyStore, aiClient *genai.Client) *propertyWorker {
+	w := &propertyWorker{
+		id:        id,
+		logger:    slog.With("worker_id", id),
+		aiClient:  aiClient,
+		batchSize: batchSize,
+	}
+	if store != nil {
+		w.targetDB = store.Target()
+		if failuresCollection != "" && outputFile == "" {
+			w.failures = store.Failures()
+		}
+	}
+	return w
+}
+
+// Create the indexes used on the target collection. Called once from main
+// before the workers start, so they never race to create the same index.
+func ensureTargetIndexes(ctx context.Context, targetDB propertyCollection) error {
+	// A unique index on metadata._id makes lookups cheap and keeps a property
+	// from ever being stored twice
+	err := targetDB.CreateIndex(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: storedPath("metadata._id"), Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	var commandErr mongo.CommandError
+	switch {
+	case errors.As(err, &commandErr) && (commandErr.Code == indexOptionsConflict || commandErr.Code == indexKeySpecsConflict):
+		// Collections indexed by older versions keep working, without the guarantee
+		slog.Warn("Target already has a non-unique index on the property ID; drop it to enforce unique properties",
+			"collection", dbName+"."+targetCollection, "field", storedPath("metadata._id"))
+	case mongo.IsDuplicateKeyError(err):
+		return fmt.Errorf("error creating unique index: the target already stores some properties more than once, remove the duplicates first: %w", err)
+	case err != nil:
+		return fmt.Errorf("error creating index: %w", err)
+	}
+
+	// Create index on tenant_id so tenant-scoped queries stay cheap
+	err = targetDB.CreateIndex(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "tenant_id", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("error creating tenant index: %w", err)
+	}
+	return nil
+}
+
+// Record a property that already has embeddings
+func (w *propertyWorker) skip(property Property) {
+	w.skipped++
+	skipped := propertiesSkipped.Add(1)
+	if !quietSkips {
+		w.logger.Debug("Property already has embeddings, skipping", "property_id", property.ID.Hex())
+	} else if skipped%skipReportInterval == 0 {
+		slog.Info("Skipped properties that already had embeddings so far", "skipped_count", skipped)
+	}
+}
+
+// Queue a property for processing. Properties are processed in windows so
+// that one query can check which of them already have embeddings.
+func (w *propertyWorker) process(ctx context.Context, property Property) {
+	w.window = append(w.window, property)
+	if len(w.window) >= existenceCheckWindow {
+		w.processWindow(ctx)
+	}
+}
+
+// Check the queued properties against the target collection, then embed the
+// ones that need it in batches and store them
+func (w *propertyWorker) processWindow(ctx context.Context) {
+	if len(w.window) == 0 {
+		return
+	}
+	ids := make([]primitive.ObjectID, len(w.window))
+	for i, property := range w.window {
+		ids[i] = property.ID
+	}
+	// An output file starts empty, so there's nothing to look up
+	var existing map[primitive.ObjectID]string
+	switch {
+	case outputFile != "":
+	case w.preloaded != nil:
+		existing = w.preloaded
+	default:
+		var err error
+		existing, err = lookupExisting(ctx, w.targetDB, ids)
+		if err != nil {
+			w.logger.Error("Error checking for existing properties", "error", err)
+		}
+	}
+	var pending []pendingEmbedding
+	for _, property := range w.window {
+		if p, ok := w.prepareProperty(ctx, property, existing); ok {
+			pending = append(pending, p)
+		}
+	}
+	w.embedPending(ctx, pending)
+	for _, property := range w.window {
+		w.handled(property.ID)
+	}
+	w.window = nil
+}
+
+// Find which of the given properties already have a stored document, mapped
+// to their stored description_text
+func lookupExisting(ctx context.Context, targetDB propertyCollection, ids []primitive.ObjectID) (map[primitive.ObjectID]string, error) {
+	idPath := storedPath("metadata._id")
+	existenceQueries.Add(1)
+	cursor, err := targetDB.Find(ctx, bson.M{idPath: bson.M{"$in": ids}},
+		options.Find().SetProjection(bson.M{idPath: 1, "description_text": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	existing := make(map[primitive.ObjectID]string, len(ids))
+	for cursor.Next(ctx) {
+		var doc PropertyWithEmbedding
+		if err := decodeStoredDocument(cursor.Current, &doc); err != nil {
+			return nil, err
+		}
+		existing[doc.Metadata.ID] = doc.DescriptionText
+	}
+	return existing, cursor.Err()
+}
+
+// A property whose embedding input is ready, waiting for its batch to be embedded
+type pendingEmbedding struct {
+	property        Property
+	description     string
+	inputs          []string // one per chunk of the embedding input
+	summary         string
+	translated      string
+	replaceExisting bool
+}
+
+// Describe a single property and build its embedding input, reporting false
+// when it doesn't need embedding
+func (w *propertyWorker) prepareProperty(ctx context.Context, property Property, existing map[primitive.ObjectID]string) (pendingEmbedding, bool) {
+	ctx = withPropertyID(ctx, property.ID)
+	w.processed++
+	propertiesProcessed.Add(1)
+	metrics.Count(metricPropertiesProcessed, 1)
+	if w.processed%10 == 0 {
+		w.logger.Debug("Processed properties so far", "processed_count", w.processed)
+	}
+	observeUpdatedAt(property.UpdatedAt)
+
+	// Resolve contradictory area values before describing the property
+	if !resolveAreaConflict(&property, areaConflictPolicy) {
+		return pendingEmbedding{}, false
+	}
+
+	// Create rich description for embedding, unless the source provides one
+	description := embeddingText(&property)
+
+	// Low-signal descriptions aren't worth a vector
+	if !descriptionLongEnough(description) {
+		w.tooShort++
+		recordTooShort(&property, description)
+		return pendingEmbedding{}, false
+	}
+
+	// Check if this property already has embeddings
+	replaceExisting := false
+	if storedDescription, ok := existing[property.ID]; ok {
+		switch {
+		case forceReembed:
+			w.logger.Debug("Property already has embeddings, re-embedding", "property_id", property.ID.Hex())
+		case !skipUnchanged || storedDescription == description:
+			w.skip(property)
+			return pendingEmbedding{}, false
+		default:
+			w.logger.Debug("Property description changed, re-embedding", "property_id", property.ID.Hex())
+		}
+		replaceExisting = true
+	}
+
+	// A dry run stops once the description is generated
+	if dryRun {
+		w.dryRunProperty(property, description)
+		return pendingEmbedding{}, false
+	}
+
+	// Optionally summarize the property with the generative model
+	embeddingInput := description
+	var summary string
+	var err error
+	if summarize {
+		summary, err = generateSummary(ctx, description, w.aiClient, embedMaxRetries, embedInitialBackoff)
+		if err != nil {
+			w.logger.Warn("Error summarizing property, using the description only",
+				"property_id", property.ID.Hex(), "error", err)
+		} else {
+			embeddingInput = summaryEmbeddingInput(description, summary)
+		}
+	}
+
+	// Optionally translate the embedding input for cross-lingual retrieval
+	var translated string
+	if translateTo != "" {
+		translated, err = translateDescription(ctx, embeddingInput, w.aiClient, embedMaxRetries, embedInitialBackoff)
+		if err != nil {
+			w.logger.Warn("Error translating property, keeping the original text",
+				"property_id", property.ID.Hex(), "error", err)
+		} else if !translateKeepOriginal {
+			embeddingInput = translated
+		}
+	}
+
+	// Keep the input within the model's limit
+	inputs, shortened := splitLongText(embeddingInput)
+	if shortened {
+		action := "truncated"
+		switch longTextStrategy {
+		case longTextChunkAverage:
+			action = fmt.Sprintf("split into %d chunks", len(inputs))
+		case longTextAPI:
+			action = "likely truncated by the API"
+		}
+		w.logger.Info("Embedding input over EMBED_MAX_TOKENS, "+action, "property_id", property.ID.Hex(),
+			"estimated_tokens", estimateTokens(embeddingInput), "max_tokens", embedMaxTokens)
+	}
+
+	return pendingEmbedding{
+		property:        property,
+		description:     description,
+		inputs:          inputs,
+		summary:         summary,
+		translated:      translated,
+		replaceExisting: replaceExisting,
+	}, true
+}
+
+// Embed the prepared properties and store them. The properties are split into
+// batches that up to EMBED_CONCURRENCY goroutines embed at once; the results
+// are then stored in their original order on the worker's goroutine, so
+// inserts and checkpoints behave as if the batches had run one after another.
+func (w *propertyWorker) embedPending(ctx context.Context, pending []pendingEmbedding) {
+	if len(pending) == 0 {
+		return
+	}
+	batches := embeddingBatches(pending, embedConcurrency)
+	embeddings := make([][][]float32, len(batches))
+	errs := make([]error, len(batches))
+
+	// Every request still goes through the shared rate and concurrency limits
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for n := min(embedConcurrency, len(batches)); n > 0; n-- {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				embeddings[i], errs[i] = generateEmbeddingsBatch(ctx, batchInputs(batches[i]))
+			}
+		}()
+	}
+	for i := range batches {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i, batch := range batches {
+		// A batch request fails as a whole, so embed its properties one by
+		// one to store the good ones and record only those that fail
+		if errs[i] != nil && retryEachProperty(ctx, errs[i], batch) {
+			w.logger.Warn("Error generating embeddings, embedding the batch's properties one by one",
+				"properties", len(batch), "error", errs[i])
+			for _, p := range batch {
+				vectors, err := generateEmbeddingsBatch(ctx, p.inputs)
+				w.storeEmbedded(ctx, []pendingEmbedding{p}, vectors, err)
+			}
+			continue
+		}
+		w.storeEmbedded(ctx, batch, embeddings[i], errs[i])
+	}
+}
+
+// Whether a failed batch is worth embedding again property by property: not
+// when the run is aborting, when there's only one property, or when a
+// dimension mismatch would fail every property alike
+func retryEachProperty(ctx context.Context, err error, batch []pendingEmbedding) bool {
+	return len(batch) > 1 && ctx.Err() == nil && !errors.Is(err, errDimensionMismatch)
+}
+
+// Store a batch of properties with the vectors embedded for their inputs, or
+// record them as failed when embedding them failed
+func (w *propertyWorker) storeEmbedded(ctx context.Context, batch []pendingEmbedding, vectors [][]float32, err error) {
+	if err != nil {
+		if errors.Is(err, errDimensionMismatch) && dimensionMismatchPolicy == dimensionMismatchFail {
+			abortRun(err)
+		}
+		// Cut short by an abort: not a failure, the resumed run embeds them
+		if ctx.Err() != nil {
+			w.logger.Warn("Embedding canceled", "properties", len(batch), "error", err)
+			w.checkpointHeld.Store(true)
+			return
+		}
+		w.logger.Error("Error generating embeddings", "properties", len(batch), "error", err)
+		w.failEmbedding(ctx, err, pendingIDs(batch)...)
+		return
+	}
+	metrics.Count(metricEmbeddingsGenerated, int64(len(batch)))
+
+	// Chunked inputs are averaged back into one vector per property
+	for _, p := range batch {
+		embedding := averageEmbeddings(vectors[:len(p.inputs)])
+		vectors = vectors[len(p.inputs):]
+		w.storeProperty(withPropertyID(ctx, p.property.ID), p, embedding)
+	}
+}
+
+// Split prepared properties into batches of at most EMBED_BATCH_SIZE inputs,
+// made small enough that there's one batch for each of the concurrent
+// requests. A property's chunks always stay in the same batch.
+func embeddingBatches(pending []pendingEmbedding, concurrency int) [][]pendingEmbedding {
+	total := 0
+	for _, p := range pending {
+		total += len(p.inputs)
+	}
+	size := embedBatchSize
+	if concurrency > 1 {
+		size = max(1, min(size, (total+concurrency-1)/concurrency))
+	}
+
+	var batches [][]pendingEmbedding
+	start, inputs := 0, 0
+	for i, p := range pending {
+		if inputs > 0 && inputs+len(p.inputs) > size {
+			batches = append(batches, pending[start:i])
+			start, inputs = i, 0
+		}
+		inputs += len(p.inputs)
+	}
+	return append(batches, pending[start:])
+}
+
+// The property IDs of a batch, in order
+func pendingIDs(batch []pendingEmbedding) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, len(batch))
+	for i, p := range batch {
+		ids[i] = p.property.ID
+	}
+	return ids
+}
+
+// The embedding inputs of a batch of properties, in order
+func batchInputs(batch []pendingEmbedding) []string {
+	var inputs []string
+	for _, p := range batch {
+		inputs = append(inputs, p.inputs...)
+	}
+	return inputs
+}
+
+// Store a single embedded property
+func (w *propertyWorker) storeProperty(ctx context.Context, p pendingEmbedding, embedding []float32) {
+	property := p.property
+
+	// Optionally embed individual fields for query-time weighting
+	fieldEmbeddings, err := generateFieldEmbeddings(ctx, &property)
+	if err != nil {
+		w.logger.Error("Error generating field embeddings", "property_id", property.ID.Hex(), "error", err)
+		w.failEmbedding(ctx, err, property.ID)
+		return
+	}
+
+	// Create document with metadata and embeddings
+	now := time.Now()
+	documentWithEmbedding := PropertyWithEmbedding{
+		Metadata:         projectProperty(&property, metadataFields),
+		TenantID:         propertyTenant(&property),
+		SourceDB:         dbName,
+		SourceCollection: sourceCollection,
+		Filter:           extractFilterFields(&property),
+		DescriptionText:  p.description,
+		Summary:          p.summary,
+		FieldEmbeddings:  fieldEmbeddings,
+		Embeddings:       embedding,
+		Model:            embeddingModelLabel(),
+		Dimensions:       len(embedding),
+		EmbeddedAt:       now,
+		CreatedAt:        now,
+	}
+
+	// Tag translated documents, or embed the translation alongside the original
+	if p.translated != "" && !translateKeepOriginal {
+		documentWithEmbedding.Language = translateTo
+	} else if p.translated != "" {
+		translatedEmbedding, err := generateEmbedding(ctx, p.translated)
+		if err != nil {
+			w.logger.Error("Error embedding translation", "property_id", property.ID.Hex(), "error", err)
+		} else {
+			documentWithEmbedding.Translation = &Translation{
+				Language:   translateTo,
+				Text:       p.translated,
+				Embeddings: translatedEmbedding,
+			}
+		}
+	}
+
+	// Output files get the document as JSON, one per line
+	if outputWriter != nil {
+		if err := outputWriter.write(documentWithEmbedding); err != nil {
+			metrics.Count(metricErrors, 1, "type:insert")
+			w.logger.Error("Error writing property", "property_id", property.ID.Hex(), "error", err)
+			w.failInsert(ctx, err, property.ID)
+			return
+		}
+		metrics.Count(metricDocumentsInserted, 1)
+		documentsAdded.Add(1)
+		return
+	}
+
+	stored, err := storedDocument(documentWithEmbedding)
+	if err != nil {
+		w.logger.Error("Error encoding property", "property_id", property.ID.Hex(), "error", err)
+		w.failInsert(ctx, err, property.ID)
+		return
+	}
+
+	// Re-embedded properties replace their stored document in place, or
+	// recreate it if it was deleted in the meantime. Transactions write them
+	// with the batch, so the batch's checkpoint never gets ahead of them.
+	if p.replaceExisting && useTransactions {
+		w.batchReplacements = append(w.batchReplacements, stored)
+		w.batchReplacedIDs = append(w.batchReplacedIDs, property.ID)
+	} else if p.replaceExisting {
+		result, err := w.targetDB.ReplaceOne(ctx, bson.M{storedPath("metadata._id"): property.ID}, stored,
+			options.Replace().SetUpsert(true))
+		switch {
+		case err != nil:
+			metrics.Count(metricErrors, 1, "type:insert")
+			w.logger.Error("Error replacing property", "property_id", property.ID.Hex(), "error", err)
+			w.failInsert(ctx, err, property.ID)
+		case result.UpsertedCount > 0:
+			documentsAdded.Add(1)
+		default:
+			documentsUpdated.Add(1)
+		}
+		return
+	} else {
+		w.batchDocuments = append(w.batchDocuments, stored)
+		w.batchIDs = append(w.batchIDs, property.ID)
+	}
+
+	// Insert in batches, in the background while the next properties are embedded
+	if w.batchLen() >= w.batchSize {
+		w.queueInsert(ctx, false)
+	}
+}
+
+// Number of documents collected for the next batch
+func (w *propertyWorker) batchLen() int {
+	return len(w.batchDocuments) + len(w.batchReplacements)
+}
+
+// Note a property as handled, and checkpoint it once nothing before it is
+// still waiting to be inserted. While batches are queued, the insert
+// goroutine checkpoints each one once it's written.
+func (w *propertyWorker) handled(id primitive.ObjectID) {
+	w.lastHandled = id
+	if w.batchLen() == 0 && w.insertsPending.Load() == 0 {
+		w.checkpoint(id)
+	}
+}
+
+// Load the stored IDs within the worker's range when EXISTENCE_CHECK=preload
+func (w *propertyWorker) preloadExisting(ctx context.Context, r idRange) error {
+	if existenceCheckMode != existenceCheckPreload || outputFile != "" {
+		return nil
+	}
+	start := time.Now()
+	existing, err := preloadExisting(ctx, w.targetDB, r)
+	if err != nil {
+		return fmt.Errorf("error preloading existing properties: %w", err)
+	}
+	w.preloaded = existing
+	w.logger.Info("Preloaded existing properties", "existing_count", len(existing), "duration_ms", durationMillis(start))
+	return nil
+}
+
+// Process the last window, insert any remaining documents and wait for all
+// queued batches to be written. Queued properties are dropped if the run was
+// aborted, but collected documents are still inserted.
+func (w *propertyWorker) flush(ctx context.Context) {
+	if ctx.Err() == nil {
+		w.processWindow(ctx)
+	}
+	w.window = nil
+	final := w.batchLen() > 0
+	if final {
+		w.queueInsert(ctx, true)
+	}
+	w.waitForInserts()
+
+	// Without a final batch, properties handled after the last queued one
+	// weren't checkpointed yet
+	if !final {
+		w.checkpoint(w.lastHandled)
+	}
+}
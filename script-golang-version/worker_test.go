@@ -0,0 +1,490 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc/codes"
+)
+
+// stubProvider embeds every text as a fixed vector, failing texts that contain fail
+type stubProvider struct {
+	fail string
+	err  error
+}
+
+func (p stubProvider) Model() string   { return "stub" }
+func (p stubProvider) Dimensions() int { return 3 }
+
+func (p stubProvider) Embed(ctx context.Context, text string) ([]float32, error) {
+	if p.fail != "" && strings.Contains(text, p.fail) {
+		return nil, p.err
+	}
+	return []float32{1, 0, 0}, nil
+}
+
+// Like the real APIs, one failing text fails the whole request
+func (p stubProvider) EmbedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		values, err := p.Embed(ctx, text)
+		if err != nil {
+			return nil, err
+		}
+		embeddings[i] = values
+	}
+	return embeddings, nil
+}
+
+// Configure workers for tests: the given provider, no retries and no cache,
+// restoring the previous configuration when the test ends
+func setupWorkerTest(t testing.TB, provider EmbeddingProvider, batch int) {
+	t.Helper()
+	saved := struct {
+		provider                                 EmbeddingProvider
+		batch, window, embedBatch, concurrency   int
+		retries, cacheSize, queueSize, minLength int
+		timeout                                  time.Duration
+		failures, output                         string
+		transactions                             bool
+	}{embeddingProvider, batchSize, existenceCheckWindow, embedBatchSize, embedConcurrency,
+		embedMaxRetries, embedCacheSize, insertQueueSize, minDescriptionLength,
+		embedTimeout, failuresCollection, outputFile, useTransactions}
+	t.Cleanup(func() {
+		embeddingProvider, batchSize, existenceCheckWindow, embedBatchSize, embedConcurrency = saved.provider,
+			saved.batch, saved.window, saved.embedBatch, saved.concurrency
+		embedMaxRetries, embedCacheSize, insertQueueSize, minDescriptionLength = saved.retries,
+			saved.cacheSize, saved.queueSize, saved.minLength
+		embedTimeout, failuresCollection, outputFile, useTransactions = saved.timeout,
+			saved.failures, saved.output, saved.transactions
+	})
+
+	embeddingProvider = provider
+	batchSize, existenceCheckWindow, embedBatchSize, embedConcurrency = batch, 100, 100, 1
+	embedMaxRetries, embedCacheSize, insertQueueSize, minDescriptionLength = 1, 0, 2, 0
+	embedTimeout, failuresCollection, outputFile, useTransactions = time.Second, "failures", "", false
+}
+
+// Properties with their own embedding text, so no description is generated
+func testProperties(texts ...string) []Property {
+	properties := make([]Property, len(texts))
+	for i, text := range texts {
+		properties[i] = Property{ID: primitive.NewObjectID(), EmbedText: text}
+	}
+	return properties
+}
+
+// Run a worker over the properties and flush it
+func runWorker(store *memoryStore, properties []Property) *propertyWorker {
+	ctx := context.Background()
+	w := newPropertyWorker(1, store, nil)
+	for _, property := range properties {
+		w.process(ctx, property)
+	}
+	w.flush(ctx)
+	return w
+}
+
+func TestWorkerSkipsStoredProperties(t *testing.T) {
+	setupWorkerTest(t, stubProvider{}, 10)
+	store := newMemoryStore()
+	properties := testProperties("one", "two", "three")
+
+	// The first property is already stored
+	first := runWorker(store, properties[:1])
+	if got := first.stats(); got.Processed != 1 || got.Skipped != 0 {
+		t.Fatalf("first run stats = %+v, want 1 processed and none skipped", got)
+	}
+
+	w := runWorker(store, properties)
+	if got := w.stats(); got.Processed != 3 || got.Skipped != 1 {
+		t.Errorf("stats = %+v, want 3 processed and 1 skipped", got)
+	}
+	ids := store.target.ids(storedPath("metadata._id"))
+	want := []primitive.ObjectID{properties[0].ID, properties[1].ID, properties[2].ID}
+	if !slices.Equal(ids, want) {
+		t.Errorf("stored %v, want %v", ids, want)
+	}
+}
+
+func TestWorkerInsertsInBatches(t *testing.T) {
+	setupWorkerTest(t, stubProvider{}, 2)
+	store := newMemoryStore()
+
+	runWorker(store, testProperties("a", "b", "c", "d", "e"))
+
+	var sizes []int
+	for _, batch := range store.target.inserts {
+		sizes = append(sizes, len(batch))
+	}
+	if want := []int{2, 2, 1}; !slices.Equal(sizes, want) {
+		t.Errorf("batch sizes = %v, want %v", sizes, want)
+	}
+}
+
+func TestWorkerRecordsEmbeddingFailures(t *testing.T) {
+	setupWorkerTest(t, stubProvider{fail: "bad", err: errors.New("invalid argument")}, 10)
+	store := newMemoryStore()
+	properties := testProperties("bad one", "bad two")
+
+	w := runWorker(store, properties)
+
+	if got := w.stats(); got.FailedEmbedding != 2 || got.FailedInsert != 0 {
+		t.Errorf("stats = %+v, want 2 embedding failures", got)
+	}
+	if ids := store.target.ids(storedPath("metadata._id")); len(ids) != 0 {
+		t.Errorf("stored %d properties, want none", len(ids))
+	}
+	for _, property := range properties {
+		entry, ok := store.failures.failures[property.ID]
+		if !ok {
+			t.Errorf("property %s not recorded as failed", property.ID.Hex())
+			continue
+		}
+		if entry["stage"] != failureStageEmbedding {
+			t.Errorf("stage = %v, want %s", entry["stage"], failureStageEmbedding)
+		}
+	}
+	if w.checkpointHeld.Load() {
+		t.Error("recorded failures held the checkpoint")
+	}
+}
+
+func TestWorkerRecordsInsertFailures(t *testing.T) {
+	setupWorkerTest(t, stubProvider{}, 2)
+	store := newMemoryStore()
+	store.target.insertErr = errors.New("connection reset")
+	properties := testProperties("a", "b", "c")
+
+	w := runWorker(store, properties)
+
+	if got := w.stats(); got.FailedInsert != 3 || got.FailedEmbedding != 0 {
+		t.Errorf("stats = %+v, want 3 insert failures", got)
+	}
+	for _, property := range properties {
+		if entry := store.failures.failures[property.ID]; entry["stage"] != failureStageInsert {
+			t.Errorf("property %s failure = %v, want stage %s", property.ID.Hex(), entry, failureStageInsert)
+		}
+	}
+}
+
+func TestWorkerHoldsCheckpointWithoutFailuresCollection(t *testing.T) {
+	setupWorkerTest(t, stubProvider{fail: "bad", err: errors.New("invalid argument")}, 10)
+	failuresCollection = ""
+
+	w := runWorker(newMemoryStore(), testProperties("bad"))
+
+	if !w.checkpointHeld.Load() {
+		t.Error("an unrecorded failure didn't hold the checkpoint")
+	}
+}
+
+// A batch where items 3 and 7 fail stores the other eight and records only those two
+func TestWorkerStoresTheRestOfAPartlyFailedBatch(t *testing.T) {
+	setupWorkerTest(t, stubProvider{fail: "bad", err: errors.New("invalid argument")}, 100)
+	store := newMemoryStore()
+	texts := make([]string, 10)
+	for i := range texts {
+		texts[i] = fmt.Sprintf("item %d", i+1)
+	}
+	texts[2], texts[6] = "item 3 bad", "item 7 bad"
+	properties := testProperties(texts...)
+
+	w := runWorker(store, properties)
+
+	if got := w.stats(); got.FailedEmbedding != 2 || got.FailedInsert != 0 {
+		t.Errorf("stats = %+v, want 2 embedding failures", got)
+	}
+	var want []primitive.ObjectID
+	for i, property := range properties {
+		if i != 2 && i != 6 {
+			want = append(want, property.ID)
+		}
+	}
+	if ids := store.target.ids(storedPath("metadata._id")); !slices.Equal(ids, want) {
+		t.Errorf("stored %v, want every property but items 3 and 7", ids)
+	}
+	if len(store.failures.failures) != 2 {
+		t.Errorf("recorded %d failures, want 2", len(store.failures.failures))
+	}
+	for _, property := range []Property{properties[2], properties[6]} {
+		if entry := store.failures.failures[property.ID]; entry["stage"] != failureStageEmbedding {
+			t.Errorf("property %s failure = %v, want stage %s", property.EmbedText, entry, failureStageEmbedding)
+		}
+	}
+	if w.checkpointHeld.Load() {
+		t.Error("recorded failures held the checkpoint")
+	}
+}
+
+// Store documents for properties in a target, as a previous run would have
+func storeProperties(t testing.TB, target *memoryCollection, properties ...Property) {
+	t.Helper()
+	var documents []interface{}
+	for _, property := range properties {
+		stored, err := storedDocument(PropertyWithEmbedding{Metadata: property, DescriptionText: property.EmbedText})
+		if err != nil {
+			t.Fatal(err)
+		}
+		documents = append(documents, stored)
+	}
+	if _, err := target.InsertMany(context.Background(), documents); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLookupExisting(t *testing.T) {
+	properties := testProperties("a", "b", "c", "d")
+	target := &memoryCollection{}
+	storeProperties(t, target, properties[0], properties[2])
+
+	existing, err := lookupExisting(context.Background(), target, propertyIDs(properties))
+	if err != nil {
+		t.Fatalf("lookupExisting: %v", err)
+	}
+	want := map[primitive.ObjectID]string{properties[0].ID: "a", properties[2].ID: "c"}
+	if len(existing) != len(want) {
+		t.Fatalf("existing = %v, want %v", existing, want)
+	}
+	for id, description := range want {
+		if existing[id] != description {
+			t.Errorf("existing[%s] = %q, want %q", id.Hex(), existing[id], description)
+		}
+	}
+}
+
+// One existence query per EXISTENCE_CHECK_WINDOW properties, skipping exactly the stored ones
+func TestWorkerChecksExistenceOncePerWindow(t *testing.T) {
+	setupWorkerTest(t, stubProvider{}, 10)
+	existenceCheckWindow = 3
+	store := newMemoryStore()
+	properties := testProperties("a", "b", "c", "d", "e", "f", "g")
+	storeProperties(t, store.target, properties[1], properties[5])
+
+	queries := existenceQueries.Load()
+	w := runWorker(store, properties)
+
+	if got := existenceQueries.Load() - queries; got != 3 {
+		t.Errorf("sent %d existence queries for 7 properties, want 3", got)
+	}
+	if got := w.stats(); got.Skipped != 2 || got.Processed != 7 {
+		t.Errorf("stats = %+v, want 7 processed and 2 skipped", got)
+	}
+	if ids := store.target.ids(storedPath("metadata._id")); len(ids) != 7 {
+		t.Errorf("target holds %d properties, want 7", len(ids))
+	}
+}
+
+// The IDs of properties, in order
+func propertyIDs(properties []Property) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, len(properties))
+	for i, property := range properties {
+		ids[i] = property.ID
+	}
+	return ids
+}
+
+// Re-embedding an updated property replaces its stored document instead of adding another
+func TestWorkerReplacesReembeddedProperties(t *testing.T) {
+	setupWorkerTest(t, stubProvider{}, 10)
+	defer func(force bool) { forceReembed = force }(forceReembed)
+	store := newMemoryStore()
+	properties := testProperties("old description", "unchanged")
+	runWorker(store, properties)
+
+	forceReembed = true
+	properties[0].EmbedText = "new description"
+	w := runWorker(store, properties)
+
+	if got := w.stats(); got.Skipped != 0 || got.Processed != 2 {
+		t.Errorf("stats = %+v, want 2 processed and none skipped", got)
+	}
+	if ids := store.target.ids(storedPath("metadata._id")); !slices.Equal(ids, propertyIDs(properties)) {
+		t.Fatalf("stored %v, want each property once", ids)
+	}
+	existing, err := lookupExisting(context.Background(), store.target, propertyIDs(properties[:1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := existing[properties[0].ID]; got != "new description" {
+		t.Errorf("stored description = %q, want the new one", got)
+	}
+}
+
+// An unordered insert that rejects one document still stores the rest of its batch
+func TestWorkerStoresTheRestOfAPartlyFailedInsert(t *testing.T) {
+	setupWorkerTest(t, stubProvider{}, 10)
+	store := newMemoryStore()
+	properties := testProperties("a", "b", "c")
+	store.target.rejectInserts = map[primitive.ObjectID]bool{properties[1].ID: true}
+	added := documentsAdded.Load()
+
+	w := runWorker(store, properties)
+
+	if got := w.stats(); got.FailedInsert != 1 {
+		t.Errorf("stats = %+v, want 1 insert failure", got)
+	}
+	want := []primitive.ObjectID{properties[0].ID, properties[2].ID}
+	if ids := store.target.ids(storedPath("metadata._id")); !slices.Equal(ids, want) {
+		t.Errorf("stored %v, want %v", ids, want)
+	}
+	if got := documentsAdded.Load() - added; got != 2 {
+		t.Errorf("counted %d documents added, want 2", got)
+	}
+	if len(store.failures.failures) != 1 || store.failures.failures[properties[1].ID]["stage"] != failureStageInsert {
+		t.Errorf("failures = %v, want only %s at stage %s", store.failures.failures, properties[1].ID.Hex(), failureStageInsert)
+	}
+}
+
+// A model answering with the wrong dimension never gets its vectors stored:
+// the fail policy aborts the run and the skip policy records the properties
+func TestWorkerRejectsMismatchedDimensions(t *testing.T) {
+	defer func(dimensions int, policy string, abort context.CancelCauseFunc) {
+		embedDimensions, dimensionMismatchPolicy, abortRun = dimensions, policy, abort
+	}(embedDimensions, dimensionMismatchPolicy, abortRun)
+
+	tests := []struct {
+		policy  string
+		aborted bool
+	}{
+		{policy: dimensionMismatchFail, aborted: true},
+		{policy: dimensionMismatchSkip, aborted: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			setupWorkerTest(t, stubProvider{}, 10)
+			// stubProvider's vectors have 3 dimensions
+			embedDimensions, dimensionMismatchPolicy = 4, tt.policy
+			var abortErr error
+			abortRun = func(err error) { abortErr = err }
+			store := newMemoryStore()
+			properties := testProperties("a", "b")
+
+			w := runWorker(store, properties)
+
+			if aborted := errors.Is(abortErr, errDimensionMismatch); aborted != tt.aborted {
+				t.Errorf("aborted with %v, want aborted %t", abortErr, tt.aborted)
+			}
+			if ids := store.target.ids(storedPath("metadata._id")); len(ids) != 0 {
+				t.Errorf("stored %v, want nothing", ids)
+			}
+			if got := w.stats(); got.FailedEmbedding != 2 {
+				t.Errorf("stats = %+v, want 2 embedding failures", got)
+			}
+			for _, property := range properties {
+				if entry := store.failures.failures[property.ID]; entry["stage"] != failureStageEmbedding {
+					t.Errorf("property %s failure = %v, want stage %s", property.ID.Hex(), entry, failureStageEmbedding)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateEmbeddingsChecksDimensions(t *testing.T) {
+	setupWorkerTest(t, stubProvider{}, 10)
+	defer func(dimensions int) { embedDimensions = dimensions }(embedDimensions)
+
+	tests := []struct {
+		dimensions int
+		mismatch   bool
+	}{
+		{dimensions: 0, mismatch: false},
+		{dimensions: 3, mismatch: false},
+		{dimensions: 2, mismatch: true},
+		{dimensions: 768, mismatch: true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.dimensions), func(t *testing.T) {
+			embedDimensions = tt.dimensions
+			embeddings, err := generateEmbeddings(context.Background(), []string{"a", "b"})
+			if mismatch := errors.Is(err, errDimensionMismatch); mismatch != tt.mismatch {
+				t.Fatalf("generateEmbeddings error = %v, want mismatch %t", err, tt.mismatch)
+			}
+			if tt.mismatch && embeddings != nil {
+				t.Errorf("returned %d embeddings along with the mismatch", len(embeddings))
+			}
+		})
+	}
+}
+
+// -skip-unchanged re-embeds only properties whose description changed since
+// they were stored; without it every stored property is skipped
+func TestWorkerSkipUnchanged(t *testing.T) {
+	defer func(skip bool) { skipUnchanged = skip }(skipUnchanged)
+
+	tests := []struct {
+		skipUnchanged bool
+		skipped       int
+		want          []string
+	}{
+		{skipUnchanged: false, skipped: 2, want: []string{"old description", "unchanged"}},
+		{skipUnchanged: true, skipped: 1, want: []string{"new description", "unchanged"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.skipUnchanged), func(t *testing.T) {
+			setupWorkerTest(t, stubProvider{}, 10)
+			skipUnchanged = tt.skipUnchanged
+			store := newMemoryStore()
+			properties := testProperties("old description", "unchanged")
+			runWorker(store, properties)
+
+			properties[0].EmbedText = "new description"
+			w := runWorker(store, properties)
+
+			if got := w.stats(); got.Skipped != tt.skipped {
+				t.Errorf("stats = %+v, want %d skipped", got, tt.skipped)
+			}
+			if ids := store.target.ids(storedPath("metadata._id")); !slices.Equal(ids, propertyIDs(properties)) {
+				t.Fatalf("stored %v, want each property once", ids)
+			}
+			existing, err := lookupExisting(context.Background(), store.target, propertyIDs(properties))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, property := range properties {
+				if got := existing[property.ID]; got != tt.want[i] {
+					t.Errorf("property %d stored description = %q, want %q", i+1, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// A dry run describes new properties without embedding or writing anything
+func TestWorkerDryRun(t *testing.T) {
+	provider := &statusProvider{code: codes.Internal}
+	setupWorkerTest(t, provider, 2)
+	defer func(dry bool) { dryRun = dry }(dryRun)
+	store := newMemoryStore()
+	properties := testProperties("stored", "new one", "new two", "new three")
+	storeProperties(t, store.target, properties[0])
+	dryRun = true
+	wouldEmbed, tokens := propertiesWouldEmbed.Load(), dryRunTokens.Load()
+
+	w := runWorker(store, properties)
+
+	if got := w.stats(); got.Skipped != 1 || got.FailedEmbedding != 0 || got.FailedInsert != 0 {
+		t.Errorf("stats = %+v, want 1 skipped and no failures", got)
+	}
+	if provider.requests != 0 {
+		t.Errorf("sent %d embedding requests, want none", provider.requests)
+	}
+	if got := propertiesWouldEmbed.Load() - wouldEmbed; got != 3 {
+		t.Errorf("counted %d properties to embed, want 3", got)
+	}
+	if got := dryRunTokens.Load() - tokens; got <= 0 {
+		t.Errorf("estimated %d tokens, want some", got)
+	}
+	if ids := store.target.ids(storedPath("metadata._id")); len(ids) != 1 || len(store.target.inserts) != 1 {
+		t.Errorf("target holds %v after %d inserts, want only the stored property", ids, len(store.target.inserts))
+	}
+	if len(store.failures.failures) != 0 {
+		t.Errorf("recorded failures %v, want none", store.failures.failures)
+	}
+}